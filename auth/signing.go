@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+const (
+	defaultSigningKeysFile = "signing.xml"
+
+	// minSigningKeySize min size in bytes of secret of a signing key
+	minSigningKeySize = 32
+)
+
+// SigningKeysConfig signing keys from xml file
+type SigningKeysConfig struct {
+	XMLName xml.Name     `xml:"signing_keys"`
+	Keys    []SigningKey `xml:"key"`
+}
+
+// SigningKey holds a key URLs are signed with. New URLs are signed with
+// the active key, the others verify URLs signed before it was rotated
+type SigningKey struct {
+	ID     string `xml:"id,attr"`
+	Active bool   `xml:"active,attr"`
+	Secret string `xml:",chardata"`
+}
+
+// Keyring holds keys URLs are signed with, signing is
+// disabled while it has none
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring returns new Keyring without keys
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Load loads keys from configuration file, it is called again to rotate
+// them. Keyring is emptied if the file does not exist. Keys in use are
+// kept if the file is not valid
+func (kr *Keyring) Load(filePath string) error {
+	path := filepath.Join(filePath, defaultSigningKeysFile)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kr.Set(nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := SigningKeysConfig{}
+	if err := xml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf(errors.ErrParseFile.Error(), path)
+	}
+	return kr.Set(cfg.Keys)
+}
+
+// Set replaces keys of kr, one of them must be active if there are any
+func (kr *Keyring) Set(keys []SigningKey) error {
+	m := make(map[string][]byte, len(keys))
+	active := ""
+	for _, k := range keys {
+		secret := strings.TrimSpace(k.Secret)
+		if len(k.ID) == 0 || len(secret) < minSigningKeySize || m[k.ID] != nil || (k.Active && len(active) > 0) {
+			return fmt.Errorf(errors.ErrInvalidSigningKey.Error(), k.ID)
+		}
+
+		m[k.ID] = []byte(secret)
+		if k.Active {
+			active = k.ID
+		}
+	}
+	if len(keys) > 0 && len(active) == 0 {
+		return fmt.Errorf(errors.ErrInvalidSigningKey.Error(), "")
+	}
+
+	kr.mu.Lock()
+	kr.keys, kr.active = m, active
+	kr.mu.Unlock()
+	return nil
+}
+
+// SignURL returns query of URL of path signed with active key, signature
+// is valid until expires and covers all parameters of query
+func (kr *Keyring) SignURL(path string, query url.Values, expires time.Time) (url.Values, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if len(kr.active) == 0 {
+		return nil, errors.ErrSigningDisabled
+	}
+
+	signed := url.Values{}
+	for name, values := range query {
+		signed[name] = append([]string(nil), values...)
+	}
+	signed.Del("sig")
+	signed.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	signed.Set("kid", kr.active)
+
+	signed.Set("sig", urlSignature(kr.keys[kr.active], path, signed))
+	return signed, nil
+}
+
+// VerifyURL checks that query of URL of path is signed
+// by a key of kr and that it did not expire at now
+func (kr *Keyring) VerifyURL(path string, query url.Values, now time.Time) error {
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil || now.Unix() > exp {
+		return errors.ErrInvalidSignature
+	}
+
+	kr.mu.RLock()
+	key, ok := kr.keys[query.Get("kid")]
+	kr.mu.RUnlock()
+	if !ok {
+		return errors.ErrInvalidSignature
+	}
+
+	unsigned := url.Values{}
+	for name, values := range query {
+		if name != "sig" {
+			unsigned[name] = values
+		}
+	}
+
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(urlSignature(key, path, unsigned))) {
+		return errors.ErrInvalidSignature
+	}
+	return nil
+}
+
+// urlSignature returns HMAC-SHA256 of path and query sorted by name
+func urlSignature(key []byte, path string, query url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
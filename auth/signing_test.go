@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+func Test_SignURL(t *testing.T) {
+	kr := NewKeyring()
+	now := time.Now()
+
+	if _, err := kr.SignURL("/g/db/key", url.Values{}, now.Add(time.Minute)); err != errors.ErrSigningDisabled {
+		t.Fatalf("expected ErrSigningDisabled, got %v", err)
+	}
+
+	k1 := SigningKey{ID: "k1", Active: true, Secret: "0123456789abcdef0123456789abcdef"}
+	if err := kr.Set([]SigningKey{k1}); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := kr.SignURL("/g/db/key", url.Values{"w": {"200"}}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.VerifyURL("/g/db/key", signed, now); err != nil {
+		t.Fatalf("expected signed URL verified, got %v", err)
+	}
+
+	// expired
+	if err := kr.VerifyURL("/g/db/key", signed, now.Add(2*time.Minute)); err != errors.ErrInvalidSignature {
+		t.Fatalf("expected expired URL rejected, got %v", err)
+	}
+
+	// tampered query and path
+	tampered := url.Values{}
+	for name, values := range signed {
+		tampered[name] = values
+	}
+	tampered.Set("w", "2000")
+	if err := kr.VerifyURL("/g/db/key", tampered, now); err != errors.ErrInvalidSignature {
+		t.Fatalf("expected tampered query rejected, got %v", err)
+	}
+	if err := kr.VerifyURL("/g/db/other", signed, now); err != errors.ErrInvalidSignature {
+		t.Fatalf("expected URL of other path rejected, got %v", err)
+	}
+
+	// rotation, URLs of the old kid are verified until it is removed
+	k1.Active = false
+	k2 := SigningKey{ID: "k2", Active: true, Secret: "fedcba9876543210fedcba9876543210"}
+	if err := kr.Set([]SigningKey{k2, k1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.VerifyURL("/g/db/key", signed, now); err != nil {
+		t.Fatalf("expected URL of old kid verified, got %v", err)
+	}
+	rotated, err := kr.SignURL("/g/db/key", url.Values{}, now.Add(time.Minute))
+	if err != nil || rotated.Get("kid") != "k2" {
+		t.Fatalf("expected URL signed with k2, got %v %v", rotated, err)
+	}
+
+	// unknown kid
+	if err := kr.Set([]SigningKey{k2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.VerifyURL("/g/db/key", signed, now); err != errors.ErrInvalidSignature {
+		t.Fatalf("expected URL of unknown kid rejected, got %v", err)
+	}
+
+	// invalid keys keep the ones in use
+	if err := kr.Set([]SigningKey{{ID: "k3", Active: true, Secret: "short"}}); err == nil {
+		t.Fatal("expected short secret rejected")
+	}
+	if err := kr.VerifyURL("/g/db/key", rotated, now); err != nil {
+		t.Fatalf("expected keys kept after invalid ones, got %v", err)
+	}
+}
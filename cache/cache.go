@@ -8,7 +8,7 @@ type Cacheable interface {
 
 	Insert(n *Node)
 
-	LookUp(key uint32) *Node
+	LookUp(key string) *Node
 }
 
 // Cache holds cache operations
@@ -17,7 +17,7 @@ type Cache struct {
 }
 
 // Get gets data from cache
-func (c *Cache) Get(key uint32) []byte {
+func (c *Cache) Get(key string) []byte {
 	if v := c.cacheable.LookUp(key); v != nil {
 		return v.value
 	}
@@ -25,7 +25,7 @@ func (c *Cache) Get(key uint32) []byte {
 }
 
 // Put puts data in cache
-func (c *Cache) Put(key uint32, value []byte) {
+func (c *Cache) Put(key string, value []byte) {
 	c.cacheable.Insert(&Node{
 		key:   key,
 		value: value,
@@ -49,7 +49,7 @@ func NewCache(c Cacheable) *Cache {
 
 // Node holds cache entry
 type Node struct {
-	key   uint32
+	key   string
 	value []byte
 	size  int32
 }
@@ -7,7 +7,7 @@ type lru struct {
 	capacity int64 // Max size of cache in bytes
 	count    int64 // Itens in cache
 	mu       sync.RWMutex
-	kv       map[uint32]**lruNode
+	kv       map[string]**lruNode
 	head     *lruNode
 }
 
@@ -56,12 +56,15 @@ func (c *lru) Insert(n *Node) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// replaced node is removed so its size is not counted twice
+	if vaddr, ok := c.kv[n.key]; ok {
+		c.decUsed((*vaddr).n.size)
+		c.removeNode(*vaddr)
+	}
+
 	ln := &lruNode{n: n, refs: 2}
 	c.insertHead(ln)
-
-	if _, ok := c.kv[n.key]; !ok {
-		c.incUsed(n.size)
-	}
+	c.incUsed(n.size)
 
 	c.kv[n.key] = &ln
 
@@ -69,10 +72,11 @@ func (c *lru) Insert(n *Node) {
 		old := c.head.next
 		c.decUsed(old.n.size)
 		c.removeNode(old)
+		delete(c.kv, old.n.key)
 	}
 }
 
-func (c *lru) LookUp(key uint32) *Node {
+func (c *lru) LookUp(key string) *Node {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -92,7 +96,7 @@ func (c *lru) LookUp(key uint32) *Node {
 func NewLRU(capacity int64) Cacheable {
 	c := &lru{
 		capacity: capacity,
-		kv:       make(map[uint32]**lruNode),
+		kv:       make(map[string]**lruNode),
 	}
 
 	// Make empty node
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// BatchItem holds an image of PutBatch and its options,
+// IfMatch of options is not used by batches
+type BatchItem struct {
+	Key  string
+	Data io.Reader
+	PutOptions
+}
+
+// BatchResult holds result of an item of a batch, Err is nil if it was written
+type BatchResult struct {
+	Key      string
+	Revision uint32
+	Data     *model.DataDefinitionResult
+	Err      error
+}
+
+// batchResults decodes results of batch response, results of an
+// aborted transaction are returned with its error
+func batchResults(resp *response, err error) ([]BatchResult, error) {
+	if err != nil {
+		if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusConflict {
+			return nil, err
+		}
+	}
+
+	var results []struct {
+		Key      string                      `json:"key"`
+		Revision uint32                      `json:"revision"`
+		Data     *model.DataDefinitionResult `json:"data"`
+		Error    string                      `json:"error"`
+	}
+	if cerr := resp.content("results", &results); cerr != nil {
+		return nil, cerr
+	}
+
+	batch := make([]BatchResult, len(results))
+	for i, r := range results {
+		batch[i] = BatchResult{Key: r.Key, Revision: r.Revision, Data: r.Data}
+		if len(r.Error) > 0 {
+			batch[i].Err = newError(0, []string{r.Error})
+		}
+	}
+	return batch, err
+}
+
+// batchPath returns path of batch requests of database dbname
+func batchPath(dbname string, atomic bool) string {
+	if atomic {
+		return dataPath(dbname, "_batch") + "?atomic=true"
+	}
+	return dataPath(dbname, "_batch")
+}
+
+// PutBatch uploads items to database dbname in one request, they are
+// written while the database is locked once. If atomic is set all items
+// are written or none, the error is ErrTransactionAborted when an item
+// failed. Returns result of each item. Items are sent as they are read,
+// the request is not sent again on retries
+func (c *Client) PutBatch(ctx context.Context, dbname string, items []BatchItem, atomic bool) ([]BatchResult, error) {
+	boundary := multipart.NewWriter(nil).Boundary()
+	sent := false
+	body := func() (io.Reader, error) {
+		if sent {
+			return nil, errNotReplayable
+		}
+		sent = true
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeBatchForm(pw, boundary, items))
+		}()
+		return pr, nil
+	}
+
+	resp, err := c.call(ctx, &request{
+		method:      http.MethodPut,
+		path:        batchPath(dbname, atomic),
+		contentType: "multipart/form-data; boundary=" + boundary,
+		body:        body,
+	})
+	return batchResults(resp, err)
+}
+
+// writeBatchForm writes batch upload request to w, options
+// of each image are named after its key
+func writeBatchForm(w io.Writer, boundary string, items []BatchItem) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		fields := map[string]string{fmt.Sprintf("upsert[%s]", item.Key): fmt.Sprint(item.Upsert)}
+		if len(item.Script) > 0 {
+			fields[fmt.Sprintf("script[%s]", item.Key)] = item.Script
+		}
+		if ttl := item.ttlField(); len(ttl) > 0 {
+			fields[fmt.Sprintf("ttl[%s]", item.Key)] = ttl
+		}
+		for name, value := range item.Attributes {
+			fields[fmt.Sprintf("attr[%s][%s]", item.Key, name)] = value
+		}
+		for name, value := range fields {
+			if err := mw.WriteField(name, value); err != nil {
+				return err
+			}
+		}
+
+		filename := item.Filename
+		if len(filename) == 0 {
+			filename = item.Key
+		}
+		fw, err := mw.CreateFormFile(fmt.Sprintf("uploadfile[%s]", item.Key), filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, item.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// DeleteBatch removes keys from database dbname in one request, if
+// atomic is set all keys are removed or none. Returns result of each key
+func (c *Client) DeleteBatch(ctx context.Context, dbname string, keys []string, atomic bool) ([]BatchResult, error) {
+	b, err := json.Marshal(map[string][]string{"keys": keys})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.call(ctx, &request{
+		method:      http.MethodDelete,
+		path:        batchPath(dbname, atomic),
+		contentType: "application/json",
+		body:        bytesBody(b),
+	})
+	return batchResults(resp, err)
+}
@@ -0,0 +1,328 @@
+// Package client is a Go client of SparrowDB HTTP API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	_error "errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+const (
+	// DefaultMaxRetries times a failed request is sent again
+	DefaultMaxRetries = 2
+
+	// DefaultRetryWait wait before first retry, it doubles on each retry
+	DefaultRetryWait = 100 * time.Millisecond
+
+	// DefaultMaxIdleConns idle connections kept to the server
+	DefaultMaxIdleConns = 16
+
+	// tokenRefreshMargin token is renewed when it expires in less than it
+	tokenRefreshMargin = 5 * time.Second
+)
+
+var errNotReplayable = _error.New("request body can not be sent again")
+
+// Options holds client configuration, zero values are set to defaults
+type Options struct {
+	// HTTPClient sends requests, one with pooled connections
+	// to the server is created if it is nil
+	HTTPClient *http.Client
+
+	// Username and Password of user, requests are sent
+	// without token if Username is empty
+	Username string
+	Password string
+
+	// MaxRetries times a request is sent again after a network error or
+	// an unavailable server, DefaultMaxRetries if 0, none if negative.
+	// Only GET requests are sent again if the server may have read them
+	MaxRetries int
+
+	// RetryWait wait before first retry, DefaultRetryWait if 0
+	RetryWait time.Duration
+
+	// MaxIdleConns idle connections kept to the server,
+	// DefaultMaxIdleConns if 0
+	MaxIdleConns int
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultMaxRetries
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryWait <= 0 {
+		o.RetryWait = DefaultRetryWait
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if o.HTTPClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = o.MaxIdleConns
+		transport.MaxIdleConnsPerHost = o.MaxIdleConns
+		o.HTTPClient = &http.Client{Transport: transport}
+	}
+	return o
+}
+
+// Client sends requests to a SparrowDB server, it can
+// be used by several goroutines
+type Client struct {
+	baseURL string
+	opts    Options
+
+	// token of user and when it expires
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New returns new Client of server at baseURL, like http://127.0.0.1:8081
+func New(baseURL string, opts Options) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		opts:    opts.withDefaults(),
+	}
+}
+
+// Login authenticates user of client options, a token is requested
+// by the client when needed, calling it is optional
+func (c *Client) Login(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.login(ctx)
+}
+
+// login requests new token, c.mu must be held
+func (c *Client) login(ctx context.Context) error {
+	b, _ := json.Marshal(map[string]string{
+		"username": c.opts.Username,
+		"password": c.opts.Password,
+	})
+
+	req := &request{method: http.MethodPost, path: "/user/login", contentType: "application/json", body: bytesBody(b)}
+	resp, err := c.send(ctx, req, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return &Error{StatusCode: resp.StatusCode, Messages: []string{errors.ErrLogin.Error()}, err: errors.ErrLogin}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newError(resp.StatusCode, nil)
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	c.token, c.expires = result.Token, tokenExpiration(result.Token)
+	return nil
+}
+
+// authToken returns token of user, a new one is requested if it
+// expires soon or force is set. Empty if client has no user
+func (c *Client) authToken(ctx context.Context, force bool) (string, error) {
+	if len(c.opts.Username) == 0 {
+		return "", nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if force || len(c.token) == 0 || time.Now().Add(tokenRefreshMargin).After(c.expires) {
+		if err := c.login(ctx); err != nil {
+			return "", err
+		}
+	}
+	return c.token, nil
+}
+
+// tokenExpiration reads expiration time of JWT token, the token
+// is checked by the server, its signature is not verified
+func tokenExpiration(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// request holds a request to the server, body returns its
+// body each time it is sent
+type request struct {
+	method      string
+	path        string
+	contentType string
+	header      http.Header
+	body        func() (io.Reader, error)
+}
+
+// bytesBody returns body of request with content b
+func bytesBody(b []byte) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		return bytes.NewReader(b), nil
+	}
+}
+
+// do sends req with token of user and returns its response. The token
+// is renewed once if it is rejected, and the request is sent again
+// after network errors and unavailable server as options allow
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	token, err := c.authToken(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	wait := c.opts.RetryWait
+	renewed := false
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, token)
+
+		retry := false
+		switch {
+		case err != nil:
+			retry = ctx.Err() == nil && (req.method == http.MethodGet || isDialError(err))
+		case resp.StatusCode == http.StatusUnauthorized && len(token) > 0 && !renewed:
+			resp.Body.Close()
+			if token, err = c.authToken(ctx, true); err != nil {
+				return nil, err
+			}
+			renewed = true
+			attempt--
+			continue
+		case req.method == http.MethodGet && isUnavailable(resp.StatusCode):
+			retry = true
+		}
+
+		if !retry || attempt >= c.opts.MaxRetries {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send sends req once
+func (c *Client) send(ctx context.Context, req *request, token string) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		var err error
+		if body, err = req.body(); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := http.NewRequest(req.method, c.baseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+
+	for name, values := range req.header {
+		r.Header[name] = values
+	}
+	if len(req.contentType) > 0 {
+		r.Header.Set("Content-Type", req.contentType)
+	}
+	if len(token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return c.opts.HTTPClient.Do(r)
+}
+
+// isDialError checks if err happened before request was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return _error.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// response holds JSON response of API
+type response struct {
+	Database string                     `json:"database"`
+	Content  map[string]json.RawMessage `json:"content"`
+	Error    []string                   `json:"error"`
+}
+
+// content decodes value name of response content into v
+func (r *response) content(name string, v interface{}) error {
+	raw, ok := r.Content[name]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// call sends req and decodes its JSON response, an Error is returned
+// with the response if the server answers with other status than 200
+func (c *Client) call(ctx context.Context, req *request) (*response, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &response{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, result); err != nil && resp.StatusCode == http.StatusOK {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := newError(resp.StatusCode, result.Error)
+		result.content("revision", &e.Revision)
+		return result, e
+	}
+	return result, nil
+}
@@ -0,0 +1,454 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	_error "errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/auth"
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+	sphttp "github.com/SparrowDb/sparrowdb/http"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// newTestServer returns server of API with authentication, with
+// user of config/user.xml. Scripts are stored in dir
+func newTestServer(t *testing.T, dir string) (*httptest.Server, *db.DBManager) {
+	return newSigningTestServer(t, dir, auth.NewKeyring())
+}
+
+// newSigningTestServer returns server of newTestServer
+// that signs URLs with keys of keyring
+func newSigningTestServer(t *testing.T, dir string, keyring *auth.Keyring) (*httptest.Server, *db.DBManager) {
+	cfg := db.NewSparrowConfig("../config/")
+	cfg.Path = filepath.Join(dir, "data")
+	cfg.SnapshotPath = filepath.Join(dir, "snapshot")
+	cfg.AuthenticationActive = true
+	cfg.UserExpire = 60000
+	cfg.ReadOnly = false
+	auth.LoadUserConfig("../config", cfg)
+
+	dbm := db.NewDBManager(cfg, db.NewDatabaseConfig(dir))
+	return httptest.NewServer(sphttp.NewRouter(cfg, dbm, keyring)), dbm
+}
+
+func testImage(t *testing.T) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func Test_Client(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	ctx := context.Background()
+	c := New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+
+	if err := c.CreateDatabase(ctx, "images", model.CreateDatabase{MaxRevisions: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateDatabase(ctx, "images", model.CreateDatabase{}); !_error.Is(err, errors.ErrCreateDatabase) {
+		t.Fatalf("expected ErrCreateDatabase, got %v", err)
+	}
+
+	info, err := c.DatabaseInfo(ctx, "images")
+	if err != nil || info.Config["max_revisions"] != float64(3) {
+		t.Fatalf("unexpected database info %v %v", info, err)
+	}
+	if _, err := c.DatabaseInfo(ctx, "missing"); !_error.Is(err, errors.ErrDatabaseNotFound) {
+		t.Fatalf("expected ErrDatabaseNotFound, got %v", err)
+	}
+
+	img := testImage(t)
+	result, err := c.Put(ctx, "images", "photo", bytes.NewReader(img), PutOptions{
+		Filename:   "photo.png",
+		Attributes: map[string]string{"album": "trip"},
+	})
+	if err != nil || result.Key != "photo" || result.Revision != 1 || result.Attributes["album"] != "trip" {
+		t.Fatalf("unexpected upload result %v %v", result, err)
+	}
+
+	_, err = c.Put(ctx, "images", "photo", bytes.NewReader(img), PutOptions{})
+	if !_error.Is(err, errors.ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if _, err := c.Put(ctx, "images", "photo", bytes.NewReader(img), PutOptions{Upsert: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Put(ctx, "images", "photo", bytes.NewReader(img), PutOptions{IfMatch: `"none"`})
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusPreconditionFailed || e.Revision != 2 || !_error.Is(err, errors.ErrWrongRevision) {
+		t.Fatalf("expected revision mismatch, got %v", err)
+	}
+
+	obj, err := c.Get(ctx, "images", "photo", GetOptions{})
+	if err != nil || !bytes.Equal(obj.Data, img) || obj.ContentType != "image/png" || len(obj.ETag) == 0 {
+		t.Fatalf("unexpected data %v", err)
+	}
+	if _, err := c.Get(ctx, "images", "none", GetOptions{}); !_error.Is(err, errors.ErrEmptyQueryResult) {
+		t.Fatalf("expected ErrEmptyQueryResult, got %v", err)
+	}
+
+	if result, err = c.UpdateMetadata(ctx, "images", "photo", map[string]string{"album": "", "place": "beach"}); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = c.Info(ctx, "images", "photo", 0); err != nil || result.Revision != 3 || result.Attributes["place"] != "beach" || len(result.Attributes) != 1 {
+		t.Fatalf("unexpected data info %v %v", result, err)
+	}
+	if result, err = c.Info(ctx, "images", "photo", 1); err != nil || result.Revision != 1 {
+		t.Fatalf("unexpected info of revision 1 %v %v", result, err)
+	}
+
+	revs, err := c.History(ctx, "images", "photo")
+	if err != nil || len(revs) != 3 || revs[0].Revision != 3 {
+		t.Fatalf("unexpected history %v %v", revs, err)
+	}
+
+	if result, err = c.Put(ctx, "images", "other", bytes.NewReader(img), PutOptions{TTL: time.Hour}); err != nil || len(result.Expires) == 0 {
+		t.Fatalf("expected expiry time of other, got %v %v", result, err)
+	}
+	page, err := c.ListKeys(ctx, "images", "", "", 1)
+	if err != nil || len(page.Keys) != 1 || page.Keys[0] != "other" || len(page.Cursor) == 0 {
+		t.Fatalf("unexpected first page %v %v", page, err)
+	}
+	if page, err = c.ListKeys(ctx, "images", "", page.Cursor, 1); err != nil || len(page.Keys) != 1 || page.Keys[0] != "photo" {
+		t.Fatalf("unexpected second page %v %v", page, err)
+	}
+
+	if err := c.Delete(ctx, "images", "photo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "images", "photo"); !_error.Is(err, errors.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := c.DropDatabase(ctx, "images"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := c.Databases(ctx); err != nil || len(names) != 0 {
+		t.Fatalf("expected no database, got %v %v", names, err)
+	}
+}
+
+func Test_ClientScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	// scripts are stored in scripts directory of working directory
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.Mkdir("scripts", 0755)
+
+	ctx := context.Background()
+	c := New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+
+	if err := c.SaveScript(ctx, "thumb", "return 1"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := c.Scripts(ctx); err != nil || len(names) != 1 || names[0] != "thumb" {
+		t.Fatalf("unexpected scripts %v %v", names, err)
+	}
+	if content, err := c.Script(ctx, "thumb"); err != nil || content != "return 1" {
+		t.Fatalf("unexpected script %q %v", content, err)
+	}
+	if err := c.SaveScript(ctx, "a-b", ""); !_error.Is(err, errors.ErrScriptInvalidName) {
+		t.Fatalf("expected ErrScriptInvalidName, got %v", err)
+	}
+	if err := c.DeleteScript(ctx, "thumb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Script(ctx, "thumb"); !_error.Is(err, errors.ErrScriptNotExists) {
+		t.Fatalf("expected ErrScriptNotExists, got %v", err)
+	}
+}
+
+func Test_ClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	ctx := context.Background()
+
+	c := New(srv.URL, Options{Username: "sparrow", Password: "wrong"})
+	if err := c.Login(ctx); !_error.Is(err, errors.ErrLogin) {
+		t.Fatalf("expected ErrLogin, got %v", err)
+	}
+
+	c = New(srv.URL, Options{})
+	if _, err := c.Databases(ctx); !_error.Is(err, errors.ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	// rejected token is renewed
+	c = New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+	if err := c.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.expires.IsZero() {
+		t.Fatal("expiration of token not read")
+	}
+	c.token = c.token[:len(c.token)-2]
+	if _, err := c.Databases(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ClientSignedURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := auth.NewKeyring()
+	srv, dbm := newSigningTestServer(t, dir, keyring)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	k1 := auth.SigningKey{ID: "k1", Active: true, Secret: "0123456789abcdef0123456789abcdef"}
+	if err := keyring.Set([]auth.SigningKey{k1}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c := New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+	if err := c.CreateDatabase(ctx, "private", model.CreateDatabase{SignedURLs: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, "private", "photo", bytes.NewReader(testImage(t)), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(ctx, "private", "photo", GetOptions{}); !_error.Is(err, errors.ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	status := func(u string) int {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	signed, expires, err := c.SignURL(ctx, "private", "photo", time.Minute, GetOptions{Transform: url.Values{"w": {"2"}}})
+	if err != nil || expires.Before(time.Now()) {
+		t.Fatalf("unexpected signed URL %s %v %v", signed, expires, err)
+	}
+	if s := status(signed); s != http.StatusOK {
+		t.Fatalf("expected signed URL served, got %d", s)
+	}
+	if s := status(strings.Replace(signed, "w=2", "w=3", 1)); s != http.StatusForbidden {
+		t.Fatalf("expected changed URL rejected, got %d", s)
+	}
+
+	past, err := keyring.SignURL("/g/private/photo", url.Values{}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := status(srv.URL + "/g/private/photo?" + past.Encode()); s != http.StatusForbidden {
+		t.Fatalf("expected expired URL rejected, got %d", s)
+	}
+
+	// URLs of previous key are valid until it is removed
+	k1.Active = false
+	k2 := auth.SigningKey{ID: "k2", Active: true, Secret: "fedcba9876543210fedcba9876543210"}
+	if err := keyring.Set([]auth.SigningKey{k2, k1}); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(signed); s != http.StatusOK {
+		t.Fatalf("expected URL of previous key served, got %d", s)
+	}
+	if err := keyring.Set([]auth.SigningKey{k2}); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(signed); s != http.StatusForbidden {
+		t.Fatalf("expected URL of removed key rejected, got %d", s)
+	}
+	if signed, _, err = c.SignURL(ctx, "private", "photo", 0, GetOptions{}); err != nil || status(signed) != http.StatusOK {
+		t.Fatalf("expected URL of new key served, got %v", err)
+	}
+}
+
+func Test_ClientRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	api, dbm := newTestServer(t, dir)
+	defer api.Close()
+	defer dbm.Stop()
+
+	// first GET request is answered as unavailable
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		api.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+	if _, err := c.Databases(ctx); err != nil || failures != 0 {
+		t.Fatalf("request not sent again %v", err)
+	}
+
+	failures = 1
+	c = New(srv.URL, Options{Username: "sparrow", Password: "sparrow", MaxRetries: -1})
+	if _, err := c.Databases(ctx); err == nil {
+		t.Fatal("request must not be sent again")
+	} else if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable server error, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Databases(canceled); !_error.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func Test_ClientBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	ctx := context.Background()
+	c := New(srv.URL, Options{Username: "sparrow", Password: "sparrow"})
+	if err := c.CreateDatabase(ctx, "images", model.CreateDatabase{AllowedMediaTypes: "image/*"}); err != nil {
+		t.Fatal(err)
+	}
+
+	img := testImage(t)
+	results, err := c.PutBatch(ctx, "images", []BatchItem{
+		{Key: "a", Data: bytes.NewReader(img), PutOptions: PutOptions{Filename: "a.png", Attributes: map[string]string{"album": "trip"}}},
+		{Key: "b", Data: bytes.NewReader(img)},
+		{Key: "c", Data: bytes.NewReader([]byte("not an image"))},
+	}, false)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("unexpected batch results %v %v", results, err)
+	}
+	if results[0].Err != nil || results[0].Revision != 1 || results[0].Data.Attributes["album"] != "trip" || results[0].Data.Ext != "png" {
+		t.Fatalf("unexpected result of a %v", results[0])
+	}
+	if results[1].Err != nil || results[1].Key != "b" {
+		t.Fatalf("unexpected result of b %v", results[1])
+	}
+	if !_error.Is(results[2].Err, errors.ErrMediaTypeNotAllowed) {
+		t.Fatalf("expected ErrMediaTypeNotAllowed, got %v", results[2].Err)
+	}
+
+	// images of a tar, key and options in PAX records
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, name := range []string{"a.png", "d.png"} {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(img)), Format: tar.FormatPAX}
+		if name == "a.png" {
+			hdr.PAXRecords = map[string]string{"SPARROW.upsert": "true", "SPARROW.attr.album": "home"}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(img)
+	}
+	tw.Close()
+
+	resp, err := c.call(ctx, &request{
+		method:      http.MethodPut,
+		path:        dataPath("images", "_batch"),
+		contentType: "application/x-tar",
+		body:        bytesBody(b.Bytes()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err = batchResults(resp, nil); err != nil || len(results) != 2 {
+		t.Fatalf("unexpected tar batch results %v %v", results, err)
+	}
+	if results[0].Err != nil || results[0].Revision != 2 || results[0].Data.Attributes["album"] != "home" {
+		t.Fatalf("unexpected result of a %v", results[0])
+	}
+	if results[1].Err != nil || results[1].Key != "d" {
+		t.Fatalf("unexpected result of d %v", results[1])
+	}
+
+	// failed item aborts atomic batch
+	results, err = c.PutBatch(ctx, "images", []BatchItem{
+		{Key: "e", Data: bytes.NewReader(img)},
+		{Key: "d", Data: bytes.NewReader(img)},
+	}, true)
+	if !_error.Is(err, errors.ErrTransactionAborted) || len(results) != 2 {
+		t.Fatalf("expected ErrTransactionAborted, got %v %v", results, err)
+	}
+	if !_error.Is(results[0].Err, errors.ErrTransactionAborted) || !_error.Is(results[1].Err, errors.ErrKeyExists) {
+		t.Fatalf("unexpected atomic batch errors %v %v", results[0].Err, results[1].Err)
+	}
+	if _, err := c.DeleteBatch(ctx, "images", []string{"d", "e"}, true); !_error.Is(err, errors.ErrTransactionAborted) {
+		t.Fatalf("expected ErrTransactionAborted, got %v", err)
+	}
+
+	results, err = c.DeleteBatch(ctx, "images", []string{"a", "b", "c", strings.Repeat("k", 151)}, false)
+	if err != nil || len(results) != 4 {
+		t.Fatalf("unexpected delete results %v %v", results, err)
+	}
+	if results[0].Err != nil || results[0].Revision != 3 || results[1].Err != nil {
+		t.Fatalf("unexpected delete results %v", results)
+	}
+	if !_error.Is(results[2].Err, errors.ErrKeyNotFound) || !_error.Is(results[3].Err, errors.ErrImageInvalidKey) {
+		t.Fatalf("unexpected delete errors %v %v", results[2].Err, results[3].Err)
+	}
+
+	if page, err := c.ListKeys(ctx, "images", "", "", 0); err != nil || len(page.Keys) != 1 || page.Keys[0] != "d" {
+		t.Fatalf("expected only d stored, got %v %v", page, err)
+	}
+}
@@ -0,0 +1,344 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// PutOptions holds options of an upload
+type PutOptions struct {
+	// Filename name of uploaded file, its extension is stored
+	// with data. Key is used if it is empty
+	Filename string
+
+	// Upsert replaces data of key if it exists
+	Upsert bool
+
+	// Script Lua script run on data before it is stored
+	Script string
+
+	// Attributes metadata stored with data
+	Attributes map[string]string
+
+	// IfMatch ETags of stored data the upload replaces, "*" for any
+	IfMatch string
+
+	// TTL time data is kept, in seconds. Default TTL of database
+	// is used if it is 0, data does not expire if it is negative
+	TTL time.Duration
+}
+
+// ttlField returns value of ttl form field of opts, empty if it is not sent
+func (opts *PutOptions) ttlField() string {
+	switch {
+	case opts.TTL < 0:
+		return "0"
+	case opts.TTL > 0:
+		return strconv.FormatInt(int64((opts.TTL+time.Second-1)/time.Second), 10)
+	}
+	return ""
+}
+
+// GetOptions holds options of a data request
+type GetOptions struct {
+	// Token of data, required by databases that generate tokens
+	Token string
+
+	// Revision of data, current one if 0
+	Revision uint32
+
+	// Transform image operations, like w, h, crop or format,
+	// applied to returned data
+	Transform url.Values
+}
+
+// Object holds data returned by Get
+type Object struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+}
+
+func dataPath(dbname, key string) string {
+	return "/api/" + url.PathEscape(dbname) + "/" + url.PathEscape(key)
+}
+
+// Put uploads data of key to database dbname. Data is sent as it is read,
+// the request is sent again on retries only if data is an io.Seeker
+func (c *Client) Put(ctx context.Context, dbname, key string, data io.Reader, opts PutOptions) (*model.DataDefinitionResult, error) {
+	filename := opts.Filename
+	if len(filename) == 0 {
+		filename = key
+	}
+
+	seeker, seekable := data.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+	sent := false
+	body := func() (io.Reader, error) {
+		if sent {
+			if !seekable {
+				return nil, errNotReplayable
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		sent = true
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeUploadForm(pw, boundary, filename, data, opts))
+		}()
+		return pr, nil
+	}
+
+	req := &request{
+		method:      http.MethodPut,
+		path:        dataPath(dbname, key),
+		contentType: "multipart/form-data; boundary=" + boundary,
+		body:        body,
+	}
+	if len(opts.IfMatch) > 0 {
+		req.header = http.Header{"If-Match": {opts.IfMatch}}
+	}
+
+	resp, err := c.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.DataDefinitionResult{}
+	err = resp.content("data", result)
+	return result, err
+}
+
+// writeUploadForm writes upload request to w, fields are written
+// before data as script must be read before large images
+func writeUploadForm(w io.Writer, boundary, filename string, data io.Reader, opts PutOptions) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	fields := map[string]string{"upsert": strconv.FormatBool(opts.Upsert)}
+	if len(opts.Script) > 0 {
+		fields["script"] = opts.Script
+	}
+	if ttl := opts.ttlField(); len(ttl) > 0 {
+		fields["ttl"] = ttl
+	}
+	for name, value := range opts.Attributes {
+		fields[fmt.Sprintf("attr[%s]", name)] = value
+	}
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
+	fw, err := mw.CreateFormFile("uploadfile", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, data); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// UpdateMetadata sets attributes of key, attributes with
+// empty value are removed. Data is not changed
+func (c *Client) UpdateMetadata(ctx context.Context, dbname, key string, attrs map[string]string) (*model.DataDefinitionResult, error) {
+	form := url.Values{}
+	for name, value := range attrs {
+		form.Set(fmt.Sprintf("attr[%s]", name), value)
+	}
+
+	resp, err := c.call(ctx, &request{
+		method:      http.MethodPatch,
+		path:        dataPath(dbname, key),
+		contentType: "application/x-www-form-urlencoded",
+		body:        bytesBody([]byte(form.Encode())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.DataDefinitionResult{}
+	err = resp.content("data", result)
+	return result, err
+}
+
+// Get returns data of key
+func (c *Client) Get(ctx context.Context, dbname, key string, opts GetOptions) (*Object, error) {
+	path := "/g/" + url.PathEscape(dbname) + "/" + url.PathEscape(key)
+	if len(opts.Token) > 0 {
+		path += "/" + url.PathEscape(opts.Token)
+	}
+
+	query := url.Values{}
+	for name, values := range opts.Transform {
+		query[name] = values
+	}
+	if opts.Revision > 0 {
+		query.Set("rev", strconv.FormatUint(uint64(opts.Revision), 10))
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: path})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		r := &response{}
+		json.NewDecoder(resp.Body).Decode(r)
+		return nil, newError(resp.StatusCode, r.Error)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		Data:        b,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}, nil
+}
+
+// Info returns information of revision rev of key, current one if rev is 0
+func (c *Client) Info(ctx context.Context, dbname, key string, rev uint32) (*model.DataDefinitionResult, error) {
+	path := dataPath(dbname, key)
+	if rev > 0 {
+		path += "?rev=" + strconv.FormatUint(uint64(rev), 10)
+	}
+
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: path})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.DataDefinitionResult{}
+	err = resp.content("data", result)
+	return result, err
+}
+
+// Delete removes key from database dbname
+func (c *Client) Delete(ctx context.Context, dbname, key string) error {
+	_, err := c.call(ctx, &request{method: http.MethodDelete, path: dataPath(dbname, key)})
+	return err
+}
+
+// ListKeys returns up to limit keys with prefix, in order, after the
+// ones of the page cursor refers to. Server default limit is used if 0
+func (c *Client) ListKeys(ctx context.Context, dbname, prefix, cursor string, limit int) (*db.KeyPage, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	return c.keyPage(ctx, dataPath(dbname, "_keys"), query, cursor, limit)
+}
+
+// QueryAttribute returns keys of data whose attribute attr is equal to
+// value, or starts with it if prefix is set. Pages are like ListKeys
+func (c *Client) QueryAttribute(ctx context.Context, dbname, attr, value string, prefix bool, cursor string, limit int) (*db.KeyPage, error) {
+	query := url.Values{}
+	query.Set("attr", attr)
+	if prefix {
+		query.Set("prefix", value)
+	} else {
+		query.Set("eq", value)
+	}
+	return c.keyPage(ctx, dataPath(dbname, "_query"), query, cursor, limit)
+}
+
+func (c *Client) keyPage(ctx context.Context, path string, query url.Values, cursor string, limit int) (*db.KeyPage, error) {
+	if len(cursor) > 0 {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: path + "?" + query.Encode()})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &db.KeyPage{}
+	if err := resp.content("keys", &page.Keys); err != nil {
+		return nil, err
+	}
+	err = resp.content("cursor", &page.Cursor)
+	return page, err
+}
+
+// History returns stored revisions of key, newest first
+func (c *Client) History(ctx context.Context, dbname, key string) ([]db.Revision, error) {
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: dataPath(dbname, key) + "/_history"})
+	if err != nil {
+		return nil, err
+	}
+
+	revs := make([]db.Revision, 0)
+	err = resp.content("revisions", &revs)
+	return revs, err
+}
+
+// SignURL returns URL of key valid for expires, database default of one
+// hour is used if it is 0. Revision and Transform of opts are part of the
+// signed URL, anyone can read the image with it until it expires
+func (c *Client) SignURL(ctx context.Context, dbname, key string, expires time.Duration, opts GetOptions) (string, time.Time, error) {
+	query := url.Values{}
+	for name, values := range opts.Transform {
+		query[name] = values
+	}
+	if opts.Revision > 0 {
+		query.Set("rev", strconv.FormatUint(uint64(opts.Revision), 10))
+	}
+	if expires > 0 {
+		query.Set("expires", strconv.FormatInt(int64((expires+time.Second-1)/time.Second), 10))
+	}
+
+	path := dataPath(dbname, key) + "/_sign"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: path})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var signed string
+	var exp int64
+	if err := resp.content("url", &signed); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := resp.content("expires", &exp); err != nil {
+		return "", time.Time{}, err
+	}
+	return c.baseURL + signed, time.Unix(exp, 0), nil
+}
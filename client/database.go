@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// DatabaseInfo holds configuration and statistics of database
+type DatabaseInfo struct {
+	Config     map[string]interface{}
+	Statistics db.DatabaseInfo
+}
+
+// CreateDatabase creates database dbname, values of cfg
+// not set use the ones of server configuration
+func (c *Client) CreateDatabase(ctx context.Context, dbname string, cfg model.CreateDatabase) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call(ctx, &request{
+		method:      http.MethodPut,
+		path:        "/api/" + url.PathEscape(dbname),
+		contentType: "application/json",
+		body:        bytesBody(b),
+	})
+	return err
+}
+
+// DropDatabase removes database dbname and its data
+func (c *Client) DropDatabase(ctx context.Context, dbname string) error {
+	_, err := c.call(ctx, &request{method: http.MethodDelete, path: "/api/" + url.PathEscape(dbname)})
+	return err
+}
+
+// DatabaseInfo returns configuration and statistics of database dbname
+func (c *Client) DatabaseInfo(ctx context.Context, dbname string) (*DatabaseInfo, error) {
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: "/api/" + url.PathEscape(dbname)})
+	if err != nil {
+		return nil, err
+	}
+
+	// info of unknown database is answered with status 200
+	if len(resp.Error) > 0 {
+		return nil, newError(http.StatusBadRequest, resp.Error)
+	}
+
+	info := &DatabaseInfo{}
+	if err := resp.content("config", &info.Config); err != nil {
+		return nil, err
+	}
+	if err := resp.content("statistics", &info.Statistics); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Databases returns names of all databases
+func (c *Client) Databases(ctx context.Context) ([]string, error) {
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: "/api/_all"})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	err = resp.content("_all", &names)
+	return names, err
+}
+
+// Scripts returns names of stored scripts
+func (c *Client) Scripts(ctx context.Context) ([]string, error) {
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: "/script/_all"})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	err = resp.content("scripts", &names)
+	return names, err
+}
+
+// Script returns content of script name
+func (c *Client) Script(ctx context.Context, name string) (string, error) {
+	resp, err := c.call(ctx, &request{method: http.MethodGet, path: "/script/" + url.PathEscape(name)})
+	if err != nil {
+		return "", err
+	}
+
+	var content string
+	err = resp.content("script", &content)
+	return content, err
+}
+
+// SaveScript stores Lua script name, an existing one is replaced
+func (c *Client) SaveScript(ctx context.Context, name, content string) error {
+	b, _ := json.Marshal(map[string]string{"content": content})
+	_, err := c.call(ctx, &request{
+		method:      http.MethodPost,
+		path:        "/script/" + url.PathEscape(name),
+		contentType: "application/json",
+		body:        bytesBody(b),
+	})
+	return err
+}
+
+// DeleteScript removes script name
+func (c *Client) DeleteScript(ctx context.Context, name string) error {
+	_, err := c.call(ctx, &request{method: http.MethodDelete, path: "/script/" + url.PathEscape(name)})
+	return err
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+// knownErrors errors the server answers with
+var knownErrors = []error{
+	errors.ErrInvalidName,
+	errors.ErrCreateDatabase,
+	errors.ErrDropDatabase,
+	errors.ErrDatabaseNotFound,
+	errors.ErrWrongRequest,
+	errors.ErrInvalidQueryAction,
+	errors.ErrEmptyQueryResult,
+	errors.ErrParse,
+	errors.ErrWrongToken,
+	errors.ErrInsertImage,
+	errors.ErrScriptNotExists,
+	errors.ErrScriptInvalidName,
+	errors.ErrWrongRevision,
+	errors.ErrKeyExists,
+	errors.ErrKeyNotFound,
+	errors.ErrImageInvalidKey,
+	errors.ErrDatabaseName,
+	errors.ErrReadDir,
+	errors.ErrInvalidCursor,
+	errors.ErrInvalidAttribute,
+	errors.ErrTooManyAttributes,
+	errors.ErrAttributeNotIndexed,
+	errors.ErrDataTooLarge,
+	errors.ErrScriptOrder,
+	errors.ErrMediaTypeNotAllowed,
+	errors.ErrReservedKey,
+	errors.ErrUnknownCodec,
+	errors.ErrCompactionRunning,
+	errors.ErrLogin,
+	errors.ErrInvalidToken,
+	errors.ErrNotSupportedFileType,
+	errors.ErrNoPrivilege,
+	errors.ErrTransactionAborted,
+	errors.ErrInvalidSignature,
+	errors.ErrSigningDisabled,
+}
+
+// errorPatterns matches messages of knownErrors, verbs
+// of messages with values match any text
+var errorPatterns = func() []*regexp.Regexp {
+	verb := regexp.MustCompile(`%[a-z]`)
+	patterns := make([]*regexp.Regexp, len(knownErrors))
+	for i, err := range knownErrors {
+		patterns[i] = regexp.MustCompile("^" + verb.ReplaceAllString(regexp.QuoteMeta(err.Error()), ".*") + "$")
+	}
+	return patterns
+}()
+
+// Error is returned when the server answers with an error status,
+// StatusCode is 0 for errors of items of a batch. It wraps the error of the errors package its message matches, it
+// can be checked with errors.Is
+type Error struct {
+	StatusCode int
+	Messages   []string
+
+	// Revision stored revision of key when a
+	// conditional write does not match
+	Revision uint32
+
+	err error
+}
+
+func (e *Error) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return strings.Join(e.Messages, "; ")
+}
+
+// Unwrap returns error of errors package, nil if message is not known
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// newError returns Error of response with status and messages
+func newError(status int, messages []string) *Error {
+	e := &Error{StatusCode: status, Messages: messages}
+	for _, msg := range messages {
+		if e.err = matchError(msg); e.err != nil {
+			break
+		}
+	}
+	if e.err == nil && status == http.StatusUnauthorized {
+		e.err = errors.ErrInvalidToken
+	}
+	return e
+}
+
+// matchError returns known error with message msg
+func matchError(msg string) error {
+	for i, p := range errorPatterns {
+		if p.MatchString(msg) {
+			return knownErrors[i]
+		}
+	}
+	return nil
+}
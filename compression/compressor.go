@@ -1,8 +1,12 @@
 package compression
 
 import (
+	"fmt"
+
+	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/bkaradzic/go-lz4"
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Compressor compression interface
@@ -11,25 +15,138 @@ type Compressor interface {
 	Decompress(src []byte) ([]byte, error)
 }
 
+const (
+	// CodecNone data is stored as it is
+	CodecNone uint16 = iota
+
+	// CodecSnappy data is compressed with snappy
+	CodecSnappy
+
+	// CodecLZ4 data is compressed with LZ4
+	CodecLZ4
+
+	// CodecZstd data is compressed with zstd
+	CodecZstd
+)
+
+const (
+	// poorRatio ratio of compressed to original size above
+	// which data is stored uncompressed
+	poorRatio = 0.9
+
+	// sampleSize bytes of larger data compressed first to check
+	// ratio, the rest of already compressed images is not
+	sampleSize = 65536
+)
+
 var (
-	compressor Compressor = snappyCompressor{}
+	codecNames = map[string]uint16{
+		"none":   CodecNone,
+		"snappy": CodecSnappy,
+		"lz4":    CodecLZ4,
+		"zstd":   CodecZstd,
+	}
+
+	defaultCodecs = NewCodecs()
 )
 
-// SetCompressor sets compressor
+// Codecs holds the Compressor of each codec, data is decoded
+// with the one of the codec it was stored with
+type Codecs struct {
+	compressors map[uint16]Compressor
+}
+
+// NewCodecs returns Codecs with built-in compressors
+func NewCodecs() *Codecs {
+	return &Codecs{compressors: map[uint16]Compressor{
+		CodecNone:   noneCompressor{},
+		CodecSnappy: snappyCompressor{},
+		CodecLZ4:    lz4Compressor{},
+		CodecZstd:   NewZstdCompressor(),
+	}}
+}
+
+// Default returns Codecs used when none is given
+func Default() *Codecs {
+	return defaultCodecs
+}
+
+// SetCompressor sets compressor of snappy codec of default Codecs, it
+// must be called before data is written or read with them
 func SetCompressor(c Compressor) {
-	compressor = c
+	defaultCodecs.compressors = defaultCodecs.With(CodecSnappy, c).compressors
 }
 
-// Compress compress []byte and returns []byte
-// with compressed data
+// Compress compress []byte with compressor of snappy
+// codec of default Codecs
 func Compress(src []byte) []byte {
-	return compressor.Compress(src)
+	return defaultCodecs.compressors[CodecSnappy].Compress(src)
 }
 
-// Decompress []byte and returns []byte
-// with decompressed data
+// Decompress []byte compressed by Compress
 func Decompress(src []byte) ([]byte, error) {
-	return compressor.Decompress(src)
+	return defaultCodecs.Decode(CodecSnappy, src)
+}
+
+// With returns copy of cs where codec id uses c, which must
+// read and write the same format as the codec, like zstd
+// with other compression level
+func (cs *Codecs) With(id uint16, c Compressor) *Codecs {
+	n := &Codecs{compressors: make(map[uint16]Compressor, len(cs.compressors)+1)}
+	for k, v := range cs.compressors {
+		n.compressors[k] = v
+	}
+	n.compressors[id] = c
+	return n
+}
+
+// CodecID returns id of codec name, false if it is unknown
+func CodecID(name string) (uint16, bool) {
+	id, ok := codecNames[name]
+	return id, ok
+}
+
+// Encode compresses src with codec id. Returns the codec data is
+// stored with, CodecNone and src if compression ratio is poor
+func (cs *Codecs) Encode(id uint16, src []byte) (uint16, []byte) {
+	c, ok := cs.compressors[id]
+	if !ok || id == CodecNone || len(src) == 0 {
+		return CodecNone, src
+	}
+
+	if len(src) > sampleSize && isPoor(len(c.Compress(src[:sampleSize])), sampleSize) {
+		return CodecNone, src
+	}
+
+	dst := c.Compress(src)
+	if isPoor(len(dst), len(src)) {
+		return CodecNone, src
+	}
+	return id, dst
+}
+
+// Decode decompresses src stored with codec id
+func (cs *Codecs) Decode(id uint16, src []byte) ([]byte, error) {
+	c, ok := cs.compressors[id]
+	if !ok {
+		return nil, fmt.Errorf(errors.ErrUnknownCodec.Error(), id)
+	}
+	return c.Decompress(src)
+}
+
+func isPoor(compressed, size int) bool {
+	return float64(compressed) > float64(size)*poorRatio
+}
+
+// NONE COMPRESSOR
+type noneCompressor struct{}
+
+func (noneCompressor) Compress(src []byte) []byte {
+	return src
+}
+
+func (noneCompressor) Decompress(src []byte) ([]byte, error) {
+	return src, nil
 }
 
 // SNAPPY COMPRESSOR
@@ -64,3 +181,25 @@ func (lz4Compressor) Decompress(src []byte) ([]byte, error) {
 func NewLZ4Compressor() lz4Compressor {
 	return lz4Compressor{}
 }
+
+// ZSTD COMPRESSOR
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func (c zstdCompressor) Compress(src []byte) []byte {
+	return c.enc.EncodeAll(src, nil)
+}
+
+func (c zstdCompressor) Decompress(src []byte) ([]byte, error) {
+	return c.dec.DecodeAll(src, nil)
+}
+
+// NewZstdCompressor returns new zstdCompressor, it can
+// be used by several goroutines
+func NewZstdCompressor() zstdCompressor {
+	enc, _ := zstd.NewWriter(nil)
+	dec, _ := zstd.NewReader(nil)
+	return zstdCompressor{enc: enc, dec: dec}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// attributeEntries returns attribute index entries of the indexed
+// attributes of df stored at offset
+func attributeEntries(df *model.DataDefinition, offset int64, indexed []string) []*index.Entry {
+	entries := make([]*index.Entry, 0)
+	for _, name := range indexed {
+		if value, ok := df.Attributes[name]; ok {
+			entries = append(entries, &index.Entry{
+				Key:      index.AttributeKey(name, value, df.Key),
+				Offset:   offset,
+				Status:   df.Status,
+				Revision: df.Revision,
+				Expires:  df.Expires,
+			})
+		}
+	}
+	return entries
+}
+
+// writeAttributeIndex replaces attribute index file, names of indexed
+// attributes are written as markers to know which ones it covers
+func writeAttributeIndex(sto engine.Storage, entries []*index.Entry, indexed []string) error {
+	all := make([]*index.Entry, 0, len(entries)+len(indexed))
+	for _, name := range indexed {
+		all = append(all, &index.Entry{Key: index.AttributeMarker(name)})
+	}
+	all = append(all, entries...)
+
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileAttributeIndex}, func(w engine.Writer) error {
+		return index.WriteSortedIndex(w, all, index.DefaultSampleInterval)
+	})
+}
+
+// attributeMarkers returns names of attributes covered by attribute index
+func attributeMarkers(si *index.SortedIndex) []string {
+	names := make([]string, 0)
+	si.Iterate(func(e *index.Entry) bool {
+		if !index.IsAttributeMarker(e.Key) {
+			return false
+		}
+		names = append(names, strings.TrimPrefix(e.Key, index.AttributeMarker("")))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// openAttributeIndex opens attribute index file, it is rebuilt from data
+// file if it does not exist or does not cover indexed attributes. A nil
+// indexed keeps attribute index as it is
+func (d *DataHolder) openAttributeIndex(indexed []string) error {
+	desc := engine.FileDesc{Type: engine.FileAttributeIndex}
+
+	if d.sto.Exists(desc) {
+		err := d.loadAttributeIndex()
+		if err == nil && (indexed == nil || reflect.DeepEqual(attributeMarkers(d.aindex), indexed)) {
+			return nil
+		}
+	}
+
+	if indexed == nil {
+		return nil
+	}
+
+	d.log.Infof("Rebuilding attribute index of %s", d.path)
+
+	// only the current revision of each key is indexed
+	entries := make([]*index.Entry, 0)
+	_, err := scanDataFile(d.sto, engine.FileDesc{Type: engine.FileData}, func(offset int64, df *model.DataDefinition) {
+		if e, ok := d.sindex.LookUp(df.Key); ok && e.Offset == offset {
+			entries = append(entries, attributeEntries(df, offset, indexed)...)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeAttributeIndex(d.sto, entries, indexed); err != nil {
+		return err
+	}
+
+	return d.loadAttributeIndex()
+}
+
+func (d *DataHolder) loadAttributeIndex() error {
+	si, freader, err := openIndexFile(d.sto, engine.FileDesc{Type: engine.FileAttributeIndex})
+	if err != nil {
+		return err
+	}
+
+	if d.aindexFile != nil {
+		d.aindexFile.Close()
+	}
+	d.aindex, d.aindexFile = si, freader
+	return nil
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+const (
+	// blobKeyPrefix starts keys of blob records, keys of data can not hold NUL
+	blobKeyPrefix = "\x00blob\x00"
+
+	// blobRefSep separates blob id and history index key in blob index keys
+	blobRefSep = "\x00"
+
+	// chunkRefPrefix starts ids of blob index entries of records that
+	// refer to chunks in another data file, it is followed by its name
+	chunkRefPrefix = "chunks:"
+)
+
+// blobID returns content address of b, SHA-256 of b and its size
+func blobID(b []byte) string {
+	return fmt.Sprintf("%x-%d", sha256.Sum256(b), len(b))
+}
+
+// blobSize returns size of data of blob id
+func blobSize(id string) int64 {
+	n, _ := strconv.ParseInt(id[strings.LastIndexByte(id, '-')+1:], 10, 64)
+	return n
+}
+
+// blobKey returns key of the record of blob id
+func blobKey(id string) string {
+	return blobKeyPrefix + id
+}
+
+// blobRefEntry returns blob index entry of record df at offset, its key
+// is the blob id followed by the history index key of the record
+func blobRefEntry(df *model.DataDefinition, offset int64) *index.Entry {
+	return refEntry(df.Blob, df, offset)
+}
+
+func refEntry(id string, df *model.DataDefinition, offset int64) *index.Entry {
+	return &index.Entry{
+		Key:      id + blobRefSep + index.RevisionKey(df.Key, df.Revision),
+		Offset:   offset,
+		Status:   df.Status,
+		Revision: df.Revision,
+	}
+}
+
+// refEntries returns blob index entries of record df at offset, one for
+// the blob it refers to and one for each other data file holding its chunks
+func refEntries(df *model.DataDefinition, offset int64) []*index.Entry {
+	entries := make([]*index.Entry, 0)
+	if len(df.Blob) > 0 {
+		entries = append(entries, blobRefEntry(df, offset))
+	}
+
+	for _, file := range chunkFiles(df) {
+		entries = append(entries, refEntry(chunkRefPrefix+strconv.FormatInt(file, 10), df, offset))
+	}
+	return entries
+}
+
+// chunkFiles returns names of the other data files holding chunks of df
+func chunkFiles(df *model.DataDefinition) []int64 {
+	files := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, file := range df.ChunkFiles {
+		if file != 0 && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// chunkRefFile returns name of data file of a chunk reference id,
+// false if id is of a blob
+func chunkRefFile(id string) (int64, bool) {
+	if !strings.HasPrefix(id, chunkRefPrefix) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(id[len(chunkRefPrefix):], 10, 64)
+	return n, err == nil
+}
+
+// pinChunkFiles pins data files holding chunks of df, db.mu must be held
+func (db *Database) pinChunkFiles(df *model.DataDefinition) {
+	for _, file := range chunkFiles(df) {
+		db.pins[file]++
+	}
+}
+
+// unpin releases n pins of data file named file, db.mu must be held
+func (db *Database) unpin(file int64, n int) {
+	if db.pins[file] -= n; db.pins[file] <= 0 {
+		delete(db.pins, file)
+	}
+}
+
+// splitBlobRefKey returns blob id and history index key of blob index key
+func splitBlobRefKey(key string) (string, string) {
+	i := strings.Index(key, blobRefSep)
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i], key[i+len(blobRefSep):]
+}
+
+// writeBlobIndex replaces blob index file
+func writeBlobIndex(sto engine.Storage, entries []*index.Entry) error {
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileBlobIndex}, func(w engine.Writer) error {
+		return index.WriteSortedIndex(w, entries, index.DefaultSampleInterval)
+	})
+}
+
+// openBlobIndex opens blob index file, it is rebuilt from
+// data file if it does not exist or can not be loaded
+func (d *DataHolder) openBlobIndex() error {
+	desc := engine.FileDesc{Type: engine.FileBlobIndex}
+
+	if d.sto.Exists(desc) {
+		if err := d.loadBlobIndex(); err == nil {
+			return nil
+		}
+	}
+
+	d.log.Infof("Rebuilding blob index of %s", d.path)
+
+	entries := make([]*index.Entry, 0)
+	_, err := scanDataFile(d.sto, engine.FileDesc{Type: engine.FileData}, func(offset int64, df *model.DataDefinition) {
+		entries = append(entries, refEntries(df, offset)...)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeBlobIndex(d.sto, entries); err != nil {
+		return err
+	}
+
+	return d.loadBlobIndex()
+}
+
+func (d *DataHolder) loadBlobIndex() error {
+	si, freader, err := openIndexFile(d.sto, engine.FileDesc{Type: engine.FileBlobIndex})
+	if err != nil {
+		return err
+	}
+
+	if d.bindexFile != nil {
+		d.bindexFile.Close()
+	}
+	d.bindex, d.bindexFile = si, freader
+	return nil
+}
+
+// countBlobRefs counts records of commitlog and data holders that
+// refer to each blob and to chunks in each data file
+func (db *Database) countBlobRefs() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.blobs = make(map[string]int)
+	db.pins = make(map[int64]int)
+	count := func(e *index.Entry) bool {
+		id, _ := splitBlobRefKey(e.Key)
+		if file, ok := chunkRefFile(id); ok {
+			db.pins[file]++
+		} else {
+			db.blobs[id]++
+		}
+		return true
+	}
+
+	for _, e := range db.commitlog.BlobRefEntries() {
+		count(e)
+	}
+
+	for i := range db.dhList {
+		err := db.dhList[i].bindex.Iterate(count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBlob makes df refer to a blob with its data. Blob is written unless
+// one with the same content is stored and is not being dropped by compaction
+func (db *Database) storeBlob(df *model.DataDefinition) error {
+	if blob := db.newBlob(df); blob != nil {
+		if err := db.appendData(blob); err != nil {
+			return err
+		}
+	}
+
+	df.Blob, df.Buf = blobID(df.Buf), nil
+	return nil
+}
+
+// newBlob returns blob record with data of df, nil if
+// the blob is stored and is not being dropped
+func (db *Database) newBlob(df *model.DataDefinition) *model.DataDefinition {
+	id := blobID(df.Buf)
+	key := blobKey(id)
+
+	e, _, ok := db.GetDataIndexByKey(key)
+	if ok && e.Status == model.DataDefinitionBlob && !db.dropping[id] {
+		return nil
+	}
+
+	rev := uint32(1)
+	if ok {
+		rev = e.Revision + 1
+	}
+
+	return &model.DataDefinition{
+		Key:      key,
+		Size:     uint32(len(df.Buf)),
+		Status:   model.DataDefinitionBlob,
+		Revision: rev,
+		Buf:      df.Buf,
+	}
+}
+
+// loadBlob sets data of df from the blob it refers to,
+// returns false if the blob can not be read
+func (db *Database) loadBlob(df *model.DataDefinition) bool {
+	if df == nil || len(df.Blob) == 0 {
+		return true
+	}
+
+	blob, ok := db.getDataByKey(blobKey(df.Blob))
+	if !ok || blob.Status != model.DataDefinitionBlob {
+		db.log.Errorf("%s blob %s of %s not found", db.Descriptor.Name, df.Blob, df.Key)
+		return false
+	}
+
+	df.Buf = blob.Buf
+	return true
+}
+
+// dedupInfo returns number of stored blobs and bytes that were not
+// stored because records refer to the same blob, db.mu must be held
+func (db *Database) dedupInfo() (int, int64) {
+	var saved int64
+	for id, refs := range db.blobs {
+		if refs > 1 {
+			saved += int64(refs-1) * blobSize(id)
+		}
+	}
+	return len(db.blobs), saved
+}
+
+// mergeBlobRefs returns number of references to each blob that merge
+// of members drops and blob index entries of kept records by member.
+// Chunks of kept records are copied to merged data holder, their
+// references to other data files are always dropped
+func mergeBlobRefs(dhList []DataHolder, members []int, records []mergedRecord) (map[string]int, error) {
+	kept := make(map[int]map[string]bool)
+	for _, rec := range records {
+		if kept[rec.member] == nil {
+			kept[rec.member] = make(map[string]bool)
+		}
+		kept[rec.member][rec.entry.Key] = true
+	}
+
+	dropped := make(map[string]int)
+	for _, m := range members {
+		err := dhList[m].bindex.Iterate(func(e *index.Entry) bool {
+			id, revKey := splitBlobRefKey(e.Key)
+			if _, chunks := chunkRefFile(id); chunks || !kept[m][revKey] {
+				dropped[id]++
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dropped, nil
+}
+
+// dropUnreferencedBlobs removes from records the blobs no record will refer
+// to after merge. They are marked as dropping until merge finishes, a write
+// of the same content stores the blob again. Returns the marked blobs
+func (db *Database) dropUnreferencedBlobs(records []mergedRecord, dropped map[string]int) ([]mergedRecord, []string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	marked := make([]string, 0)
+	kept := records[:0]
+	for _, rec := range records {
+		if rec.entry.Status == model.DataDefinitionBlob {
+			key, _ := index.SplitRevisionKey(rec.entry.Key)
+			id := strings.TrimPrefix(key, blobKeyPrefix)
+
+			if db.blobs[id]-dropped[id] <= 0 {
+				if !db.dropping[id] {
+					db.dropping[id] = true
+					marked = append(marked, id)
+				}
+				continue
+			}
+		}
+		kept = append(kept, rec)
+	}
+	return kept, marked
+}
+
+// releaseBlobRefs applies references dropped by a merge, db.mu must be held
+func (db *Database) releaseBlobRefs(dropped map[string]int) {
+	for id, n := range dropped {
+		if file, ok := chunkRefFile(id); ok {
+			db.unpin(file, n)
+			continue
+		}
+		if db.blobs[id] -= n; db.blobs[id] <= 0 {
+			delete(db.blobs, id)
+		}
+	}
+}
+
+// unmarkBlobs ends drop of blobs marked by dropUnreferencedBlobs
+func (db *Database) unmarkBlobs(marked []string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, id := range marked {
+		delete(db.dropping, id)
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_Dedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "dedup",
+		Path:                 dir,
+		MaxDataLogSize:       512,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		MaxRevisions:         1,
+		Dedup:                true,
+	}
+	db := NewDatabase(descriptor)
+
+	content := []byte("same image content")
+	for _, key := range []string{"a", "b"} {
+		df := newTestDataDefinition(key)
+		df.Buf, df.Size = content, uint32(len(content))
+		if _, err := db.InsertCheckUpsert(df, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info := db.Info(); info.DedupBlobs != 1 || info.DedupSaved != int64(len(content)) {
+		t.Fatalf("expected 1 blob saving %d bytes, got %d and %d", len(content), info.DedupBlobs, info.DedupSaved)
+	}
+
+	df, ok := db.GetDataByKey("b")
+	if !ok || string(df.Buf) != string(content) {
+		t.Fatal("data of deduplicated key not read")
+	}
+
+	if _, err := db.InsertCheckUpsert(&model.DataDefinition{Key: blobKey(df.Blob)}, true); err == nil {
+		t.Fatal("blob key must be reserved")
+	}
+
+	page, err := db.ListKeys("", "", 10)
+	if err != nil || len(page.Keys) != 2 {
+		t.Fatalf("expected keys a and b, got %v %v", page, err)
+	}
+
+	// blob is kept while b refers to it
+	a, _ := db.GetDataByKey("a")
+	if _, err := db.InsertCheckUpsert(model.NewTombstone(a), true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doCompaction(db)
+	db.Close()
+
+	db = openTestDatabase(t, descriptor)
+	df, ok = db.GetDataByKey("b")
+	if !ok || string(df.Buf) != string(content) {
+		t.Fatal("blob referred to by b dropped")
+	}
+	if db.blobs[blobID(content)] != 1 {
+		t.Fatalf("expected 1 reference, got %d", db.blobs[blobID(content)])
+	}
+
+	// unreferenced blob is dropped
+	if _, err := db.InsertCheckUpsert(model.NewTombstone(df), true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 8; i < 16; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doCompaction(db)
+	defer db.Close()
+
+	if _, _, ok := db.GetDataIndexByKey(blobKey(blobID(content))); ok {
+		t.Fatal("unreferenced blob not dropped")
+	}
+	if _, ok := db.blobs[blobID(content)]; ok {
+		t.Fatal("references to dropped blob kept")
+	}
+}
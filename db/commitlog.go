@@ -1,6 +1,8 @@
 package db
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -17,45 +19,84 @@ import (
 const (
 	// FolderCommitlog keeps default commitlog folder name
 	FolderCommitlog = "commitlog"
+
+	// SyncAlways commitlog is synced to disk after each write
+	SyncAlways = "always"
+
+	// SyncBatch commitlog is synced to disk periodically
+	SyncBatch = "batch"
+
+	// SyncNone commitlog sync is left to operating system
+	SyncNone = "none"
 )
 
 // Commitlog holds commitlog information
 type Commitlog struct {
-	filepath string
-	sto      engine.Storage
-	summary  *index.Summary
-	mu       sync.RWMutex
-	desc     engine.FileDesc
+	filepath   string
+	sto        engine.Storage
+	summary    *index.Summary
+	mu         sync.RWMutex
+	desc       engine.FileDesc
+	syncPolicy string
+	dirty      bool
+	refs       *fileRefs
+	log        slog.Logger
+
+	// batch is set while writes of a batch are added,
+	// they are synced together when it ends
+	batch bool
+
+	// attribute index of commitlog, attrKeys keeps the
+	// attribute index keys of each data key
+	indexed  []string
+	attrs    *index.Summary
+	attrKeys map[string][]string
+
+	// history index entries of each key, oldest revision first
+	history map[string][]*index.Entry
+
+	// blob index entries of records that refer to a blob
+	// or to chunks in another data file
+	blobRefs []*index.Entry
+
+	// number of ChunkWriter that wrote chunks to commitlog, orphans
+	// is set when one closed without its record and sealed is the
+	// name of data holder commitlog was made. db.mu guards them,
+	// writers is added to while it is read locked
+	writers int32
+	orphans bool
+	sealed  int64
 }
 
 // Get returns ByteStream with requested data, nil if not found
 func (c *Commitlog) Get(key string) *util.ByteStream {
-	return c.GetByHash(util.DefaultHash(key))
-}
-
-// GetByHash returns ByteStream with requested data, nil if not found
-func (c *Commitlog) GetByHash(hKey uint32) *util.ByteStream {
 	// Search in index if found, get from data file
-	if idx, ok := c.summary.LookUp(hKey); ok == true {
-		freader, err := c.sto.Open(c.desc)
-		if err != nil {
-			slog.Warnf(err.Error())
-		}
+	if idx, ok := c.summary.LookUp(key); ok == true {
+		return c.GetAt(idx.Offset)
+	}
+	return nil
+}
 
-		r := newReader(freader.(io.ReaderAt))
+// GetAt returns ByteStream of record at offset, nil if it can not be read
+func (c *Commitlog) GetAt(offset int64) *util.ByteStream {
+	freader, err := c.sto.Open(c.desc)
+	if err != nil {
+		c.log.Warnf(err.Error())
+		return nil
+	}
+	defer freader.Close()
 
-		// If found key but can't load it from file, it will return nil to avoid
-		// db crash. Returning nil will send to user empty query result
-		b, err := r.Read(idx.Offset)
-		if err != nil {
-			slog.Errorf(errors.ErrFileCorrupted.Error(), c.filepath)
-			return nil
-		}
+	r := newReader(freader.(io.ReaderAt))
 
-		bs := util.NewByteStreamFromBytes(b)
-		return bs
+	// If found key but can't load it from file, it will return nil to avoid
+	// db crash. Returning nil will send to user empty query result
+	b, err := r.Read(offset)
+	if err != nil {
+		c.log.Errorf(errors.ErrFileCorrupted.Error(), c.filepath)
+		return nil
 	}
-	return nil
+
+	return util.NewByteStreamFromBytes(b)
 }
 
 // Keys return all data keys from commitlog
@@ -63,91 +104,358 @@ func (c *Commitlog) Keys() []string {
 	keys := make([]string, 0)
 
 	summary := c.summary.GetTable()
-	for _, v := range summary {
-		bs := c.GetByHash(v.Key)
-		df := model.NewDataDefinitionFromByteStream(bs)
-		keys = append(keys, df.Key)
+	for key := range summary {
+		keys = append(keys, key)
 	}
 
 	return keys
 }
 
-// Add add entry to commitlog
-func (c *Commitlog) Add(key string, status uint16, rev uint32, bs *util.ByteStream) error {
+// Add add entry to commitlog, expires is expiry time of its data
+func (c *Commitlog) Add(key string, status uint16, rev uint32, expires int64, bs *util.ByteStream) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	var err error
 
+	pos, err := c.appendRecord(key, bs)
+	if err != nil {
+		return err
+	}
+
+	e := &index.Entry{Key: key, Offset: pos, Status: status, Revision: rev, Expires: expires}
+	if err = c.writeIndex(e); err != nil {
+		c.sto.Truncate(c.desc, pos)
+		return err
+	}
+	c.indexRecord(e, bs)
+
+	if !c.syncEach() {
+		c.dirty = true
+	}
+
+	return nil
+}
+
+// commitRecord holds a record of a batch record
+type commitRecord struct {
+	key     string
+	status  uint16
+	rev     uint32
+	expires int64
+	bs      *util.ByteStream
+}
+
+// AddBatch writes records in one batch record, its checksum
+// makes them be read and replayed all or none
+func (c *Commitlog) AddBatch(records []commitRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// records are framed as they are in data file, they are
+	// read at their offset like the others
+	inner := new(bytes.Buffer)
+	offsets := make([]int64, len(records))
+	for i, r := range records {
+		offsets[i] = int64(inner.Len())
+		if err := writeRecord(inner, r.bs.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	bs := batchRecord(inner.Bytes())
+	pos, err := c.appendRecord("", bs)
+	if err != nil {
+		return err
+	}
+
+	// data of batch record ends the record
+	base := pos + recordSizeMark + recordChecksumMark + int64(bs.Size()-inner.Len())
+	entries := make([]*index.Entry, len(records))
+	for i, r := range records {
+		entries[i] = &index.Entry{Key: r.key, Offset: base + offsets[i], Status: r.status, Revision: r.rev, Expires: r.expires}
+	}
+
+	if err = c.writeIndex(entries...); err != nil {
+		c.sto.Truncate(c.desc, pos)
+		return err
+	}
+	for i, r := range records {
+		c.indexRecord(entries[i], r.bs)
+	}
+
+	if !c.syncEach() {
+		c.dirty = true
+	}
+
+	return nil
+}
+
+// indexRecord adds record of entry e to attribute,
+// history and blob indexes of commitlog
+func (c *Commitlog) indexRecord(e *index.Entry, bs *util.ByteStream) {
+	df := model.NewDataDefinitionHeaderFromByteStream(util.NewByteStreamFromBytes(bs.Bytes()))
+	if len(c.indexed) > 0 {
+		c.indexAttributes(df, e.Offset)
+	}
+	c.history[e.Key] = append(c.history[e.Key], historyEntry(e.Key, e.Offset, e.Status, e.Revision))
+	c.blobRefs = append(c.blobRefs, refEntries(df, e.Offset)...)
+}
+
+// AddChunk appends a chunk record, it is not indexed.
+// Returns its offset
+func (c *Commitlog) AddChunk(key string, bs *util.ByteStream) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pos, err := c.appendRecord(key, bs)
+	if err != nil {
+		return 0, err
+	}
+
+	if !c.syncEach() {
+		c.dirty = true
+	}
+	return pos, nil
+}
+
+// appendRecord writes record at the end of commitlog and returns its offset
+func (c *Commitlog) appendRecord(key string, bs *util.ByteStream) (int64, error) {
 	fwriter, err := c.sto.Create(c.desc)
+	if err != nil {
+		return 0, err
+	}
+
 	pos, err := c.sto.Size(c.desc)
+	if err != nil {
+		fwriter.Close()
+		return 0, err
+	}
 
 	writer := newWriter(fwriter)
+	defer writer.Close()
 
-	if err = writer.Append(key, bs.Bytes()); err == nil {
-		hKey := util.DefaultHash(key)
+	if err = writer.Append(key, bs.Bytes()); err != nil {
+		c.sto.Truncate(c.desc, pos)
+		return 0, err
+	}
 
-		if eidx := c.writeIndex(&index.Entry{
-			Key:      hKey,
-			Offset:   pos,
-			Status:   status,
-			Revision: rev,
-		}); eidx != nil {
-			c.sto.Truncate(pos)
+	if c.syncEach() {
+		if err = writer.Sync(); err != nil {
+			c.sto.Truncate(c.desc, pos)
+			return 0, err
 		}
+	}
+	return pos, nil
+}
 
-		writer.Close()
-	} else {
-		c.sto.Truncate(pos)
+func (c *Commitlog) writeIndex(entries ...*index.Entry) error {
+	fwriter, err := c.sto.Create(engine.FileDesc{Type: engine.FileIndex})
+	if err != nil {
+		return err
+	}
+
+	writer := newBufWriter(fwriter)
+	defer writer.Close()
+
+	for _, e := range entries {
+		if err = writer.Append(e.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if c.syncEach() {
+		if err = writer.Sync(); err != nil {
+			return err
+		}
 	}
 
-	c.sto.Close()
-	return err
+	for _, e := range entries {
+		c.summary.Add(e)
+	}
+	return nil
 }
 
-func (c *Commitlog) writeIndex(index *index.Entry) error {
-	var err error
+// Sync commits commitlog data and index files to stable storage
+// if there were writes since last sync
+func (c *Commitlog) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	fwriter, err := c.sto.Create(engine.FileDesc{Type: engine.FileIndex})
+	if !c.dirty {
+		return nil
+	}
 
-	writer := newBufWriter(fwriter)
-	if err = writer.Append(index.Bytes()); err == nil {
-		c.summary.Add(index)
-		writer.Close()
+	for _, desc := range []engine.FileDesc{c.desc, {Type: engine.FileIndex}} {
+		fwriter, err := c.sto.Create(desc)
+		if err != nil {
+			return err
+		}
+
+		err = fwriter.Sync()
+		fwriter.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// syncEach checks if each write is synced to disk as it is added
+func (c *Commitlog) syncEach() bool {
+	return c.syncPolicy == SyncAlways && !c.batch
+}
+
+// validSyncPolicy checks if policy is SyncAlways, SyncBatch or SyncNone
+func validSyncPolicy(policy string) bool {
+	return policy == SyncAlways || policy == SyncBatch || policy == SyncNone
+}
+
+// BeginBatch starts a batch of writes, with SyncAlways
+// policy they are synced once by EndBatch
+func (c *Commitlog) BeginBatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batch = true
+}
+
+// EndBatch ends batch of writes, they are synced
+// to disk if sync policy is SyncAlways
+func (c *Commitlog) EndBatch() error {
+	c.mu.Lock()
+	c.batch = false
+	c.mu.Unlock()
+
+	if c.syncPolicy != SyncAlways {
+		return nil
+	}
+	return c.Sync()
+}
+
+// indexAttributes replaces attribute index entries of df key
+func (c *Commitlog) indexAttributes(df *model.DataDefinition, offset int64) {
+	for _, akey := range c.attrKeys[df.Key] {
+		c.attrs.Remove(akey)
+	}
+	delete(c.attrKeys, df.Key)
+
+	for _, e := range attributeEntries(df, offset, c.indexed) {
+		c.attrs.Add(e)
+		c.attrKeys[df.Key] = append(c.attrKeys[df.Key], e.Key)
+	}
+}
+
+// AttributeEntries returns attribute index entries of commitlog
+func (c *Commitlog) AttributeEntries() []*index.Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]*index.Entry, 0, len(c.attrs.GetTable()))
+	for _, e := range c.attrs.GetTable() {
+		entries = append(entries, e)
 	}
+	return entries
+}
 
-	return err
+// HistoryEntries returns history index entries of commitlog
+func (c *Commitlog) HistoryEntries() []*index.Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]*index.Entry, 0, len(c.history))
+	for _, revs := range c.history {
+		entries = append(entries, revs...)
+	}
+	return entries
 }
 
-// LoadData loads commitlog data file
-func (c *Commitlog) LoadData() {
-	desc := engine.FileDesc{Type: engine.FileIndex}
-	var pos int64
+// BlobRefEntries returns blob index entries of commitlog
+func (c *Commitlog) BlobRefEntries() []*index.Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*index.Entry(nil), c.blobRefs...)
+}
+
+// Revisions returns history index entries of key, newest revision first
+func (c *Commitlog) Revisions(key string) []*index.Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	if !c.sto.Exists(desc) {
-		return
+	revs := c.history[key]
+	entries := make([]*index.Entry, 0, len(revs))
+	for i := len(revs) - 1; i >= 0; i-- {
+		entries = append(entries, revs[i])
 	}
+	return entries
+}
 
-	size, err := c.sto.Size(desc)
+// SetIndexedAttributes sets names of attributes with secondary
+// index, it must be called before LoadData
+func (c *Commitlog) SetIndexedAttributes(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexed = names
+}
+
+// SetSyncPolicy sets when commitlog writes are synced to disk,
+// SyncAlways, SyncBatch or SyncNone
+func (c *Commitlog) SetSyncPolicy(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncPolicy = policy
+}
+
+// LoadData loads commitlog data file. Records are checked against their
+// checksum, an incomplete last record left by an interrupted write is
+// truncated, unreadable records followed by others are skipped and the
+// index file is rebuilt from data file when it does not match
+func (c *Commitlog) LoadData() error {
+	entries := make([]*index.Entry, 0)
+
+	end, unreadable, err := scanRecords(c.sto, c.desc, scanSkip, func(offset int64, df *model.DataDefinition) {
+		entries = append(entries, &index.Entry{
+			Key:      df.Key,
+			Offset:   offset,
+			Status:   df.Status,
+			Revision: df.Revision,
+			Expires:  df.Expires,
+		})
+		c.indexAttributes(df, offset)
+		c.history[df.Key] = append(c.history[df.Key], historyEntry(df.Key, offset, df.Status, df.Revision))
+		c.blobRefs = append(c.blobRefs, refEntries(df, offset)...)
+	})
 	if err != nil {
-		slog.Fatalf(err.Error())
+		return fmt.Errorf("%s: %s", c.filepath, err)
+	}
+
+	for _, r := range unreadable {
+		c.log.Errorf(errors.ErrSkippedRecord.Error(), c.filepath, r.Start, r.End-r.Start)
 	}
 
-	freader, err := c.sto.Open(desc)
+	size, err := c.sto.Size(c.desc)
 	if err != nil {
-		slog.Fatalf(err.Error())
+		return err
 	}
 
-	r := newReader(freader.(io.ReaderAt))
+	if end < size {
+		c.log.Warnf(errors.ErrTornWrite.Error(), c.filepath, end, size-end)
+		if err := c.sto.Truncate(c.desc, end); err != nil {
+			return err
+		}
+	}
 
-	for pos < size {
-		if b, err := r.Read(pos); err == nil {
-			bs := util.NewByteStreamFromBytes(b)
-			c.summary.Add(index.NewEntryFromByteStream(bs))
-			pos += int64(bs.Size()) + 4
-		} else {
-			slog.Fatalf(err.Error())
+	idxEntries, err := readIndexFile(c.sto)
+	if err != nil || !sameIndexEntries(idxEntries, entries) {
+		c.log.Warnf("Rebuilding commitlog index from data file: %s", c.filepath)
+		if err := writeIndexFile(c.sto, entries); err != nil {
+			return err
 		}
 	}
+
+	for _, e := range entries {
+		c.summary.Add(e)
+	}
+	return nil
 }
 
 // Size returns commitlog file size
@@ -167,18 +475,28 @@ func (c *Commitlog) RenameTo(newpath string) {
 	os.Rename(c.filepath, newpath)
 }
 
-// NewCommitLog returns new Commitlog
+// NewCommitLog returns new Commitlog logging with default logger
 func NewCommitLog(path string) *Commitlog {
+	return openCommitLog(path, slog.Default())
+}
+
+func openCommitLog(path string, log slog.Logger) *Commitlog {
 	var err error
 
 	c := Commitlog{}
 	c.filepath = filepath.Join(path, FolderCommitlog)
 	c.summary = index.NewSummary()
+	c.attrs = index.NewSummary()
+	c.attrKeys = make(map[string][]string)
+	c.history = make(map[string][]*index.Entry)
 	c.desc = engine.FileDesc{Type: engine.FileCommitlog}
+	c.syncPolicy = SyncNone
+	c.refs = newFileRefs()
+	c.log = log
 
 	c.sto, err = engine.OpenFile(c.filepath)
 	if err != nil {
-		slog.Fatalf(err.Error())
+		c.log.Fatalf(err.Error())
 	}
 
 	return &c
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func newTestDataDefinition(key string) *model.DataDefinition {
+	buf := []byte("image content of " + key)
+	return &model.DataDefinition{
+		Key:    key,
+		Token:  "00000000-0000-1000-8000-000000000000",
+		Ext:    "png",
+		Size:   uint32(len(buf)),
+		Status: model.DataDefinitionActive,
+		Buf:    buf,
+	}
+}
+
+func openTestDatabase(t *testing.T, descriptor DatabaseDescriptor) *Database {
+	db, err := OpenDatabase(descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func fillCommitlog(t *testing.T, dir string, n int) (*Commitlog, []int64) {
+	c := NewCommitLog(dir)
+	sizes := make([]int64, 0, n)
+
+	for i := 0; i < n; i++ {
+		df := newTestDataDefinition(fmt.Sprintf("key%d", i))
+		if err := c.Add(df.Key, df.Status, df.Revision, df.Expires, df.ToByteStream()); err != nil {
+			t.Fatal(err)
+		}
+		size, _ := c.Size()
+		sizes = append(sizes, size)
+	}
+
+	return c, sizes
+}
+
+func Test_CommitlogTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, sizes := fillCommitlog(t, dir, 3)
+
+	// simulate a record interrupted after its size mark
+	dataPath := filepath.Join(dir, FolderCommitlog, "commitlog.spw")
+	f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xff, 0x00, 0x00, 0x80, 0x01, 0x02})
+	f.Close()
+
+	c := NewCommitLog(dir)
+	if err := c.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := c.Size(); size != sizes[2] {
+		t.Fatalf("expected commitlog size %d after recovery, got %d", sizes[2], size)
+	}
+
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key%d", i)
+		bs := c.Get(key)
+		if bs == nil {
+			t.Fatalf("key %s not found after recovery", key)
+		}
+		if df := model.NewDataDefinitionFromByteStream(bs); df.Key != key {
+			t.Fatalf("expected key %s, got %s", key, df.Key)
+		}
+	}
+}
+
+func Test_CommitlogChecksumAndIndexRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, sizes := fillCommitlog(t, dir, 3)
+
+	// corrupt last byte of the last record and remove index file
+	dataPath := filepath.Join(dir, FolderCommitlog, "commitlog.spw")
+	b, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	ioutil.WriteFile(dataPath, b, 0644)
+	os.Remove(filepath.Join(dir, FolderCommitlog, "index.spw"))
+
+	c := NewCommitLog(dir)
+	if err := c.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := c.Size(); size != sizes[1] {
+		t.Fatalf("expected commitlog size %d after recovery, got %d", sizes[1], size)
+	}
+	if c.Get("key1") == nil {
+		t.Fatal("key1 not found after index rebuild")
+	}
+	if c.Get("key2") != nil {
+		t.Fatal("corrupted key2 must not be loaded")
+	}
+
+	entries, err := readIndexFile(c.sto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries in rebuilt index, got %d", len(entries))
+	}
+}
+
+func Test_CommitlogSkipCorruptedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, sizes := fillCommitlog(t, dir, 3)
+
+	// corrupt last byte of the record in the middle of the file
+	dataPath := filepath.Join(dir, FolderCommitlog, "commitlog.spw")
+	b, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[sizes[1]-1] ^= 0xff
+	ioutil.WriteFile(dataPath, b, 0644)
+
+	c := NewCommitLog(dir)
+	if err := c.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := c.Size(); size != sizes[2] {
+		t.Fatalf("expected commitlog size %d kept, got %d", sizes[2], size)
+	}
+	if c.Get("key0") == nil || c.Get("key2") == nil {
+		t.Fatal("records around corrupted one not loaded")
+	}
+	if c.Get("key1") != nil {
+		t.Fatal("corrupted key1 must not be loaded")
+	}
+}
+
+func Test_CommitlogCorruptedSizeMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, sizes := fillCommitlog(t, dir, 3)
+
+	// size mark of the record in the middle of the file
+	// points past it, next record is searched
+	dataPath := filepath.Join(dir, FolderCommitlog, "commitlog.spw")
+	b, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[sizes[0]+2] ^= 0xff
+	ioutil.WriteFile(dataPath, b, 0644)
+
+	c := NewCommitLog(dir)
+	if err := c.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := c.Size(); size != sizes[2] {
+		t.Fatalf("expected commitlog size %d kept, got %d", sizes[2], size)
+	}
+	if c.Get("key0") == nil || c.Get("key2") == nil {
+		t.Fatal("records around corrupted one not loaded")
+	}
+	if c.Get("key1") != nil {
+		t.Fatal("corrupted key1 must not be loaded")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/SparrowDb/sparrowdb/db/index"
@@ -19,8 +20,64 @@ import (
 type DataHolder struct {
 	path        string
 	sto         engine.Storage
-	summary     index.Summary
+	sindex      *index.SortedIndex
+	sindexFile  engine.Reader
+	aindex      *index.SortedIndex
+	aindexFile  engine.Reader
+	hindex      *index.SortedIndex
+	hindexFile  engine.Reader
+	bindex      *index.SortedIndex
+	bindexFile  engine.Reader
 	bloomfilter util.BloomFilter
+	refs        *fileRefs
+	log         slog.Logger
+}
+
+// fileRefs counts DataStream reading chunks from a data file, a data
+// holder replaced by compaction is deleted after the last one is closed
+type fileRefs struct {
+	mu      sync.Mutex
+	n       int
+	release func()
+}
+
+func newFileRefs() *fileRefs {
+	return &fileRefs{}
+}
+
+// acquire adds a reader of data file
+func (f *fileRefs) acquire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+}
+
+// done removes a reader of data file, data file is
+// released if it was retired and it was the last one
+func (f *fileRefs) done() {
+	f.mu.Lock()
+	f.n--
+	var release func()
+	if f.n == 0 {
+		release, f.release = f.release, nil
+	}
+	f.mu.Unlock()
+
+	if release != nil {
+		release()
+	}
+}
+
+// retire calls release when data file has no readers
+func (f *fileRefs) retire(release func()) {
+	f.mu.Lock()
+	if f.n > 0 {
+		f.release = release
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	release()
 }
 
 // Get get ByteStream from dataholder for a given position in data file
@@ -28,9 +85,10 @@ func (d *DataHolder) Get(position int64) (*util.ByteStream, error) {
 	// Search in index if found, get from data file
 	freader, err := d.sto.Open(engine.FileDesc{Type: engine.FileData})
 	if err != nil {
-		slog.Errorf(errors.ErrFileCorrupted.Error(), d.path)
+		d.log.Errorf(errors.ErrFileCorrupted.Error(), d.path)
 		return nil, nil
 	}
+	defer freader.Close()
 
 	r := newReader(freader.(io.ReaderAt))
 
@@ -38,7 +96,7 @@ func (d *DataHolder) Get(position int64) (*util.ByteStream, error) {
 	// db crash. Returning nil will send to user empty query result
 	b, err := r.Read(position)
 	if err != nil {
-		slog.Errorf(errors.ErrFileCorrupted.Error(), d.path)
+		d.log.Errorf(errors.ErrFileCorrupted.Error(), d.path)
 		return nil, nil
 	}
 
@@ -46,14 +104,183 @@ func (d *DataHolder) Get(position int64) (*util.ByteStream, error) {
 	return bs, nil
 }
 
-// GetSummary get index summary of current data gile
-func (d *DataHolder) GetSummary() index.Summary {
-	return d.summary
+// GetIndex get sorted index of current data file
+func (d *DataHolder) GetIndex() *index.SortedIndex {
+	return d.sindex
+}
+
+// sealedAt returns when data holder was created from commitlog,
+// its directory is named with unix time in nanoseconds
+func (d *DataHolder) sealedAt() time.Time {
+	n := d.name()
+	if n == 0 {
+		return time.Now()
+	}
+	return time.Unix(0, n)
+}
+
+// name returns name of data holder directory, records refer by it
+// to chunks in another data file. 0 if it is not a number
+func (d *DataHolder) name() int64 {
+	n, _ := strconv.ParseInt(filepath.Base(d.path), 10, 64)
+	return n
+}
+
+// findHolder returns data holder of dhList named name, nil if there is none
+func findHolder(dhList []DataHolder, name int64) *DataHolder {
+	for i := range dhList {
+		if dhList[i].name() == name {
+			return &dhList[i]
+		}
+	}
+	return nil
+}
+
+// Close closes data holder files
+func (d *DataHolder) Close() error {
+	if d.aindexFile != nil {
+		d.aindexFile.Close()
+	}
+	if d.hindexFile != nil {
+		d.hindexFile.Close()
+	}
+	if d.bindexFile != nil {
+		d.bindexFile.Close()
+	}
+	if d.sindexFile != nil {
+		return d.sindexFile.Close()
+	}
+	return nil
 }
 
-// NewDataHolder returns new DataHolder pointer
-func NewDataHolder(sto *engine.Storage, dbPath string, bloomFilterFp float32) (*DataHolder, error) {
+// openSortedIndex opens sorted index file, if it does not exist or can
+// not be loaded it is built from index file
+func (d *DataHolder) openSortedIndex(bloomFilterFp float32) error {
+	desc := engine.FileDesc{Type: engine.FileSortedIndex}
+
+	if d.sto.Exists(desc) {
+		if err := d.loadSortedIndex(); err == nil {
+			return nil
+		}
+		d.log.Warnf("Rebuilding sorted index of %s", d.path)
+	}
+
+	entries, err := readIndexFile(d.sto)
+	if err != nil {
+		return err
+	}
+
+	legacy := hasLegacyEntries(entries)
+	if legacy {
+		d.log.Infof("Upgrading index of %s", d.path)
+		if err := d.migrateIndex(entries, bloomFilterFp); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSortedIndex(d.sto, entries); err != nil {
+		return err
+	}
+
+	// index file is upgraded last, if anything fails
+	// before it, migration runs again on next open
+	if legacy {
+		if err := writeIndexFile(d.sto, entries); err != nil {
+			return err
+		}
+	}
+
+	return d.loadSortedIndex()
+}
+
+// migrateIndex reads from data file the keys of entries written when index
+// only kept 32 bit hash of the key. Bloom filter of these versions was built
+// with key hashes and is rebuilt
+func (d *DataHolder) migrateIndex(entries []*index.Entry, bloomFilterFp float32) error {
+	freader, err := d.sto.Open(engine.FileDesc{Type: engine.FileData})
+	if err != nil {
+		return err
+	}
+	defer freader.Close()
+
+	r := newReader(freader)
+
+	for _, e := range entries {
+		if !e.IsLegacy() {
+			continue
+		}
+
+		b, err := r.Read(e.Offset)
+		if err != nil {
+			return err
+		}
+
+		df, err := decodeRecordHeader(b)
+		if err != nil {
+			return err
+		}
+		e.Key = df.Key
+	}
+
+	bf := newBloomFilterFromEntries(entries, bloomFilterFp)
+	return writeBloomFilter(d.sto, &bf)
+}
+
+func hasLegacyEntries(entries []*index.Entry) bool {
+	for _, e := range entries {
+		if e.IsLegacy() {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DataHolder) loadSortedIndex() error {
+	si, freader, err := openIndexFile(d.sto, engine.FileDesc{Type: engine.FileSortedIndex})
+	if err != nil {
+		return err
+	}
+
+	if d.sindexFile != nil {
+		d.sindexFile.Close()
+	}
+	d.sindex, d.sindexFile = si, freader
+	return nil
+}
+
+// openIndexFile opens a sorted index file, returned reader
+// must be kept open while sorted index is used
+func openIndexFile(sto engine.Storage, desc engine.FileDesc) (*index.SortedIndex, engine.Reader, error) {
+	size, err := sto.Size(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	freader, err := sto.Open(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	si, err := index.OpenSortedIndex(freader, size)
+	if err != nil {
+		freader.Close()
+		return nil, nil, err
+	}
+	return si, freader, nil
+}
+
+// writeSortedIndex replaces sorted index file with the given entries
+func writeSortedIndex(sto engine.Storage, entries []*index.Entry) error {
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileSortedIndex}, func(w engine.Writer) error {
+		return index.WriteSortedIndex(w, entries, index.DefaultSampleInterval)
+	})
+}
+
+// NewDataHolder returns new DataHolder pointer made from commitlog c,
+// its attribute index covers indexed attributes
+func NewDataHolder(c *Commitlog, dbPath string, bloomFilterFp float32, indexed []string) (*DataHolder, error) {
 	var err error
+	sto := &c.sto
 
 	// commitlog full path
 	cPath := filepath.Join(dbPath, FolderCommitlog)
@@ -72,49 +299,103 @@ func NewDataHolder(sto *engine.Storage, dbPath string, bloomFilterFp float32) (*
 		return nil, err
 	}
 
-	// Load dataholder
-	dh := DataHolder{path: newPath}
+	// Load dataholder, streams reading chunks from
+	// commitlog go on reading them from it
+	dh := DataHolder{path: newPath, refs: c.refs, log: c.log}
 	if dh.sto, err = engine.OpenFile(newPath); err != nil {
 		return nil, err
 	}
 
-	// Load index from dataholder
-	ir := newIndexReader(&dh.sto)
-	dh.summary, err = ir.LoadIndex()
+	// Load index entries of commitlog and write them sorted
+	entries, err := readIndexFile(dh.sto)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := writeSortedIndex(dh.sto, entries); err != nil {
+		return nil, err
+	}
+
+	if err := dh.loadSortedIndex(); err != nil {
+		return nil, err
+	}
+
 	// Create and populate bloomfilter
-	table := dh.summary.GetTable()
-	dh.bloomfilter = util.NewBloomFilter(dh.summary.Count(), bloomFilterFp)
-	for _, v := range table {
-		dh.bloomfilter.Add(strconv.Itoa(int(v.Key)))
+	dh.bloomfilter = newBloomFilterFromEntries(entries, bloomFilterFp)
+	if err := writeBloomFilter(dh.sto, &dh.bloomfilter); err != nil {
+		dh.Close()
+		return nil, err
 	}
 
-	bfw, err := dh.sto.Create(engine.FileDesc{Type: engine.FileBloomFilter})
-	if err != nil {
+	if err := writeAttributeIndex(dh.sto, c.AttributeEntries(), indexed); err != nil {
+		dh.Close()
+		return nil, err
+	}
+
+	if err := dh.loadAttributeIndex(); err != nil {
+		dh.Close()
+		return nil, err
+	}
+
+	if err := writeHistoryIndex(dh.sto, c.HistoryEntries()); err != nil {
+		dh.Close()
 		return nil, err
 	}
 
-	writer := newBufWriter(bfw)
-	b, errbs := dh.bloomfilter.ByteStream()
-	if errbs != nil {
-		return nil, errbs
+	if err := dh.loadHistoryIndex(); err != nil {
+		dh.Close()
+		return nil, err
+	}
+
+	if err := writeBlobIndex(dh.sto, c.BlobRefEntries()); err != nil {
+		dh.Close()
+		return nil, err
 	}
 
-	if err = writer.Append(b.Bytes()); err == nil {
-		writer.Close()
+	if err := dh.loadBlobIndex(); err != nil {
+		dh.Close()
+		return nil, err
 	}
 
 	return &dh, nil
 }
 
-// OpenDataHolder opens data holder for a given path
-func OpenDataHolder(path string) (*DataHolder, error) {
+// newBloomFilterFromEntries creates bloomfilter with keys of all entries
+func newBloomFilterFromEntries(entries []*index.Entry, bloomFilterFp float32) util.BloomFilter {
+	count := uint32(len(entries))
+	if count == 0 {
+		count = 1
+	}
+
+	bf := util.NewBloomFilter(count, bloomFilterFp)
+	for _, v := range entries {
+		bf.Add(v.Key)
+	}
+	return bf
+}
+
+// writeBloomFilter replaces bloomfilter file
+func writeBloomFilter(sto engine.Storage, bf *util.BloomFilter) error {
+	b, err := bf.ByteStream()
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileBloomFilter}, func(w engine.Writer) error {
+		return newBufWriter(w).Append(b.Bytes())
+	})
+}
+
+// OpenDataHolder opens data holder for a given path, bloomFilterFp and
+// indexed attributes are used if bloom filter or indexes must be rebuilt
+func OpenDataHolder(path string, bloomFilterFp float32, indexed []string) (*DataHolder, error) {
+	return openDataHolder(path, bloomFilterFp, indexed, slog.Default())
+}
+
+func openDataHolder(path string, bloomFilterFp float32, indexed []string, log slog.Logger) (*DataHolder, error) {
 	var err error
 
-	dh := DataHolder{path: path}
+	dh := DataHolder{path: path, refs: newFileRefs(), log: log}
 
 	dh.sto, err = engine.OpenFile(path)
 	if err != nil {
@@ -122,27 +403,42 @@ func OpenDataHolder(path string) (*DataHolder, error) {
 	}
 
 	// Loads index
-	ir := newIndexReader(&dh.sto)
-	dh.summary, err = ir.LoadIndex()
-	if err != nil {
+	if err = dh.openSortedIndex(bloomFilterFp); err != nil {
+		return nil, err
+	}
+
+	if err = dh.openAttributeIndex(indexed); err != nil {
+		dh.Close()
+		return nil, err
+	}
+
+	if err = dh.openHistoryIndex(); err != nil {
+		dh.Close()
+		return nil, err
+	}
+
+	if err = dh.openBlobIndex(); err != nil {
+		dh.Close()
 		return nil, err
 	}
 
 	// Loads bloomfilter
 	var pos int64
-	var bfreader io.Reader
 
-	bfreader, err = dh.sto.Open(engine.FileDesc{Type: engine.FileBloomFilter})
+	bfreader, err := dh.sto.Open(engine.FileDesc{Type: engine.FileBloomFilter})
 	if err != nil {
+		dh.Close()
 		return nil, err
 	}
+	defer bfreader.Close()
 
-	r := newReader(bfreader.(io.ReaderAt))
+	r := newReader(bfreader)
 
 	if b, err := r.Read(pos); err == nil {
 		bs := util.NewByteStreamFromBytes(b)
 		dh.bloomfilter, err = util.NewBloomFilterFromByteStream(bs)
 		if err != nil {
+			dh.Close()
 			return nil, err
 		}
 	}
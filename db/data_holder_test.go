@@ -0,0 +1,73 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+func Test_DataHolderLegacyIndexMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, _ := fillCommitlog(t, dir, 3)
+	dh, err := NewDataHolder(c, dir, 0.01, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readIndexFile(dh.sto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.Close()
+
+	// rewrite index as it was when only key hash was stored
+	err = replaceFile(dh.sto, engine.FileDesc{Type: engine.FileIndex}, func(w engine.Writer) error {
+		bw := newBufWriter(w)
+		for _, e := range entries {
+			bs := util.NewByteStream()
+			bs.PutUInt32(util.DefaultHash(e.Key))
+			bs.PutUInt64(uint64(e.Offset))
+			bs.PutUInt16(e.Status)
+			bs.PutUInt32(e.Revision)
+			if err := bw.Append(bs.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dh.path, "sindex.spw"))
+
+	mdh, err := OpenDataHolder(dh.path, 0.01, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdh.Close()
+
+	for _, key := range []string{"key0", "key1", "key2"} {
+		if _, ok := mdh.sindex.LookUp(key); !ok {
+			t.Fatalf("key %s not found in migrated index", key)
+		}
+		if !mdh.bloomfilter.Contains(key) {
+			t.Fatalf("key %s not found in migrated bloom filter", key)
+		}
+	}
+
+	migrated, err := readIndexFile(mdh.sto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasLegacyEntries(migrated) {
+		t.Fatal("index file must be rewritten with full keys")
+	}
+}
@@ -3,8 +3,18 @@ package db
 import (
 	"encoding/json"
 	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/compression"
 )
 
+// Unset value of TombstoneGracePeriod and ExpirySweepInterval of
+// DatabaseDescriptor that takes the one of configuration, 0 is valid
+// for them. It is set to the ones database configuration does not hold
+const Unset = -1
+
 // XMLDatabaseList holds root node and DatabaseDescriptor
 // list
 type XMLDatabaseList struct {
@@ -14,16 +24,106 @@ type XMLDatabaseList struct {
 
 // DatabaseDescriptor holds database configuration
 type DatabaseDescriptor struct {
-	XMLName        xml.Name `xml:"database"`
-	Name           string   `xml:"name" valid:"alphanum,required,length(3|50)"`
-	MaxDataLogSize uint64   `xml:"max_datalog_size"`
-	MaxCacheSize   uint64   `xml:"max_cache_size"`
-	BloomFilterFp  float32  `xml:"bloomfilter_fpp"`
-	CronExp        string   `xml:"dataholder_cron_compaction"`
-	Path           string   `xml:"path"`
-	SnapshotPath   string   `xml:"snapshot_path"`
-	TokenActive    bool     `xml:"generate_token"`
-	ReadOnly       bool     `xml:"read_only"`
+	XMLName               xml.Name `xml:"database"`
+	Name                  string   `xml:"name" valid:"alphanum,required,length(3|50)"`
+	MaxDataLogSize        uint64   `xml:"max_datalog_size"`
+	MaxCacheSize          uint64   `xml:"max_cache_size"`
+	BloomFilterFp         float32  `xml:"bloomfilter_fpp"`
+	CronExp               string   `xml:"dataholder_cron_compaction"`
+	Path                  string   `xml:"path"`
+	SnapshotPath          string   `xml:"snapshot_path"`
+	TokenActive           bool     `xml:"generate_token"`
+	ReadOnly              bool     `xml:"read_only"`
+	CommitlogSync         string   `xml:"commitlog_sync"`
+	CommitlogSyncInterval int      `xml:"commitlog_sync_interval"`
+	CompactionTiers       string   `xml:"compaction_tiers"`
+	CompactionMinHolders  int      `xml:"compaction_min_holders"`
+	TombstoneGracePeriod  int      `xml:"tombstone_grace_period"`
+	IndexedAttributes     string   `xml:"indexed_attributes"`
+	MaxRevisions          int      `xml:"max_revisions"`
+	CacheControl          string   `xml:"cache_control"`
+	ChunkSize             uint32   `xml:"chunk_size"`
+	MaxDerivedCacheSize   uint64   `xml:"max_derived_cache_size"`
+	AllowedMediaTypes     string   `xml:"allowed_media_types"`
+	AutoOrient            bool     `xml:"auto_orient"`
+	Dedup                 bool     `xml:"dedup"`
+	Compression           string   `xml:"compression"`
+	DefaultTTL            int      `xml:"default_ttl"`
+	ExpirySweepInterval   int      `xml:"expiry_sweep_interval"`
+	SignedURLs            bool     `xml:"signed_urls"`
+}
+
+// UnmarshalXML reads DatabaseDescriptor, values with Unset that
+// are not in the XML node keep it
+func (dd *DatabaseDescriptor) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain DatabaseDescriptor
+	p := plain{TombstoneGracePeriod: Unset, ExpirySweepInterval: Unset}
+	if err := d.DecodeElement(&p, &start); err != nil {
+		return err
+	}
+	*dd = DatabaseDescriptor(p)
+	return nil
+}
+
+// IndexedAttributeNames returns names of attributes with secondary index,
+// IndexedAttributes is a comma separated list of attribute names
+func (dd *DatabaseDescriptor) IndexedAttributeNames() []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(dd.IndexedAttributes, ",") {
+		if name := strings.TrimSpace(v); len(name) > 0 && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllowsMediaType checks if data of media type t can be stored,
+// AllowedMediaTypes is a comma separated list of media types,
+// type/* allows all subtypes and */* any media type. Any media
+// type is allowed if it is empty
+func (dd *DatabaseDescriptor) AllowsMediaType(t string) bool {
+	if len(strings.TrimSpace(dd.AllowedMediaTypes)) == 0 {
+		return true
+	}
+
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	t = strings.ToLower(strings.TrimSpace(t))
+
+	for _, v := range strings.Split(dd.AllowedMediaTypes, ",") {
+		allowed := strings.ToLower(strings.TrimSpace(v))
+		if allowed == t || allowed == "*/*" ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(t, allowed[:len(allowed)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// CodecID returns id of compression codec of database, snappy
+// if Compression is not a known codec
+func (dd *DatabaseDescriptor) CodecID() uint16 {
+	if id, ok := compression.CodecID(strings.ToLower(strings.TrimSpace(dd.Compression))); ok {
+		return id
+	}
+	return compression.CodecSnappy
+}
+
+// CompactionTierSizes returns upper data file size in bytes of each
+// compaction tier, CompactionTiers is a comma separated list of sizes
+func (dd *DatabaseDescriptor) CompactionTierSizes() []int64 {
+	sizes := make([]int64, 0)
+	for _, v := range strings.Split(dd.CompactionTiers, ",") {
+		if size, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
 }
 
 // ToJSON returns DatabaseDescriptor as JSON
@@ -5,8 +5,9 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
-	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SparrowDb/sparrowdb/cache"
@@ -23,9 +24,46 @@ type Database struct {
 	commitlog  *Commitlog
 	dhList     []DataHolder
 	cache      *cache.Cache
+	derived    *cache.Cache
+	codec      uint16
+	enc        *model.Encoder
+	log        slog.Logger
 	mu         sync.RWMutex
 
-	compFinish chan bool
+	compFinish   chan bool
+	compStatus   CompactionStatus
+	compStatusMu sync.RWMutex
+
+	syncStop  chan bool
+	sweepStop chan bool
+
+	// number of ChunkWriter finished and not closed, commitlog is
+	// not made a data holder between Finish and insert of their record
+	finishing int
+
+	// set while a batch is written, new
+	// commitlog joins the batch
+	batching bool
+
+	// number of stored records that refer to each blob, blobs
+	// being dropped by compaction are in dropping
+	blobs    map[string]int
+	dropping map[string]bool
+
+	// expiry time of keys stored with one, expiryLoaded is set when
+	// the ones stored before database was opened are added
+	expiring     map[string]int64
+	expiryLoaded bool
+	sweepMu      sync.Mutex
+
+	// data holders with records removed by expiry sweep or chunks
+	// no record refers to, compaction rewrites them to reclaim space
+	reclaimIn map[string]bool
+
+	// number of records, and of ChunkWriter not closed, referring to
+	// chunks in each data holder by its name. Compaction does not
+	// merge a data holder while it has any
+	pins map[int64]int
 }
 
 // DatabaseInfo returns database information
@@ -34,18 +72,55 @@ type DatabaseInfo struct {
 	CommitlogSize int64 `json:"commitlog_size"`
 	CacheItems    int64 `json:"cache_item_count"`
 	CacheUsed     int64 `json:"cache_used_bytes"`
+	DedupBlobs    int   `json:"dedup_blob_count"`
+	DedupSaved    int64 `json:"dedup_saved_bytes"`
 }
 
 // InsertData insert data into database
 func (db *Database) InsertData(df *model.DataDefinition) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.insertData(df)
+}
+
+// insertData writes df, its data is stored as a blob if
+// database deduplicates data
+func (db *Database) insertData(df *model.DataDefinition) error {
+	if strings.HasPrefix(df.Key, blobKeyPrefix) {
+		return fmt.Errorf(errors.ErrReservedKey.Error(), df.Key)
+	}
+
+	buf := df.Buf
 
-	hKey := util.DefaultHash(df.Key)
-	bs := df.ToByteStream()
+	if db.Descriptor.Dedup && df.Status == model.DataDefinitionActive && !df.IsChunked() && len(buf) > 0 {
+		if err := db.storeBlob(df); err != nil {
+			return err
+		}
+		defer func() { df.Buf = buf }()
+	} else if len(buf) > 0 {
+		df.Blob = ""
+	}
+
+	if err := db.appendData(df); err != nil {
+		return err
+	}
+
+	if len(df.Blob) > 0 {
+		db.blobs[df.Blob]++
+	}
+	db.pinChunkFiles(df)
+	db.trackExpiry(df)
+	return nil
+}
+
+// appendData writes df to commitlog, commitlog is made
+// a data holder first if it is full
+func (db *Database) appendData(df *model.DataDefinition) error {
+	df.Codec = db.codec
+	bs := db.enc.ToByteStream(df)
 
 	// Put in cache
-	db.cache.Put(hKey, bs.Bytes())
+	db.cache.Put(df.Key, bs.Bytes())
 
 	// Get last position in commitlog
 	size, err := db.commitlog.Size()
@@ -53,40 +128,121 @@ func (db *Database) InsertData(df *model.DataDefinition) error {
 		return err
 	}
 
-	// Check if commitlog has the max file size
-	if size+int64(df.Size) > int64(db.Descriptor.MaxDataLogSize) {
-		ndh, err := NewDataHolder(&db.commitlog.sto, db.Descriptor.Path, db.Descriptor.BloomFilterFp)
-		if err != nil {
+	// Check if commitlog has the max file size, chunks of a finished
+	// ChunkWriter must stay in the same file as the record that refers to them
+	if db.finishing == 0 && size+int64(df.Size) > int64(db.Descriptor.MaxDataLogSize) {
+		if err := db.rollover(); err != nil {
 			return err
 		}
+	}
 
-		db.dhList = append(db.dhList, *ndh)
-		db.commitlog = NewCommitLog(db.Descriptor.Path)
+	if err = db.commitlog.Add(df.Key, df.Status, df.Revision, df.Expires, bs); err != nil {
+		return err
 	}
 
-	if err = db.commitlog.Add(df.Key, df.Status, df.Revision, bs); err != nil {
+	return nil
+}
+
+// rollover makes commitlog a data holder and starts a new one
+func (db *Database) rollover() error {
+	// commitlog must be on disk before it becomes a data holder
+	if err := db.commitlog.Sync(); err != nil {
 		return err
 	}
 
+	ndh, err := NewDataHolder(db.commitlog, db.Descriptor.Path, db.Descriptor.BloomFilterFp,
+		db.Descriptor.IndexedAttributeNames())
+	if err != nil {
+		return err
+	}
+
+	// ChunkWriter still writing chunks to commitlog refer to it by name
+	c := db.commitlog
+	c.sealed = ndh.name()
+	if n := atomic.LoadInt32(&c.writers); n > 0 {
+		db.pins[c.sealed] += int(n)
+	}
+	if c.orphans {
+		db.reclaimIn[ndh.path] = true
+	}
+
+	db.dhList = append(db.dhList, *ndh)
+	db.commitlog = db.newCommitlog()
+	if db.batching {
+		db.commitlog.BeginBatch()
+	}
 	return nil
 }
 
 // InsertCheckUpsert if df not exists insert it. If exits and is upsert,
-// override old data
+// override old data. df gets the revision following the stored one,
+// a new key starts at revision 1
 func (db *Database) InsertCheckUpsert(df *model.DataDefinition, upsert bool) (uint32, error) {
-	storedDf, ok := db.GetDataByKey(df.Key)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.insertCheckUpsert(df, upsert)
+}
+
+func (db *Database) insertCheckUpsert(df *model.DataDefinition, upsert bool) (uint32, error) {
+	storedDf, ok := db.getDataByKey(df.Key)
 
+	df.Revision = 1
 	if ok {
-		if storedDf.Status == model.DataDefinitionRemoved {
+		if storedDf.Status == model.DataDefinitionRemoved || storedDf.Expired(time.Now()) {
 			upsert = true
 		}
 
 		if !upsert {
 			return 0, fmt.Errorf(errors.ErrKeyExists.Error(), df.Key)
 		}
+		df.Revision = storedDf.Revision + 1
 	}
 
-	if err := db.InsertData(df); err != nil {
+	if err := db.insertData(df); err != nil {
+		return 0, err
+	}
+
+	return df.Revision, nil
+}
+
+// RevisionError is returned by a conditional write when stored
+// data of the key does not match the condition
+type RevisionError struct {
+	Key      string
+	Expected string
+	Current  uint32
+}
+
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf(errors.ErrWrongRevision.Error(), e.Key, e.Expected)
+}
+
+// InsertIf inserts df if match accepts the stored data of its key, checked
+// atomically with the write. stored is nil when key does not exist or is
+// removed. expected describes the condition in RevisionError on mismatch
+func (db *Database) InsertIf(df *model.DataDefinition, expected string, match func(stored *model.DataDefinition) bool) (uint32, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	storedDf, ok := db.getDataByKey(df.Key)
+
+	df.Revision = 1
+	if ok {
+		df.Revision = storedDf.Revision + 1
+		if storedDf.Status == model.DataDefinitionRemoved || storedDf.Expired(time.Now()) {
+			storedDf = nil
+		}
+	}
+
+	if !match(storedDf) {
+		current := uint32(0)
+		if storedDf != nil {
+			current = storedDf.Revision
+		}
+		return 0, &RevisionError{Key: df.Key, Expected: expected, Current: current}
+	}
+
+	if err := db.insertData(df); err != nil {
 		return 0, err
 	}
 
@@ -95,29 +251,38 @@ func (db *Database) InsertCheckUpsert(df *model.DataDefinition, upsert bool) (ui
 
 // GetDataByKey returns pointer to DataDefinition, bool if found the data
 // and if found in data holder, return data holder index array, or if found
-// in cache or commitlog return -1
+// in cache or commitlog return -1. Expired data is not found
 func (db *Database) GetDataByKey(key string) (*model.DataDefinition, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	df, ok := db.getDataByKey(key)
+	if ok && (df.Expired(time.Now()) || !db.loadBlob(df)) {
+		return nil, false
+	}
+	return df, ok
+}
+
+func (db *Database) getDataByKey(key string) (*model.DataDefinition, bool) {
 	defer func() {
 		if x := recover(); x != nil {
 		}
 	}()
 
-	hkey := util.DefaultHash(key)
-
 	// Search for given key in cache
-	if c := db.cache.Get(hkey); c != nil {
+	if c := db.cache.Get(key); c != nil {
 		bs := util.NewByteStreamFromBytes(c)
-		return model.NewDataDefinitionFromByteStream(bs), true
+		return db.enc.FromByteStream(bs), true
 	}
 
 	// Search in commitlog
 	if bs := db.commitlog.Get(key); bs != nil {
-		db.cache.Put(hkey, bs.Bytes())
-		return model.NewDataDefinitionFromByteStream(bs), true
+		db.cache.Put(key, bs.Bytes())
+		return db.enc.FromByteStream(bs), true
 	}
 
 	// Search in data files
-	if entry, idx, found := db.GetDataIndexByKey(hkey); found == true {
+	if entry, idx, found := db.GetDataIndexByKey(key); found == true {
 		return db.GetDataByIndexEntry(idx, entry)
 	}
 
@@ -126,18 +291,17 @@ func (db *Database) GetDataByKey(key string) (*model.DataDefinition, bool) {
 
 // GetDataIndexByKey search key in index, returns the index entry,
 // data holder index or -1 if the key is in commitlog and bool if found.
-func (db *Database) GetDataIndexByKey(hkey uint32) (*index.Entry, int, bool) {
-	strKey := strconv.Itoa(int(hkey))
+func (db *Database) GetDataIndexByKey(key string) (*index.Entry, int, bool) {
 	dhListLen := len(db.dhList) - 1
 
 	table := db.commitlog.GetSummary()
-	if e, eIdx := table.LookUp(hkey); eIdx == true {
+	if e, eIdx := table.LookUp(key); eIdx == true {
 		return e, -1, eIdx
 	}
 
 	for curr := dhListLen; curr > -1; curr-- {
-		if db.dhList[curr].bloomfilter.Contains(strKey) {
-			if e, eIdx := db.dhList[curr].summary.LookUp(hkey); eIdx == true {
+		if db.dhList[curr].bloomfilter.Contains(key) {
+			if e, eIdx := db.dhList[curr].sindex.LookUp(key); eIdx == true {
 				return e, curr, eIdx
 			}
 		}
@@ -151,53 +315,78 @@ func (db *Database) GetDataByIndexEntry(dhIdx int, entry *index.Entry) (*model.D
 	if err != nil {
 		return nil, false
 	}
-	return model.NewDataDefinitionFromByteStream(bs), true
-}
-
-// Keys returns all data keys from database
-func (db *Database) Keys() []string {
-	keys := make([]string, 0)
-	keys = append(keys, db.commitlog.Keys()...)
-
-	for dhIdx, dh := range db.dhList {
-		for _, entry := range dh.summary.GetTable() {
-			if df, found := db.GetDataByIndexEntry(dhIdx, entry); found == true {
-				keys = append(keys, df.Key)
-			}
-		}
-	}
-
-	return keys
+	return db.enc.FromByteStream(bs), true
 }
 
 // Info returns information about database
 func (db *Database) Info() DatabaseInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	dbi := DatabaseInfo{}
 	dbi.DhCount = len(db.dhList)
 	dbi.CommitlogSize, _ = db.commitlog.Size()
 	_, dbi.CacheUsed, dbi.CacheItems = db.cache.Usage()
+	dbi.DedupBlobs, dbi.DedupSaved = db.dedupInfo()
 	return dbi
 }
 
 // LoadData loads index and bloom filter from each data file
-func (db *Database) LoadData() {
+func (db *Database) LoadData() error {
 	flist, _ := ioutil.ReadDir(db.Descriptor.Path)
 	for _, v := range flist {
+		// left by a compaction that did not finish
+		if strings.HasSuffix(v.Name(), compactingSuffix) {
+			util.DeleteDir(filepath.Join(db.Descriptor.Path, v.Name()))
+			continue
+		}
+
 		if m, _ := regexp.MatchString("^([0-9]{19})$", v.Name()); m == true {
-			dh, err := OpenDataHolder(filepath.Join(db.Descriptor.Path, v.Name()))
+			dh, err := openDataHolder(filepath.Join(db.Descriptor.Path, v.Name()), db.Descriptor.BloomFilterFp, db.Descriptor.IndexedAttributeNames(), db.log)
 			if err != nil {
-				slog.Fatalf(err.Error())
+				return err
 			}
 			db.dhList = append(db.dhList, *dh)
 		}
 	}
+	return nil
+}
+
+// newCommitlog returns new Commitlog with database sync policy
+// and indexed attributes
+func (db *Database) newCommitlog() *Commitlog {
+	c := openCommitLog(db.Descriptor.Path, db.log)
+	c.SetSyncPolicy(db.Descriptor.CommitlogSync)
+	c.SetIndexedAttributes(db.Descriptor.IndexedAttributeNames())
+	return c
+}
+
+// syncCommitlog periodically syncs commitlog to disk, used when
+// commitlog sync policy is batch
+func (db *Database) syncCommitlog(stop chan bool) {
+	interval := time.Duration(db.Descriptor.CommitlogSyncInterval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.RLock()
+			if err := db.commitlog.Sync(); err != nil {
+				db.log.Errorf("%s commitlog sync failed: %s", db.Descriptor.Name, err)
+			}
+			db.mu.RUnlock()
+		case <-stop:
+			return
+		}
+	}
 }
 
 func (db *Database) compactionNotification() {
-	slog.Infof("%s compaction started: %s", db.Descriptor.Name, time.Now())
+	db.log.Infof("%s compaction started: %s", db.Descriptor.Name, time.Now())
 	select {
 	case <-db.compFinish:
-		slog.Infof("%s compaction finished: %s", db.Descriptor.Name, time.Now())
+		db.log.Infof("%s compaction finished: %s", db.Descriptor.Name, time.Now())
 	}
 }
 
@@ -205,28 +394,89 @@ func (db *Database) compactionNotification() {
 func (db *Database) Close() {
 	// removes db from compaction service
 	removeDbCompaction(db.Descriptor.Name)
+
+	if db.syncStop != nil {
+		close(db.syncStop)
+		db.syncStop = nil
+	}
+	if db.sweepStop != nil {
+		close(db.sweepStop)
+		db.sweepStop = nil
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.commitlog.Sync(); err != nil {
+		db.log.Errorf("%s commitlog sync failed: %s", db.Descriptor.Name, err)
+	}
+
+	for i := range db.dhList {
+		db.dhList[i].Close()
+	}
 }
 
-// NewDatabase returns new Database
+// NewDatabase returns new Database with default Options
 func NewDatabase(descriptor DatabaseDescriptor) *Database {
+	return NewDatabaseWithOptions(descriptor, Options{})
+}
+
+// NewDatabaseWithOptions returns new Database with dependencies of opts
+func NewDatabaseWithOptions(descriptor DatabaseDescriptor, opts Options) *Database {
+	opts = opts.withDefaults()
+
 	db := Database{
 		Descriptor: descriptor,
-		commitlog:  NewCommitLog(descriptor.Path),
 		cache:      cache.NewCache(cache.NewLRU(int64(descriptor.MaxCacheSize))),
+		derived:    cache.NewCache(cache.NewLRU(int64(descriptor.MaxDerivedCacheSize))),
+		codec:      descriptor.CodecID(),
+		enc:        model.NewEncoder(opts.Codecs),
+		log:        opts.Logger,
 
 		compFinish: make(chan bool),
+		blobs:      make(map[string]int),
+		dropping:   make(map[string]bool),
+		expiring:   make(map[string]int64),
+		reclaimIn:  make(map[string]bool),
+		pins:       make(map[int64]int),
 	}
+	db.commitlog = db.newCommitlog()
 
 	// add database in compaction service
 	registerDbCompaction(&db)
 
+	if descriptor.CommitlogSync == SyncBatch && descriptor.CommitlogSyncInterval > 0 {
+		db.syncStop = make(chan bool)
+		go db.syncCommitlog(db.syncStop)
+	}
+
+	if descriptor.ExpirySweepInterval > 0 {
+		db.sweepStop = make(chan bool)
+		go db.sweepExpiry(db.sweepStop)
+	}
+
 	return &db
 }
 
-// OpenDatabase returns oppened Database
-func OpenDatabase(descriptor DatabaseDescriptor) *Database {
-	db := NewDatabase(descriptor)
-	db.commitlog.LoadData()
-	db.LoadData()
-	return db
+// OpenDatabase returns oppened Database with default Options
+func OpenDatabase(descriptor DatabaseDescriptor) (*Database, error) {
+	return OpenDatabaseWithOptions(descriptor, Options{})
+}
+
+// OpenDatabaseWithOptions returns oppened Database with dependencies of opts,
+// error if its files can not be loaded
+func OpenDatabaseWithOptions(descriptor DatabaseDescriptor, opts Options) (*Database, error) {
+	db := NewDatabaseWithOptions(descriptor, opts)
+
+	err := db.commitlog.LoadData()
+	if err == nil {
+		err = db.LoadData()
+	}
+	if err == nil {
+		err = db.countBlobRefs()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// BatchOp is a write of a batch, Data is inserted
+// or, if it is nil, Key is removed
+type BatchOp struct {
+	Data   *model.DataDefinition
+	Upsert bool
+	Key    string
+}
+
+// BatchResult holds revision written by a BatchOp or its error
+type BatchResult struct {
+	Key      string
+	Revision uint32
+	Err      error
+}
+
+// Batch applies ops in order while holding database lock once. A failed
+// op does not stop the others. Commitlog is synced once after all ops,
+// its error is set in results of applied ones
+func (db *Database) Batch(ops []BatchOp) []BatchResult {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.beginBatch()
+
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		if op.Data != nil {
+			results[i].Key = op.Data.Key
+			results[i].Revision, results[i].Err = db.insertCheckUpsert(op.Data, op.Upsert)
+		} else {
+			results[i].Key = op.Key
+			results[i].Revision, results[i].Err = db.removeData(op.Key)
+		}
+	}
+
+	if err := db.endBatch(); err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results
+}
+
+// beginBatch starts a batch of writes, db.mu must be held
+func (db *Database) beginBatch() {
+	db.batching = true
+	db.commitlog.BeginBatch()
+}
+
+// endBatch ends batch of writes and syncs it, db.mu must be held
+func (db *Database) endBatch() error {
+	db.batching = false
+	return db.commitlog.EndBatch()
+}
+
+// removeData writes tombstone of key, which must be
+// stored and not removed. Returns its revision
+func (db *Database) removeData(key string) (uint32, error) {
+	storedDf, ok := db.getDataByKey(key)
+	if !ok || storedDf.Status != model.DataDefinitionActive || storedDf.Expired(time.Now()) {
+		return 0, fmt.Errorf(errors.ErrKeyNotFound.Error(), key, db.Descriptor.Name)
+	}
+	return db.insertCheckUpsert(model.NewTombstone(storedDf), true)
+}
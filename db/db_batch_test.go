@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_Batch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:           "batch",
+		Path:           dir,
+		MaxDataLogSize: 256,
+		MaxCacheSize:   1024,
+		BloomFilterFp:  0.01,
+		CronExp:        "0 0 1 ? * TUE",
+		CommitlogSync:  SyncAlways,
+	}
+	db := NewDatabase(descriptor)
+
+	if err := db.InsertData(newTestDataDefinition("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := make([]BatchOp, 0)
+	for i := 0; i < 8; i++ {
+		ops = append(ops, BatchOp{Data: newTestDataDefinition(fmt.Sprintf("key%d", i))})
+	}
+	ops = append(ops,
+		BatchOp{Data: newTestDataDefinition("key0")},
+		BatchOp{Data: newTestDataDefinition("key1"), Upsert: true},
+		BatchOp{Key: "old"},
+		BatchOp{Key: "missing"},
+	)
+
+	results := db.Batch(ops)
+	for i := 0; i < 8; i++ {
+		if results[i].Err != nil || results[i].Revision != 1 {
+			t.Fatalf("unexpected result of key%d %v", i, results[i])
+		}
+	}
+	if results[8].Err == nil {
+		t.Fatal("existing key inserted without upsert")
+	}
+	if results[9].Err != nil || results[9].Revision != 2 {
+		t.Fatalf("unexpected result of upsert %v", results[9])
+	}
+	if results[10].Err != nil || results[10].Key != "old" {
+		t.Fatalf("unexpected result of remove %v", results[10])
+	}
+	if results[11].Err == nil {
+		t.Fatal("missing key removed")
+	}
+
+	if len(db.dhList) == 0 {
+		t.Fatal("expected commitlog to become a data holder during batch")
+	}
+	if db.batching || db.commitlog.batch || db.commitlog.dirty {
+		t.Fatal("batch not ended and synced")
+	}
+	db.Close()
+
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+
+	if df, ok := db.GetDataByKey("key7"); !ok || df.Revision != 1 {
+		t.Fatal("key7 not stored")
+	}
+	if df, ok := db.GetDataByKey("old"); !ok || df.Status != model.DataDefinitionRemoved {
+		t.Fatal("old not removed")
+	}
+}
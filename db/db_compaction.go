@@ -1,12 +1,25 @@
 package db
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
 	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/model"
 	"github.com/SparrowDb/sparrowdb/util"
 	"github.com/elgs/cron"
 )
 
+// compactingSuffix is added to the directory where merged
+// data holder is written until it is complete
+const compactingSuffix = ".compacting"
+
 var (
 	// keeps all active cron with dbname and id of cron
 	activeCron map[string]int
@@ -34,110 +47,609 @@ func removeDbCompaction(dbname string) {
 	}
 }
 
-type tombstoneMark struct {
-	path string
-	index.Entry
+// CompactionStatus holds progress of running compaction
+// or result of the last one
+type CompactionStatus struct {
+	Running        bool      `json:"running"`
+	Processed      int       `json:"holders_processed"`
+	Total          int       `json:"holders_total"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	LastError      string    `json:"last_error"`
+}
+
+// Compact starts compaction of database in background
+func (db *Database) Compact() error {
+	if !db.startCompaction() {
+		return fmt.Errorf(errors.ErrCompactionRunning.Error(), db.Descriptor.Name)
+	}
+
+	go db.runCompaction()
+	return nil
+}
+
+// CompactionStatus returns status of running or last compaction
+func (db *Database) CompactionStatus() CompactionStatus {
+	db.compStatusMu.RLock()
+	defer db.compStatusMu.RUnlock()
+	return db.compStatus
+}
+
+// startCompaction marks compaction as running, returns false
+// if it is already running
+func (db *Database) startCompaction() bool {
+	db.compStatusMu.Lock()
+	defer db.compStatusMu.Unlock()
+
+	if db.compStatus.Running {
+		return false
+	}
+
+	db.compStatus = CompactionStatus{
+		Running:    true,
+		StartedAt:  time.Now(),
+		FinishedAt: db.compStatus.FinishedAt,
+	}
+	return true
 }
 
+// doCompaction runs compaction if it is not already running
 func doCompaction(db *Database) {
+	if !db.startCompaction() {
+		db.log.Infof("%s compaction is already running", db.Descriptor.Name)
+		return
+	}
+	db.runCompaction()
+}
+
+// runCompaction merges data holders of each size tier that has enough of
+// them. A data holder with tombstones past grace period, or with records
+// removed by expiry sweep, that is not in any merge is rewritten alone
+// to drop them
+func (db *Database) runCompaction() {
 	go db.compactionNotification()
 
-	// get all tombstones from database
-	tombstones := geTombstonesFromDb(db)
+	groups := selectCompaction(db)
 
-	// get all tombstones from commitlog
-	tbCommitlog := getTombstonesFromCommitlog(db)
-	tombstones = append(tombstones, tbCommitlog...)
+	db.compStatusMu.Lock()
+	for _, paths := range groups {
+		db.compStatus.Total += len(paths)
+	}
+	db.compStatusMu.Unlock()
 
-	// iterate over all dataHolders
-	for _, dh := range db.dhList {
+	for _, paths := range groups {
+		reclaimed, err := mergeDataHolders(db, paths)
+		if err != nil {
+			db.log.Errorf("%s compaction failed: %s", db.Descriptor.Name, err)
+		}
 
-		// check if DataHolder has any tombstone
-		if dhContainsAnyTombstone(&dh, &tombstones) {
-			// if found tombstone, get index table of DataHolder
-			// and reinsert in commitlog non tombstone entry
-			table := dh.summary.GetTable()
-
-			for _, v := range table {
-				if c := containsKey(v.Key, &tombstones); c == false {
-					bs, _ := dh.Get(v.Offset)
-					df := model.NewDataDefinitionFromByteStream(bs)
-					db.commitlog.Add(df.Key, df.Status, df.Revision, bs)
-				}
-			}
-			util.DeleteDir(dh.path)
+		db.compStatusMu.Lock()
+		db.compStatus.Processed += len(paths)
+		db.compStatus.BytesReclaimed += reclaimed
+		if err != nil {
+			db.compStatus.LastError = err.Error()
 		}
+		db.compStatusMu.Unlock()
 	}
 
+	db.compStatusMu.Lock()
+	db.compStatus.Running = false
+	db.compStatus.FinishedAt = time.Now()
+	db.compStatusMu.Unlock()
+
 	db.compFinish <- true
 }
 
-func getTombstonesFromCommitlog(db *Database) []tombstoneMark {
-	var tombstones []tombstoneMark
+// selectCompaction returns paths of data holders to be merged together,
+// each group is ordered from oldest to newest. Data holders with chunks
+// that records of other data files refer to are not merged
+func selectCompaction(db *Database) [][]string {
+	db.mu.RLock()
+	dhList := append([]DataHolder(nil), db.dhList...)
+	reclaimIn := make(map[string]bool, len(db.reclaimIn))
+	for path := range db.reclaimIn {
+		reclaimIn[path] = true
+	}
+	pinned := make(map[string]bool, len(db.pins))
+	for i := range dhList {
+		pinned[dhList[i].path] = db.pins[dhList[i].name()] > 0
+	}
+	db.mu.RUnlock()
+
+	tierSizes := db.Descriptor.CompactionTierSizes()
+	tiers := make([][]string, len(tierSizes))
+	grace := time.Now().Add(-time.Duration(db.Descriptor.TombstoneGracePeriod) * time.Second)
+	single := make([]string, 0)
 
-	summary := db.commitlog.summary.GetTable()
+	for i := range dhList {
+		dh := &dhList[i]
 
-	for _, v := range summary {
-		if v.Status == model.DataDefinitionRemoved {
-			tombstones = append(tombstones, tombstoneMark{db.commitlog.filepath, *v})
+		// chunks of records in other data files are not indexed
+		// and would be dropped by merge, so it waits for them
+		if pinned[dh.path] {
+			continue
+		}
+
+		size, err := dh.sto.Size(engine.FileDesc{Type: engine.FileData})
+		if err != nil {
+			db.log.Warnf("%s compaction skips %s: %s", db.Descriptor.Name, dh.path, err)
+			continue
+		}
+
+		if t := tierOf(tierSizes, size); t >= 0 {
+			tiers[t] = append(tiers[t], dh.path)
+		} else if reclaimIn[dh.path] || hasExpiredTombstones(dh, grace) {
+			single = append(single, dh.path)
 		}
 	}
-	return tombstones
+
+	groups := make([][]string, 0)
+	for _, tier := range tiers {
+		if len(tier) >= db.Descriptor.CompactionMinHolders {
+			groups = append(groups, tier)
+			continue
+		}
+
+		for _, path := range tier {
+			if dh := findDataHolder(dhList, path); reclaimIn[path] || hasExpiredTombstones(dh, grace) {
+				single = append(single, path)
+			}
+		}
+	}
+
+	for _, path := range single {
+		groups = append(groups, []string{path})
+	}
+	return groups
 }
 
-func geTombstonesFromDb(db *Database) []tombstoneMark {
-	var tombstones []tombstoneMark
-	echan := make(chan []tombstoneMark)
+// tierOf returns the first tier that fits size, -1 if
+// size is greater than the largest tier
+func tierOf(tierSizes []int64, size int64) int {
+	for i, max := range tierSizes {
+		if size <= max {
+			return i
+		}
+	}
+	return -1
+}
 
-	// iterate over all dataHolders
-	for _, dh := range db.dhList {
-		// search in DataHolder index for tombstone
-		go func(dh *DataHolder, results chan []tombstoneMark) {
-			var result []tombstoneMark
+func findDataHolder(dhList []DataHolder, path string) *DataHolder {
+	for i := range dhList {
+		if dhList[i].path == path {
+			return &dhList[i]
+		}
+	}
+	return nil
+}
 
-			// get index table
-			idxSummary := dh.summary.GetTable()
+func hasExpiredTombstones(dh *DataHolder, grace time.Time) bool {
+	if !dh.sealedAt().Before(grace) {
+		return false
+	}
 
-			for _, v := range idxSummary {
-				if v.Status == model.DataDefinitionRemoved {
-					result = append(result, tombstoneMark{dh.path, *v})
-				}
+	found := false
+	dh.sindex.Iterate(func(e *index.Entry) bool {
+		found = e.Status == model.DataDefinitionRemoved
+		return !found
+	})
+	return found
+}
+
+// containsKey checks if any data holder of dhList has key
+func containsKey(dhList []DataHolder, key string) bool {
+	for i := range dhList {
+		if dhList[i].bloomfilter.Contains(key) {
+			if _, ok := dhList[i].sindex.LookUp(key); ok {
+				return true
 			}
-			results <- result
-		}(&dh, echan)
+		}
+	}
+	return false
+}
+
+// countRevisions returns number of revisions of key in data holders of dhList
+func countRevisions(dhList []DataHolder, key string) (int, error) {
+	n := 0
+	for i := range dhList {
+		if !dhList[i].bloomfilter.Contains(key) {
+			continue
+		}
+
+		entries, err := revisionEntries(dhList[i].hindex, key)
+		if err != nil {
+			return 0, err
+		}
+		n += len(entries)
+	}
+	return n, nil
+}
+
+// mergeDataHolders writes the records of data holders in paths that are kept
+// to a new data holder that replaces them in database. Only the newest
+// MaxRevisions revisions of a key, counting the ones in newer data holders and
+// commitlog, are kept. When newest revision is a tombstone past grace period
+// and no older data holder out of the merge still has the key, the key is
+// dropped. Blobs no remaining record refers to are dropped too. Returns
+// data file bytes reclaimed by the merge
+func mergeDataHolders(db *Database, paths []string) (int64, error) {
+	db.mu.RLock()
+	dhList := append([]DataHolder(nil), db.dhList...)
+	clRevisions := make(map[string]int)
+	for _, e := range db.commitlog.HistoryEntries() {
+		if key, ok := index.SplitRevisionKey(e.Key); ok {
+			clRevisions[key]++
+		}
+	}
+
+	// records of merged data holders removed by expiry sweep after
+	// this point may be copied, their marks move to merged one
+	reclaimBefore := make(map[string]bool)
+	for _, path := range paths {
+		reclaimBefore[path] = db.reclaimIn[path]
+	}
+	db.mu.RUnlock()
+
+	inMerge := make(map[string]bool)
+	for _, path := range paths {
+		inMerge[path] = true
+	}
+
+	// positions of merged data holders, oldest first
+	members := make([]int, 0, len(paths))
+	for i := range dhList {
+		if inMerge[dhList[i].path] {
+			members = append(members, i)
+		}
+	}
+	if len(members) != len(paths) {
+		return 0, nil
+	}
+
+	newest := &dhList[members[len(members)-1]]
+	grace := time.Now().Add(-time.Duration(db.Descriptor.TombstoneGracePeriod) * time.Second)
+
+	// merged data holder is named after the newest merged one, so it keeps
+	// its position in database when data holders are loaded again
+	target := filepath.Join(db.Descriptor.Path, fmt.Sprintf("%v", newest.sealedAt().UnixNano()+1))
+	if exists, _ := util.Exists(target); exists {
+		return 0, fmt.Errorf(errors.ErrCompactionTarget.Error(), target)
+	}
+
+	records, err := planMerge(dhList, members, clRevisions, inMerge, grace, db.Descriptor.MaxRevisions)
+	if err != nil {
+		return 0, err
+	}
+
+	// blobs that no record refers to after merge are dropped
+	droppedRefs, err := mergeBlobRefs(dhList, members, records)
+	if err != nil {
+		return 0, err
+	}
+	records, marked := db.dropUnreferencedBlobs(records, droppedRefs)
+	defer db.unmarkBlobs(marked)
+
+	tmpPath := target + compactingSuffix
+	util.DeleteDir(tmpPath)
+
+	sto, err := engine.OpenFile(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := db.Descriptor.IndexedAttributeNames()
+	md, err := writeMergedData(sto, db.enc, dhList, records, indexed)
+	if err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+	entries := md.entries
+
+	for _, revKey := range md.corrupted {
+		key, _ := index.SplitRevisionKey(revKey)
+		db.log.Errorf("%s compaction copied %s, its data can not be decoded", db.Descriptor.Name, key)
+	}
+
+	if err := writeIndexFile(sto, entries); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	if err := writeSortedIndex(sto, entries); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	// bloom filter has the keys that only have older revisions too
+	keys := make([]*index.Entry, 0, len(md.history))
+	for _, e := range md.history {
+		key, _ := index.SplitRevisionKey(e.Key)
+		keys = append(keys, &index.Entry{Key: key})
+	}
+
+	bf := newBloomFilterFromEntries(keys, db.Descriptor.BloomFilterFp)
+	if err := writeBloomFilter(sto, &bf); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	if err := writeAttributeIndex(sto, md.attrEntries, indexed); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	if err := writeHistoryIndex(sto, md.history); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
 	}
 
-	dhListLen := len(db.dhList)
-	processed := 0
-	for processed < dhListLen {
-		select {
-		case result := <-echan:
-			tombstones = append(tombstones, result...)
-			processed++
+	if err := writeBlobIndex(sto, md.blobRefs); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	// from here merged data holder is loaded with database, until merged
+	// ones are deleted they are only shadowed by it
+	if err := os.Rename(tmpPath, target); err != nil {
+		util.DeleteDir(tmpPath)
+		return 0, err
+	}
+
+	merged, err := openDataHolder(target, db.Descriptor.BloomFilterFp, indexed, db.log)
+	if err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	list := make([]DataHolder, 0, len(db.dhList))
+	removed := make([]DataHolder, 0, len(paths))
+	for _, dh := range db.dhList {
+		if !inMerge[dh.path] {
+			list = append(list, dh)
+			continue
+		}
+
+		removed = append(removed, dh)
+		if dh.path == newest.path {
+			list = append(list, *merged)
+		}
+
+		if db.reclaimIn[dh.path] && !reclaimBefore[dh.path] {
+			db.reclaimIn[target] = true
 		}
+		delete(db.reclaimIn, dh.path)
 	}
+	db.dhList = list
+	db.releaseBlobRefs(droppedRefs)
+	db.mu.Unlock()
 
-	return tombstones
+	// streams still reading chunks of a removed data
+	// holder keep its files until they are closed
+	var reclaimed int64
+	for i := range removed {
+		dh := removed[i]
+		if size, err := dh.sto.Size(engine.FileDesc{Type: engine.FileData}); err == nil {
+			reclaimed += size
+		}
+		dh.refs.retire(func() {
+			dh.Close()
+			util.DeleteDir(dh.path)
+		})
+	}
+	reclaimed -= md.size
+
+	db.log.Infof("%s merged %d data holders into %s with %d entries", db.Descriptor.Name, len(paths), target, len(entries))
+	return reclaimed, nil
+}
+
+// mergedRecord is a record of a merged data holder that is kept,
+// current is set when it is the newest revision of its key
+type mergedRecord struct {
+	member  int
+	entry   *index.Entry
+	current bool
 }
 
-func containsKey(key uint32, list *[]tombstoneMark) bool {
-	for _, tb := range *list {
-		if tb.Key == key {
+// planMerge returns the records of merged data holders that are kept, in
+// the order they are written. Records of a data holder keep their order, so
+// the newest revision of a key is always the last one written
+func planMerge(dhList []DataHolder, members []int, clRevisions map[string]int, inMerge map[string]bool, grace time.Time, maxRevisions int) ([]mergedRecord, error) {
+	if maxRevisions < 1 {
+		maxRevisions = 1
+	}
+
+	records := make([]mergedRecord, 0)
+	dropped := make(map[string]bool)
+
+	// newest data holder first, the one with newest revision
+	// of a key decides if it is dropped
+	for i := len(members) - 1; i >= 0; i-- {
+		m := members[i]
+		dh := &dhList[m]
+
+		older := make([]DataHolder, 0, m)
+		for _, o := range dhList[:m] {
+			if !inMerge[o.path] {
+				older = append(older, o)
+			}
+		}
+		expired := dh.sealedAt().Before(grace)
+
+		kept := make([]mergedRecord, 0)
+		var key string
+		var newer int
+		var countErr error
+
+		// history index has revisions of a key from newest to oldest
+		err := dh.hindex.Iterate(func(e *index.Entry) bool {
+			k, ok := index.SplitRevisionKey(e.Key)
+			if !ok {
+				return true
+			}
+
+			if k != key {
+				n, err := countRevisions(dhList[m+1:], k)
+				if err != nil {
+					countErr = err
+					return false
+				}
+				key, newer = k, clRevisions[k]+n
+
+				if newer == 0 && e.Status == model.DataDefinitionRemoved && expired && !containsKey(older, k) {
+					dropped[k] = true
+				}
+			} else {
+				newer++
+			}
+
+			if !dropped[k] && newer < maxRevisions {
+				kept = append(kept, mergedRecord{member: m, entry: e, current: newer == 0})
+			}
 			return true
+		})
+		if countErr != nil {
+			return nil, countErr
+		}
+		if err != nil {
+			return nil, err
 		}
+
+		sort.Slice(kept, func(a, b int) bool { return kept[a].entry.Offset < kept[b].entry.Offset })
+		records = append(kept, records...)
 	}
-	return false
+	return records, nil
 }
 
-func dhContainsAnyTombstone(dh *DataHolder, list *[]tombstoneMark) bool {
-	var result bool
-	dhs := dh.summary.GetTable()
+// mergedData holds index entries and data file size of merged data
+// holder, corrupted has revision keys of records whose data can not be decoded
+type mergedData struct {
+	entries     []*index.Entry
+	attrEntries []*index.Entry
+	history     []*index.Entry
+	blobRefs    []*index.Entry
+	corrupted   []string
+	size        int64
+}
 
-	for _, v := range *list {
-		if _, ok := dhs[v.Key]; ok == true {
-			return true
+// writeMergedData copies records to sto data file, records
+// whose data enc can not decode are dropped
+func writeMergedData(sto engine.Storage, enc *model.Encoder, dhList []DataHolder, records []mergedRecord, indexed []string) (*mergedData, error) {
+	fwriter, err := sto.Create(engine.FileDesc{Type: engine.FileData})
+	if err != nil {
+		return nil, err
+	}
+	defer fwriter.Close()
+
+	w := bufio.NewWriter(fwriter)
+	md := &mergedData{entries: make([]*index.Entry, 0)}
+	var pos int64
+
+	var freader engine.Reader
+	var r *dbReader
+	member := -1
+	defer func() {
+		if freader != nil {
+			freader.Close()
+		}
+	}()
+
+	// data files of chunks written while their commitlog was made a
+	// data holder, they are copied next to the record referring to them
+	others := make(map[int64]engine.Reader)
+	defer func() {
+		for _, f := range others {
+			f.Close()
+		}
+	}()
+
+	open := func(file int64) (*dbReader, error) {
+		f, ok := others[file]
+		if !ok {
+			dh := findHolder(dhList, file)
+			if dh == nil {
+				return nil, fmt.Errorf(errors.ErrChunkFile.Error(), file)
+			}
+			var err error
+			if f, err = dh.sto.Open(engine.FileDesc{Type: engine.FileData}); err != nil {
+				return nil, err
+			}
+			others[file] = f
+		}
+		return newReader(f), nil
+	}
+
+	for _, rec := range records {
+		if rec.member != member {
+			if freader != nil {
+				freader.Close()
+			}
+			if freader, err = dhList[rec.member].sto.Open(engine.FileDesc{Type: engine.FileData}); err != nil {
+				return nil, err
+			}
+			r, member = newReader(freader), rec.member
+		}
+
+		e := rec.entry
+		b, err := r.Read(e.Offset)
+		if err != nil {
+			return nil, err
+		}
+
+		df, err := decodeRecordHeader(b)
+		if err != nil {
+			return nil, err
+		}
+
+		// record whose data can not be decoded is copied as it is, it
+		// keeps its revision and reads of it report it as corrupted
+		if !df.IsChunked() {
+			if _, err := decodeRecord(enc, b); err != nil {
+				md.corrupted = append(md.corrupted, e.Key)
+			}
+		}
+
+		// chunks are written before the record that refers to them
+		if df.IsChunked() {
+			if b, pos, err = copyChunks(enc, r, open, w, b, pos); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := writeRecord(w, b); err != nil {
+			return nil, err
 		}
+
+		key, _ := index.SplitRevisionKey(e.Key)
+		md.history = append(md.history, historyEntry(key, pos, e.Status, e.Revision))
+		if len(df.Blob) > 0 {
+			md.blobRefs = append(md.blobRefs, blobRefEntry(df, pos))
+		}
+
+		if rec.current {
+			md.entries = append(md.entries, &index.Entry{
+				Key:      key,
+				Offset:   pos,
+				Status:   e.Status,
+				Revision: e.Revision,
+				Expires:  df.Expires,
+			})
+
+			if len(indexed) > 0 {
+				md.attrEntries = append(md.attrEntries, attributeEntries(df, pos, indexed)...)
+			}
+		}
+		pos += int64(recordSizeMark + recordChecksumMark + len(b))
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if err := fwriter.Sync(); err != nil {
+		return nil, err
 	}
 
-	return result
+	md.size = pos
+	return md, nil
 }
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_MergeDataHolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "compaction",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+	}
+	db := NewDatabase(descriptor)
+
+	// every data holder gets about two records, key1 is written twice
+	// and key2 is removed
+	for i := 0; i < 6; i++ {
+		df := newTestDataDefinition(fmt.Sprintf("key%d", i))
+		if err := db.InsertData(df); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"key1", "key2"} {
+		df := newTestDataDefinition(key)
+		df.Buf = []byte("new content of " + key)
+		df.Size = uint32(len(df.Buf))
+		if key == "key2" {
+			df.Status = model.DataDefinitionRemoved
+		}
+		if err := db.InsertData(df); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.dhList) < 2 {
+		t.Fatalf("expected several data holders, got %d", len(db.dhList))
+	}
+
+	doCompaction(db)
+
+	if len(db.dhList) != 1 {
+		t.Fatalf("expected 1 data holder after compaction, got %d", len(db.dhList))
+	}
+	db.Close()
+
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprintf("key%d", i)
+		df, ok := db.GetDataByKey(key)
+		if !ok {
+			t.Fatalf("key %s not found after compaction", key)
+		}
+		if key == "key1" && string(df.Buf) != "new content of key1" {
+			t.Fatal("superseded revision of key1 returned")
+		}
+		if key == "key2" && df.Status != model.DataDefinitionRemoved {
+			t.Fatal("tombstone in grace period must be kept")
+		}
+	}
+
+	// superseded revisions are not copied to merged data holder
+	entries, err := readIndexFile(db.dhList[0].sto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if seen[e.Key] {
+			t.Fatalf("key %s copied more than once", e.Key)
+		}
+		seen[e.Key] = true
+	}
+}
+
+func Test_CompactionStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewDatabase(DatabaseDescriptor{
+		Name:                 "compaction",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+	})
+	defer db.Close()
+
+	for i := 0; i < 8; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	holders := len(db.dhList)
+	if holders < 2 {
+		t.Fatalf("expected several data holders, got %d", holders)
+	}
+
+	if !db.startCompaction() {
+		t.Fatal("compaction must start")
+	}
+	if err := db.Compact(); err == nil {
+		t.Fatal("compaction must not start while one is running")
+	}
+	db.runCompaction()
+
+	status := db.CompactionStatus()
+	if status.Running || status.FinishedAt.IsZero() || status.LastError != "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.Processed != holders || status.Total != holders {
+		t.Fatalf("expected %d holders processed, got %d of %d", holders, status.Processed, status.Total)
+	}
+}
+
+// corruptCompressor writes data snappy can not decode
+type corruptCompressor struct{}
+
+func (corruptCompressor) Compress(src []byte) []byte { return []byte{0xff} }
+
+func (corruptCompressor) Decompress(src []byte) ([]byte, error) { return src, nil }
+
+func Test_MergeCorruptedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "corrupted",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+		Compression:          "snappy",
+	}
+
+	// key0 is written with data its checksum covers but snappy can not decode
+	codecs := compression.NewCodecs().With(compression.CodecSnappy, corruptCompressor{})
+	db := NewDatabaseWithOptions(descriptor, Options{Codecs: codecs})
+	if err := db.InsertData(newTestDataDefinition("key0")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+	for i := 1; i < 6; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doCompaction(db)
+
+	if len(db.dhList) != 1 {
+		t.Fatalf("expected 1 data holder after compaction, got %d", len(db.dhList))
+	}
+	if _, ok := db.dhList[0].sindex.LookUp("key0"); !ok {
+		t.Fatal("record that can not be decoded not copied")
+	}
+	if df, ok := db.GetDataByKey("key0"); !ok || !df.Corrupted {
+		t.Fatalf("expected key0 read as corrupted, got %v", df)
+	}
+}
@@ -0,0 +1,20 @@
+package db
+
+import "github.com/SparrowDb/sparrowdb/model"
+
+// derivedKey returns cache key of the image derived from df by params.
+// ETag changes with each write of the key, so images derived from
+// older revisions are not found and are evicted from cache
+func derivedKey(df *model.DataDefinition, params string) string {
+	return df.Key + "\x00" + df.ETag() + "\x00" + params
+}
+
+// GetDerived returns image derived from df by params if it is in cache
+func (db *Database) GetDerived(df *model.DataDefinition, params string) []byte {
+	return db.derived.Get(derivedKey(df, params))
+}
+
+// PutDerived puts image derived from df by params in cache
+func (db *Database) PutDerived(df *model.DataDefinition, params string, b []byte) {
+	db.derived.Put(derivedKey(df, params), b)
+}
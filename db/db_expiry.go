@@ -0,0 +1,199 @@
+package db
+
+import (
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+// expiryPageSize number of keys read while database lock is held when
+// expiry of keys stored before opening it is loaded, and number of
+// expired keys removed while it is held by a sweep
+const expiryPageSize = 1000
+
+// trackExpiry keeps expiry time of key of df written
+// to database, db.mu must be held
+func (db *Database) trackExpiry(df *model.DataDefinition) {
+	switch {
+	case df.Status != model.DataDefinitionActive && df.Status != model.DataDefinitionRemoved:
+	case df.Status == model.DataDefinitionActive && df.Expires != 0:
+		db.expiring[df.Key] = df.Expires
+	default:
+		delete(db.expiring, df.Key)
+	}
+}
+
+// loadExpiring adds to tracked keys the ones stored with expiry time
+// before database was opened. Keys are read by pages, lock is released
+// between them and keys written meanwhile are tracked by their writes
+func (db *Database) loadExpiring() error {
+	after := ""
+	for {
+		db.mu.RLock()
+		last, err := db.loadExpiringPage(after)
+		db.mu.RUnlock()
+
+		if err != nil || len(last) == 0 {
+			return err
+		}
+		after = last
+	}
+}
+
+// loadExpiringPage tracks expiry of a page of keys that follow after,
+// returns the last key of the page, empty if there are no more keys
+func (db *Database) loadExpiringPage(after string) (string, error) {
+	sources := []keySource{summaryKeySource(db.commitlog.summary)}
+	for i := len(db.dhList) - 1; i >= 0; i-- {
+		sources = append(sources, sortedIndexKeySource(db.dhList[i].sindex))
+	}
+
+	from := make(map[*index.Entry]int)
+	entries, err := listEntries(sources, "", after, expiryPageSize, func(e *index.Entry, source int) bool {
+		from[e] = source
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		if e.Status != model.DataDefinitionActive {
+			continue
+		}
+		if e.ExpiryKnown() {
+			if e.Expires != 0 {
+				db.expiring[e.Key] = e.Expires
+			}
+			continue
+		}
+
+		// indexes written before expiry time was stored in
+		// their entries, it is read from the record
+		var bs *util.ByteStream
+		if source := from[e]; source == 0 {
+			bs = db.commitlog.GetAt(e.Offset)
+		} else {
+			bs, _ = db.dhList[len(db.dhList)-source].Get(e.Offset)
+		}
+		if bs == nil {
+			continue
+		}
+
+		if df := model.NewDataDefinitionHeaderFromByteStream(bs); df.Expires != 0 {
+			db.expiring[df.Key] = df.Expires
+		}
+	}
+
+	if len(entries) < expiryPageSize {
+		return "", nil
+	}
+	return entries[len(entries)-1].Key, nil
+}
+
+// SweepExpired writes tombstones of keys whose data expired, in batches
+// of expiryPageSize keys, lock is released between them. Data holders with
+// expired records are rewritten by the next compaction to reclaim their
+// space. Returns number of keys removed
+func (db *Database) SweepExpired() (int, error) {
+	db.sweepMu.Lock()
+	defer db.sweepMu.Unlock()
+
+	if !db.expiryLoaded {
+		if err := db.loadExpiring(); err != nil {
+			return 0, err
+		}
+		db.expiryLoaded = true
+	}
+
+	now := time.Now()
+
+	db.mu.RLock()
+	keys := make([]string, 0)
+	for key, expires := range db.expiring {
+		if now.Unix() >= expires {
+			keys = append(keys, key)
+		}
+	}
+	db.mu.RUnlock()
+
+	removed := 0
+	for len(keys) > 0 {
+		n := expiryPageSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+
+		count, err := db.sweepKeys(keys[:n], now)
+		removed += count
+		if err != nil {
+			return removed, err
+		}
+		keys = keys[n:]
+	}
+	return removed, nil
+}
+
+// sweepKeys writes tombstones of keys whose data expired at now in
+// one batch, keys written again since they were selected are kept
+func (db *Database) sweepKeys(keys []string, now time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.beginBatch()
+
+	removed := 0
+	var err error
+	for _, key := range keys {
+		if expires, ok := db.expiring[key]; !ok || now.Unix() < expires {
+			continue
+		}
+
+		stored, ok := db.getDataByKey(key)
+		if !ok || stored.Status != model.DataDefinitionActive || !stored.Expired(now) {
+			delete(db.expiring, key)
+			continue
+		}
+
+		path := ""
+		if _, idx, ok := db.GetDataIndexByKey(key); ok && idx >= 0 {
+			path = db.dhList[idx].path
+		}
+
+		if _, err = db.insertCheckUpsert(model.NewTombstone(stored), true); err != nil {
+			break
+		}
+		if len(path) > 0 {
+			db.reclaimIn[path] = true
+		}
+		removed++
+	}
+
+	if serr := db.endBatch(); err == nil {
+		err = serr
+	}
+	return removed, err
+}
+
+// sweepExpiry periodically removes expired keys
+func (db *Database) sweepExpiry(stop chan bool) {
+	interval := time.Duration(db.Descriptor.ExpirySweepInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := db.SweepExpired()
+			if err != nil {
+				db.log.Errorf("%s expiry sweep failed: %s", db.Descriptor.Name, err)
+			} else if removed > 0 {
+				db.log.Infof("%s expiry sweep removed %d keys", db.Descriptor.Name, removed)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
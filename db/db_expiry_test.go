@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_Expiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "expiry",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 100,
+		TombstoneGracePeriod: 3600,
+	}
+	db := NewDatabase(descriptor)
+
+	now := time.Now().Unix()
+	for _, key := range []string{"expired", "later", "again"} {
+		df := newTestDataDefinition(key)
+		df.Expires = now - 1
+		if key == "later" {
+			df.Expires = now + 3600
+		}
+		if _, err := db.InsertCheckUpsert(df, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := db.InsertCheckUpsert(newTestDataDefinition(fmt.Sprintf("key%d", i)), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := db.GetDataByKey("expired"); ok {
+		t.Fatal("expired data found")
+	}
+	if _, _, ok := db.GetDataStream("expired"); ok {
+		t.Fatal("expired data streamed")
+	}
+	if _, ok := db.GetDataByKey("later"); !ok {
+		t.Fatal("data not expired yet not found")
+	}
+
+	// expiry time is kept in index entries, listings hide expired keys
+	if page, err := db.ListKeys("", "", 100); err != nil || len(page.Keys) != 5 || page.Keys[0] != "key0" {
+		t.Fatalf("expected expired keys not listed, got %v %v", page, err)
+	}
+
+	// expired key is written again as if it was removed
+	if rev, err := db.InsertCheckUpsert(newTestDataDefinition("again"), false); err != nil || rev != 2 {
+		t.Fatalf("expected revision 2 over expired data, got %d %v", rev, err)
+	}
+	db.Close()
+
+	// keys stored before opening are found by the first sweep
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+
+	removed, err := db.SweepExpired()
+	if err != nil || removed != 1 {
+		t.Fatalf("expected 1 key removed, got %d %v", removed, err)
+	}
+	if df, ok := db.GetDataByKey("expired"); !ok || df.Status != model.DataDefinitionRemoved || df.Revision != 2 {
+		t.Fatal("tombstone of expired data not written")
+	}
+	if df, ok := db.GetDataByKey("again"); !ok || df.Status != model.DataDefinitionActive {
+		t.Fatal("data written over expired one removed")
+	}
+	if len(db.reclaimIn) != 1 {
+		t.Fatalf("expected data holder of expired data marked, got %v", db.reclaimIn)
+	}
+
+	// data holder of expired record is rewritten without it
+	doCompaction(db)
+
+	for i := range db.dhList {
+		if e, ok := db.dhList[i].sindex.LookUp("expired"); ok && e.Status == model.DataDefinitionActive {
+			t.Fatalf("expired record kept in %s", db.dhList[i].path)
+		}
+	}
+	if _, ok := db.GetDataByKey("later"); !ok {
+		t.Fatal("data not expired yet removed")
+	}
+}
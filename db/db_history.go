@@ -0,0 +1,124 @@
+package db
+
+import (
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+// Revision holds information of a stored revision of a key,
+// Timestamp is taken from the token of the revision
+type Revision struct {
+	Revision  uint32 `json:"revision"`
+	Timestamp string `json:"timestamp"`
+	Size      uint32 `json:"size"`
+	Ext       string `json:"ext"`
+	MediaType string `json:"media_type"`
+	Removed   bool   `json:"removed"`
+}
+
+// GetDataByRevision returns revision rev of key, bool if it is
+// still stored and did not expire
+func (db *Database) GetDataByRevision(key string, rev uint32) (*model.DataDefinition, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sto, desc, _, offset, ok := db.locateRevision(key, rev)
+	if !ok {
+		return nil, false
+	}
+
+	freader, err := sto.Open(desc)
+	if err != nil {
+		return nil, false
+	}
+	defer freader.Close()
+
+	b, err := newReader(freader).Read(offset)
+	if err != nil {
+		return nil, false
+	}
+
+	df := db.enc.FromByteStream(util.NewByteStreamFromBytes(b))
+	if df.Expired(time.Now()) {
+		return nil, false
+	}
+	return df, db.loadBlob(df)
+}
+
+// locateRevision returns file, its readers and offset of revision rev of key
+func (db *Database) locateRevision(key string, rev uint32) (engine.Storage, engine.FileDesc, *fileRefs, int64, bool) {
+	for _, e := range db.commitlog.Revisions(key) {
+		if e.Revision == rev {
+			return db.commitlog.sto, db.commitlog.desc, db.commitlog.refs, e.Offset, true
+		}
+	}
+
+	for i := len(db.dhList) - 1; i >= 0; i-- {
+		dh := &db.dhList[i]
+		if !dh.bloomfilter.Contains(key) {
+			continue
+		}
+
+		if e, ok := dh.hindex.LookUp(index.RevisionKey(key, rev)); ok {
+			return dh.sto, engine.FileDesc{Type: engine.FileData}, dh.refs, e.Offset, true
+		}
+	}
+	return nil, engine.FileDesc{}, nil, 0, false
+}
+
+// History returns stored revisions of key from newest to oldest, older
+// revisions than MaxRevisions are kept until they are compacted
+func (db *Database) History(key string) ([]Revision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	revs := make([]Revision, 0)
+	seen := make(map[uint32]bool)
+
+	add := func(e *index.Entry, bs *util.ByteStream) {
+		if seen[e.Revision] || bs == nil {
+			return
+		}
+		seen[e.Revision] = true
+
+		df, err := decodeRecordHeader(bs.Bytes())
+		if err != nil {
+			return
+		}
+
+		revs = append(revs, Revision{
+			Revision:  df.Revision,
+			Timestamp: df.Time().String(),
+			Size:      df.Size,
+			Ext:       df.Ext,
+			MediaType: df.MediaType,
+			Removed:   df.Status == model.DataDefinitionRemoved,
+		})
+	}
+
+	for _, e := range db.commitlog.Revisions(key) {
+		add(e, db.commitlog.GetAt(e.Offset))
+	}
+
+	for i := len(db.dhList) - 1; i >= 0; i-- {
+		dh := &db.dhList[i]
+		if !dh.bloomfilter.Contains(key) {
+			continue
+		}
+
+		entries, err := revisionEntries(dh.hindex, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			bs, _ := dh.Get(e.Offset)
+			add(e, bs)
+		}
+	}
+	return revs, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_History(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "history",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+		MaxRevisions:         3,
+	}
+	db := NewDatabase(descriptor)
+
+	// five revisions of key, other keys spread them over data holders
+	for i := 1; i <= 5; i++ {
+		df := newTestDataDefinition("key")
+		df.Buf = []byte(fmt.Sprintf("revision %d", i))
+		df.Size = uint32(len(df.Buf))
+		rev, err := db.InsertCheckUpsert(df, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev != uint32(i) {
+			t.Fatalf("expected revision %d, got %d", i, rev)
+		}
+
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("other%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.dhList) < 2 {
+		t.Fatalf("expected several data holders, got %d", len(db.dhList))
+	}
+
+	checkRevisions := func(expected []uint32) {
+		revs, err := db.History("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revs) != len(expected) {
+			t.Fatalf("expected %d revisions, got %v", len(expected), revs)
+		}
+		for i, rev := range expected {
+			if revs[i].Revision != rev {
+				t.Fatalf("expected revision %d at %d, got %d", rev, i, revs[i].Revision)
+			}
+
+			df, ok := db.GetDataByRevision("key", rev)
+			if !ok || string(df.Buf) != fmt.Sprintf("revision %d", rev) {
+				t.Fatalf("revision %d not read", rev)
+			}
+		}
+	}
+	checkRevisions([]uint32{5, 4, 3, 2, 1})
+
+	// compaction keeps the newest MaxRevisions revisions
+	doCompaction(db)
+	checkRevisions([]uint32{5, 4, 3})
+
+	if _, ok := db.GetDataByRevision("key", 1); ok {
+		t.Fatal("revision 1 must be dropped by compaction")
+	}
+
+	df, ok := db.GetDataByKey("key")
+	if !ok || df.Revision != 5 {
+		t.Fatal("current revision not returned after compaction")
+	}
+	db.Close()
+
+	// history index is rebuilt when it is missing
+	os.Remove(filepath.Join(db.dhList[len(db.dhList)-1].path, "hindex.spw"))
+
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+	checkRevisions([]uint32{5, 4, 3})
+
+	if _, err := db.InsertCheckUpsert(model.NewTombstone(df), true); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := db.History("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revs[0].Revision != 6 || !revs[0].Removed {
+		t.Fatalf("expected removal as revision 6, got %v", revs[0])
+	}
+}
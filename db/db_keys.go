@@ -0,0 +1,187 @@
+package db
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// KeyPage holds a page of keys in key order, Cursor is used
+// to request the next page and is empty on the last one
+type KeyPage struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor"`
+}
+
+// keySource holds entries of commitlog or data holder, it returns up to
+// limit entries with prefix and key greater than after, or equal to it
+// when inclusive is set. truncated is set if it stopped at limit
+type keySource func(after string, inclusive bool, prefix string, limit int) (entries []*index.Entry, truncated bool, err error)
+
+// ListKeys returns up to limit keys with prefix that follow cursor in key
+// order. Keys are read from commitlog summary and sorted indexes, data
+// files are never read. Removed and expired keys and blobs are not listed
+func (db *Database) ListKeys(prefix, cursor string, limit int) (*KeyPage, error) {
+	after, err := decodeKeyCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// newest source first, its entry shadows older ones with same key
+	sources := []keySource{summaryKeySource(db.commitlog.summary)}
+	for i := len(db.dhList) - 1; i >= 0; i-- {
+		sources = append(sources, sortedIndexKeySource(db.dhList[i].sindex))
+	}
+
+	now := time.Now()
+	entries, err := listEntries(sources, prefix, after, limit, func(e *index.Entry, source int) bool {
+		return e.Status != model.DataDefinitionRemoved && e.Status != model.DataDefinitionBlob && !e.Expired(now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &KeyPage{Keys: make([]string, 0, len(entries))}
+	for _, e := range entries {
+		page.Keys = append(page.Keys, e.Key)
+	}
+	if len(entries) == limit {
+		page.Cursor = encodeKeyCursor(entries[len(entries)-1].Key)
+	}
+	return page, nil
+}
+
+// listEntries returns up to limit entries of sources with prefix that follow
+// after in key order. When sources have the same key, entry of the first one
+// is used. visible filters entries, source is the position in sources
+func listEntries(sources []keySource, prefix, after string, limit int, visible func(e *index.Entry, source int) bool) ([]*index.Entry, error) {
+	result := make([]*index.Entry, 0, limit)
+	inclusive := len(after) == 0 || after < prefix
+	if inclusive {
+		after = prefix
+	}
+
+	for {
+		batch := make(map[string]*index.Entry)
+		from := make(map[string]int)
+		bounded := false
+		var bound string
+
+		for i, source := range sources {
+			entries, truncated, err := source(after, inclusive, prefix, limit)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				if _, ok := batch[e.Key]; !ok {
+					batch[e.Key], from[e.Key] = e, i
+				}
+			}
+
+			// a source that stopped at limit may have keys after its
+			// last one, batch is complete only up to it
+			if last := len(entries) - 1; truncated && (!bounded || entries[last].Key < bound) {
+				bounded, bound = true, entries[last].Key
+			}
+		}
+
+		keys := make([]string, 0, len(batch))
+		for key := range batch {
+			if !bounded || key <= bound {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !visible(batch[key], from[key]) {
+				continue
+			}
+
+			result = append(result, batch[key])
+			if len(result) == limit {
+				return result, nil
+			}
+		}
+
+		if !bounded {
+			return result, nil
+		}
+		after, inclusive = bound, false
+	}
+}
+
+func summaryKeySource(s *index.Summary) keySource {
+	table := s.GetTable()
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return func(after string, inclusive bool, prefix string, limit int) ([]*index.Entry, bool, error) {
+		entries := make([]*index.Entry, 0)
+
+		i := sort.SearchStrings(keys, after)
+		for ; i < len(keys); i++ {
+			if !inclusive && keys[i] == after {
+				continue
+			}
+			if !strings.HasPrefix(keys[i], prefix) {
+				break
+			}
+			if len(entries) == limit {
+				return entries, true, nil
+			}
+			entries = append(entries, table[keys[i]])
+		}
+		return entries, false, nil
+	}
+}
+
+func sortedIndexKeySource(si *index.SortedIndex) keySource {
+	return func(after string, inclusive bool, prefix string, limit int) ([]*index.Entry, bool, error) {
+		entries := make([]*index.Entry, 0)
+		truncated := false
+		if si == nil {
+			return entries, false, nil
+		}
+
+		err := si.IterateFrom(after, func(e *index.Entry) bool {
+			if !inclusive && e.Key == after {
+				return true
+			}
+			if !strings.HasPrefix(e.Key, prefix) {
+				return false
+			}
+			if len(entries) == limit {
+				truncated = true
+				return false
+			}
+			entries = append(entries, e)
+			return true
+		})
+		return entries, truncated, err
+	}
+}
+
+func encodeKeyCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKeyCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errors.ErrInvalidCursor
+	}
+	return string(b), nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_ListKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewDatabase(DatabaseDescriptor{
+		Name:           "keys",
+		Path:           dir,
+		MaxDataLogSize: 512,
+		MaxCacheSize:   1024,
+		BloomFilterFp:  0.01,
+		CronExp:        "0 0 1 ? * TUE",
+	})
+	defer db.Close()
+
+	expected := make([]string, 0)
+	for i := 19; i >= 0; i-- {
+		key := fmt.Sprintf("a%02d", i)
+		if err := db.InsertData(newTestDataDefinition(key)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("b%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if i%5 != 0 {
+			expected = append([]string{key}, expected...)
+		}
+	}
+
+	// removed keys are not listed, some tombstones are in other data holders
+	for i := 0; i < 20; i += 5 {
+		df := newTestDataDefinition(fmt.Sprintf("a%02d", i))
+		df.Status = model.DataDefinitionRemoved
+		if err := db.InsertData(df); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.dhList) < 2 {
+		t.Fatalf("expected several data holders, got %d", len(db.dhList))
+	}
+
+	keys := make([]string, 0)
+	cursor := ""
+	for {
+		page, err := db.ListKeys("a", cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page.Keys...)
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+
+	if _, err := db.ListKeys("", "%%", 3); err == nil {
+		t.Fatal("invalid cursor must fail")
+	}
+}
@@ -3,13 +3,13 @@ package db
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
 	govalidator "gopkg.in/asaskevich/govalidator.v4"
 
+	"github.com/SparrowDb/sparrowdb/compression"
 	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/model"
 	"github.com/SparrowDb/sparrowdb/slog"
@@ -21,6 +21,7 @@ type DBManager struct {
 	Config         *SparrowConfig
 	databases      map[string]*Database
 	databaseConfig *DatabaseConfig
+	opts           Options
 	mu             sync.RWMutex
 }
 
@@ -44,6 +45,42 @@ func (dbm *DBManager) checkAndFillDescriptor(descriptor *DatabaseDescriptor) {
 	if descriptor.MaxDataLogSize <= 0 {
 		descriptor.MaxDataLogSize = dbm.Config.MaxDataLogSize
 	}
+	if len(strings.TrimSpace(descriptor.CommitlogSync)) == 0 {
+		descriptor.CommitlogSync = dbm.Config.CommitlogSync
+	}
+	if descriptor.CommitlogSyncInterval <= 0 {
+		descriptor.CommitlogSyncInterval = dbm.Config.CommitlogSyncInterval
+	}
+	if len(strings.TrimSpace(descriptor.CompactionTiers)) == 0 {
+		descriptor.CompactionTiers = dbm.Config.CompactionTiers
+	}
+	if descriptor.CompactionMinHolders <= 0 {
+		descriptor.CompactionMinHolders = dbm.Config.CompactionMinHolders
+	}
+	if descriptor.TombstoneGracePeriod < 0 {
+		descriptor.TombstoneGracePeriod = dbm.Config.TombstoneGracePeriod
+	}
+	if descriptor.MaxRevisions <= 0 {
+		descriptor.MaxRevisions = dbm.Config.MaxRevisions
+	}
+	if len(strings.TrimSpace(descriptor.CacheControl)) == 0 {
+		descriptor.CacheControl = dbm.Config.CacheControl
+	}
+	if descriptor.ChunkSize == 0 {
+		descriptor.ChunkSize = dbm.Config.ChunkSize
+	}
+	if descriptor.MaxDerivedCacheSize == 0 {
+		descriptor.MaxDerivedCacheSize = dbm.Config.MaxDerivedCacheSize
+	}
+	if len(strings.TrimSpace(descriptor.AllowedMediaTypes)) == 0 {
+		descriptor.AllowedMediaTypes = dbm.Config.AllowedMediaTypes
+	}
+	if len(strings.TrimSpace(descriptor.Compression)) == 0 {
+		descriptor.Compression = dbm.Config.Compression
+	}
+	if descriptor.ExpirySweepInterval < 0 {
+		descriptor.ExpirySweepInterval = dbm.Config.ExpirySweepInterval
+	}
 }
 
 // CreateDatabase create database
@@ -60,12 +97,19 @@ func (dbm *DBManager) CreateDatabase(descriptor DatabaseDescriptor) error {
 		// as default value
 		dbm.checkAndFillDescriptor(&descriptor)
 
+		if !validSyncPolicy(descriptor.CommitlogSync) {
+			return fmt.Errorf(errors.ErrSyncPolicy.Error(), descriptor.CommitlogSync)
+		}
+		if _, ok := compression.CodecID(strings.ToLower(strings.TrimSpace(descriptor.Compression))); !ok {
+			return fmt.Errorf(errors.ErrUnknownCodec.Error(), descriptor.Compression)
+		}
+
 		// create dir for the database with configured path
 		if err := util.CreateDir(descriptor.Path); err != nil {
 			return errors.ErrCreateDatabase
 		}
 
-		dbm.databases[descriptor.Name] = NewDatabase(descriptor)
+		dbm.databases[descriptor.Name] = NewDatabaseWithOptions(descriptor, dbm.opts)
 		dbm.databaseConfig.SaveDatabase(descriptor)
 
 		return nil
@@ -143,9 +187,10 @@ func (dbm *DBManager) LoadDatabases() {
 		for _, d := range descriptors {
 			_, err := dbm.openDatabase(d)
 
+			// other databases are served if one can not be opened
 			if err != nil {
-				slog.Fatalf("%s [%s]\nQuiting...", err, d.Path)
-				os.Exit(1)
+				dbm.opts.Logger.Errorf("%s [%s]", err, d.Path)
+				continue
 			}
 
 			buffer.WriteString(d.Name + " ")
@@ -154,7 +199,7 @@ func (dbm *DBManager) LoadDatabases() {
 		buffer.WriteString("none")
 	}
 
-	slog.Infof("Databases loaded: %s", buffer.String())
+	dbm.opts.Logger.Infof("Databases loaded: %s", buffer.String())
 }
 
 func (dbm *DBManager) openDatabase(descriptor DatabaseDescriptor) (*Database, error) {
@@ -164,13 +209,27 @@ func (dbm *DBManager) openDatabase(descriptor DatabaseDescriptor) (*Database, er
 		return nil, fmt.Errorf("%s: %s", errors.ErrOpenDatabase, descriptor.Name)
 	}
 
-	database := OpenDatabase(descriptor)
+	// databases saved by older versions may not have all values set
+	dbm.checkAndFillDescriptor(&descriptor)
+	if !validSyncPolicy(descriptor.CommitlogSync) {
+		return nil, fmt.Errorf(errors.ErrSyncPolicy.Error(), descriptor.CommitlogSync)
+	}
+
+	database, err := OpenDatabaseWithOptions(descriptor, dbm.opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", errors.ErrOpenDatabase, descriptor.Name, err)
+	}
 
 	dbm.databases[descriptor.Name] = database
 
 	return database, nil
 }
 
+// Logger returns logger of dbm and its databases
+func (dbm *DBManager) Logger() slog.Logger {
+	return dbm.opts.Logger
+}
+
 // Start starts db manager
 func (dbm *DBManager) Start() {
 
@@ -183,12 +242,19 @@ func (dbm *DBManager) Stop() {
 	}
 }
 
-// NewDBManager returns new DBManager
+// NewDBManager returns new DBManager, databases use default Options
 func NewDBManager(config *SparrowConfig, dbConfig *DatabaseConfig) *DBManager {
+	return NewDBManagerWithOptions(config, dbConfig, Options{})
+}
+
+// NewDBManagerWithOptions returns new DBManager, its databases
+// use dependencies of opts
+func NewDBManagerWithOptions(config *SparrowConfig, dbConfig *DatabaseConfig, opts Options) *DBManager {
 	dbm := DBManager{
 		Config:         config,
 		databases:      make(map[string]*Database),
 		databaseConfig: dbConfig,
+		opts:           opts.withDefaults(),
 	}
 	return &dbm
 }
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+// QueryAttribute returns up to limit keys of data with attribute name equal
+// to value, or starting with value if prefix is set. Keys are ordered by
+// attribute value and key, cursor is used as in ListKeys. Keys of expired
+// data are not returned
+func (db *Database) QueryAttribute(name, value string, prefix bool, cursor string, limit int) (*KeyPage, error) {
+	if !db.isIndexed(name) {
+		return nil, fmt.Errorf(errors.ErrAttributeNotIndexed.Error(), name)
+	}
+
+	after, err := decodeKeyCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPrefix := index.AttributePrefix(name, value)
+	if !prefix {
+		keyPrefix = index.AttributeKey(name, value, "")
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sources := []keySource{summaryKeySource(db.commitlog.attrs)}
+	for i := len(db.dhList) - 1; i >= 0; i-- {
+		sources = append(sources, sortedIndexKeySource(db.dhList[i].aindex))
+	}
+
+	// attribute index entry is stale if its key was written
+	// again, or removed, in a newer source
+	now := time.Now()
+	entries, err := listEntries(sources, keyPrefix, after, limit, func(e *index.Entry, source int) bool {
+		_, _, key := index.SplitAttributeKey(e.Key)
+		return !e.Expired(now) && !db.hasNewerKey(key, source)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &KeyPage{Keys: make([]string, 0, len(entries))}
+	for _, e := range entries {
+		_, _, key := index.SplitAttributeKey(e.Key)
+		page.Keys = append(page.Keys, key)
+	}
+	if len(entries) == limit {
+		page.Cursor = encodeKeyCursor(entries[len(entries)-1].Key)
+	}
+	return page, nil
+}
+
+// hasNewerKey checks if key is in a source newer than source, sources are
+// commitlog followed by data holders from newest to oldest
+func (db *Database) hasNewerKey(key string, source int) bool {
+	if source == 0 {
+		return false
+	}
+
+	if _, ok := db.commitlog.summary.LookUp(key); ok {
+		return true
+	}
+	return containsKey(db.dhList[len(db.dhList)-source+1:], key)
+}
+
+func (db *Database) isIndexed(name string) bool {
+	for _, v := range db.Descriptor.IndexedAttributeNames() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
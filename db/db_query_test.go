@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func queryAll(t *testing.T, db *Database, name, value string, prefix bool) []string {
+	keys := make([]string, 0)
+	cursor := ""
+	for {
+		page, err := db.QueryAttribute(name, value, prefix, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page.Keys...)
+		if page.Cursor == "" {
+			return keys
+		}
+		cursor = page.Cursor
+	}
+}
+
+func Test_QueryAttribute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "query",
+		Path:                 dir,
+		MaxDataLogSize:       512,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+		IndexedAttributes:    "owner",
+	}
+	db := NewDatabase(descriptor)
+
+	insert := func(key string, attrs map[string]string, status uint16) {
+		df := newTestDataDefinition(key)
+		df.Attributes = attrs
+		df.Status = status
+		if err := db.InsertData(df); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		insert(fmt.Sprintf("key%d", i), map[string]string{"owner": fmt.Sprintf("user%d", i%2), "tag": "x"}, model.DataDefinitionActive)
+	}
+
+	// key0 changes owner and key2 is removed
+	insert("key0", map[string]string{"owner": "user1"}, model.DataDefinitionActive)
+	insert("key2", nil, model.DataDefinitionRemoved)
+	if len(db.dhList) < 2 {
+		t.Fatalf("expected several data holders, got %d", len(db.dhList))
+	}
+
+	check := func() {
+		if keys := queryAll(t, db, "owner", "user0", false); !reflect.DeepEqual(keys, []string{"key4", "key6", "key8"}) {
+			t.Fatalf("unexpected owner=user0 result %v", keys)
+		}
+		expected := []string{"key4", "key6", "key8", "key0", "key1", "key3", "key5", "key7", "key9"}
+		if keys := queryAll(t, db, "owner", "user", true); !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("unexpected owner prefix result %v", keys)
+		}
+	}
+	check()
+
+	if _, err := db.QueryAttribute("tag", "x", false, "", 10); err == nil {
+		t.Fatal("query of attribute not indexed must fail")
+	}
+
+	doCompaction(db)
+	check()
+
+	db.Close()
+	db = openTestDatabase(t, descriptor)
+	check()
+	db.Close()
+
+	// attribute index of data holders is rebuilt with new attribute
+	descriptor.IndexedAttributes = "owner,tag"
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+	check()
+
+	if keys := queryAll(t, db, "tag", "x", false); len(keys) != 8 {
+		t.Fatalf("expected 8 keys with tag=x, got %v", keys)
+	}
+}
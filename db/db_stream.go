@@ -0,0 +1,414 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+// ChunkWriter writes data of a DataDefinition to commitlog in chunk
+// records as it arrives. Commitlog may be made a data holder while chunks
+// are written, the record refers to chunks in other data files by name
+type ChunkWriter struct {
+	db       *Database
+	key      string
+	size     int
+	buf      []byte
+	chunks   []int64
+	logs     []*Commitlog
+	total    int64
+	df       *model.DataDefinition
+	finished bool
+	closed   bool
+}
+
+// NewChunkWriter returns ChunkWriter of data of key
+func (db *Database) NewChunkWriter(key string) *ChunkWriter {
+	size := int(db.Descriptor.ChunkSize)
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	return &ChunkWriter{
+		db:   db,
+		key:  key,
+		size: size,
+		buf:  make([]byte, 0, size),
+	}
+}
+
+// Write buffers p and writes every full chunk to commitlog
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+	if w.total+int64(len(w.buf))+int64(len(p)) > math.MaxUint32 {
+		return 0, fmt.Errorf(errors.ErrDataTooLarge.Error(), uint32(math.MaxUint32))
+	}
+
+	n := 0
+	for n < len(p) {
+		m := w.size - len(w.buf)
+		if m > len(p)-n {
+			m = len(p) - n
+		}
+		w.buf = append(w.buf, p[n:n+m]...)
+		n += m
+
+		if len(w.buf) == w.size {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *ChunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	chunk := &model.DataDefinition{
+		Key:    w.key,
+		Size:   uint32(len(w.buf)),
+		Status: model.DataDefinitionChunk,
+		Buf:    w.buf,
+		Codec:  w.db.codec,
+	}
+
+	// commitlog is not made a data holder while a chunk is
+	// added, it counts the ChunkWriter with chunks in it
+	w.db.mu.RLock()
+	c := w.db.commitlog
+	offset, err := c.AddChunk(w.key, w.db.enc.ToByteStream(chunk))
+	if err == nil && (len(w.logs) == 0 || w.logs[len(w.logs)-1] != c) {
+		atomic.AddInt32(&c.writers, 1)
+	}
+	w.db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	w.chunks = append(w.chunks, offset)
+	w.logs = append(w.logs, c)
+	w.total += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Finish writes the last chunk and makes df refer to the chunks written,
+// df must be inserted before ChunkWriter is closed. Commitlog is not made
+// a data holder until then
+func (w *ChunkWriter) Finish(df *model.DataDefinition) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	w.db.mu.Lock()
+	defer w.db.mu.Unlock()
+
+	var files []int64
+	for i, c := range w.logs {
+		if c == w.db.commitlog {
+			continue
+		}
+		if files == nil {
+			files = make([]int64, len(w.chunks))
+		}
+		files[i] = c.sealed
+	}
+
+	if !w.finished {
+		w.finished = true
+		w.db.finishing++
+	}
+	w.df = df
+
+	df.Buf = nil
+	df.Size = uint32(w.total)
+	df.ChunkSize = uint32(w.size)
+	df.Chunks = w.chunks
+	df.ChunkFiles = files
+	return nil
+}
+
+// Close releases data files chunks were written to. If the record of
+// Finish was not inserted they are marked for compaction, which drops
+// chunks no record refers to
+func (w *ChunkWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	db := w.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if w.finished {
+		db.finishing--
+	}
+
+	inserted := false
+	if w.df != nil && len(w.chunks) > 0 {
+		stored, ok := db.getDataByKey(w.key)
+		inserted = ok && stored.IsChunked() && stored.Chunks[0] == w.chunks[0] &&
+			stored.Token == w.df.Token && stored.Revision == w.df.Revision
+	}
+
+	for i, c := range w.logs {
+		if i > 0 && w.logs[i-1] == c {
+			continue
+		}
+
+		if c.sealed == 0 {
+			atomic.AddInt32(&c.writers, -1)
+			c.orphans = c.orphans || !inserted
+			continue
+		}
+
+		db.unpin(c.sealed, 1)
+		if !inserted {
+			db.reclaimIn[filepath.Join(db.Descriptor.Path, fmt.Sprint(c.sealed))] = true
+		}
+	}
+	return nil
+}
+
+// DataStream reads data of a DataDefinition, chunks are read
+// from the files that hold them as they are needed
+type DataStream struct {
+	df    *model.DataDefinition
+	enc   *model.Encoder
+	files map[int64]*chunkFile
+	pos   int64
+	chunk int
+	buf   []byte
+}
+
+// chunkFile is a data file DataStream reads chunks from,
+// refs counts it until DataStream is closed
+type chunkFile struct {
+	freader engine.Reader
+	r       *dbReader
+	refs    *fileRefs
+}
+
+// Size returns size of data
+func (s *DataStream) Size() int64 {
+	if !s.df.IsChunked() {
+		return int64(len(s.df.Buf))
+	}
+	return int64(s.df.Size)
+}
+
+// Read reads data from the current position
+func (s *DataStream) Read(p []byte) (int, error) {
+	if s.pos >= s.Size() {
+		return 0, io.EOF
+	}
+
+	if !s.df.IsChunked() {
+		n := copy(p, s.df.Buf[s.pos:])
+		s.pos += int64(n)
+		return n, nil
+	}
+
+	idx := int(s.pos / int64(s.df.ChunkSize))
+	if idx != s.chunk {
+		if err := s.loadChunk(idx); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, s.buf[s.pos-int64(idx)*int64(s.df.ChunkSize):])
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *DataStream) loadChunk(idx int) error {
+	if idx >= len(s.df.Chunks) {
+		return errors.ErrCorruptedRecord
+	}
+
+	f, ok := s.files[s.df.ChunkFile(idx)]
+	if !ok {
+		return errors.ErrCorruptedRecord
+	}
+
+	b, err := f.r.Read(s.df.Chunks[idx])
+	if err != nil {
+		return err
+	}
+
+	chunk := s.enc.FromByteStream(util.NewByteStreamFromBytes(b))
+	if chunk.Corrupted || chunk.Status != model.DataDefinitionChunk || chunk.Key != s.df.Key || len(chunk.Buf) == 0 {
+		return errors.ErrCorruptedRecord
+	}
+
+	s.buf, s.chunk = chunk.Buf, idx
+	return nil
+}
+
+// Seek sets position of next Read
+func (s *DataStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.Size()
+	}
+
+	if offset < 0 {
+		return s.pos, errors.ErrSeek
+	}
+	s.pos = offset
+	return s.pos, nil
+}
+
+// Close closes files of chunks
+func (s *DataStream) Close() error {
+	var err error
+	for _, f := range s.files {
+		if cerr := f.freader.Close(); err == nil {
+			err = cerr
+		}
+		f.refs.done()
+	}
+	s.files = nil
+	return err
+}
+
+// GetDataStream returns DataDefinition of key and a DataStream of its
+// data, bool if found the data. Expired data is not found. DataStream
+// must be closed
+func (db *Database) GetDataStream(key string) (*model.DataDefinition, *DataStream, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// cached data is used unless it must be read from chunks
+	if c := db.cache.Get(key); c != nil {
+		df := db.enc.FromByteStream(util.NewByteStreamFromBytes(c))
+		if df.Expired(time.Now()) {
+			return nil, nil, false
+		}
+		if !df.IsChunked() && db.loadBlob(df) {
+			return df, &DataStream{df: df}, true
+		}
+	}
+
+	e, idx, ok := db.GetDataIndexByKey(key)
+	if !ok {
+		return nil, nil, false
+	}
+
+	sto, desc, refs := db.commitlog.sto, db.commitlog.desc, db.commitlog.refs
+	if idx >= 0 {
+		sto, desc, refs = db.dhList[idx].sto, engine.FileDesc{Type: engine.FileData}, db.dhList[idx].refs
+	}
+	return db.openDataStream(sto, desc, refs, e.Offset)
+}
+
+// GetDataStreamByRevision is like GetDataStream for revision rev of key
+func (db *Database) GetDataStreamByRevision(key string, rev uint32) (*model.DataDefinition, *DataStream, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sto, desc, refs, offset, ok := db.locateRevision(key, rev)
+	if !ok {
+		return nil, nil, false
+	}
+	return db.openDataStream(sto, desc, refs, offset)
+}
+
+// openDataStream reads the record at offset of desc, file is kept open
+// by DataStream if data is in chunks and refs counts it until it is
+// closed, with the data holders of its other chunks. db.mu must be held
+func (db *Database) openDataStream(sto engine.Storage, desc engine.FileDesc, refs *fileRefs, offset int64) (*model.DataDefinition, *DataStream, bool) {
+	freader, err := sto.Open(desc)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	r := newReader(freader)
+	b, err := r.Read(offset)
+	if err != nil {
+		freader.Close()
+		return nil, nil, false
+	}
+
+	df := db.enc.FromByteStream(util.NewByteStreamFromBytes(b))
+	if df.Expired(time.Now()) {
+		freader.Close()
+		return nil, nil, false
+	}
+
+	if !df.IsChunked() {
+		freader.Close()
+		if !db.loadBlob(df) {
+			return nil, nil, false
+		}
+		return df, &DataStream{df: df}, true
+	}
+
+	refs.acquire()
+	stream := &DataStream{df: df, enc: db.enc, chunk: -1}
+	stream.files = map[int64]*chunkFile{0: {freader: freader, r: r, refs: refs}}
+
+	for _, file := range chunkFiles(df) {
+		dh := findHolder(db.dhList, file)
+		if dh == nil {
+			db.log.Errorf("%s data holder %d of chunks of %s not found", db.Descriptor.Name, file, df.Key)
+			stream.Close()
+			return nil, nil, false
+		}
+
+		freader, err := dh.sto.Open(engine.FileDesc{Type: engine.FileData})
+		if err != nil {
+			stream.Close()
+			return nil, nil, false
+		}
+
+		dh.refs.acquire()
+		stream.files[file] = &chunkFile{freader: freader, r: newReader(freader), refs: dh.refs}
+	}
+	return df, stream, true
+}
+
+// copyChunks writes to w the chunks of record b read with r, or with the
+// reader open returns for chunks in another data file. Returns the record
+// updated with chunk offsets in w, where pos is the next offset
+func copyChunks(enc *model.Encoder, r *dbReader, open func(file int64) (*dbReader, error), w io.Writer, b []byte, pos int64) ([]byte, int64, error) {
+	df := enc.FromByteStream(util.NewByteStreamFromBytes(b))
+
+	chunks := make([]int64, 0, len(df.Chunks))
+	for i, offset := range df.Chunks {
+		cr := r
+		if file := df.ChunkFile(i); file != 0 {
+			var err error
+			if cr, err = open(file); err != nil {
+				return nil, pos, err
+			}
+		}
+
+		cb, err := cr.Read(offset)
+		if err != nil {
+			return nil, pos, err
+		}
+		if err := writeRecord(w, cb); err != nil {
+			return nil, pos, err
+		}
+
+		chunks = append(chunks, pos)
+		pos += int64(recordSizeMark + recordChecksumMark + len(cb))
+	}
+
+	df.Chunks, df.ChunkFiles = chunks, nil
+	return enc.ToByteStream(df).Bytes(), pos, nil
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_DataStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:                 "stream",
+		Path:                 dir,
+		MaxDataLogSize:       256,
+		MaxCacheSize:         1024,
+		BloomFilterFp:        0.01,
+		CronExp:              "0 0 1 ? * TUE",
+		CompactionTiers:      "1048576",
+		CompactionMinHolders: 2,
+		TombstoneGracePeriod: 3600,
+		MaxRevisions:         1,
+		ChunkSize:            16,
+	}
+	db := NewDatabase(descriptor)
+	defer db.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef-"), 30)
+
+	w := db.NewChunkWriter("big")
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// commitlog is made a data holder while chunks are written
+	for i := 0; i < 5; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("other%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	df := newTestDataDefinition("big")
+	if err := w.Finish(df); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertCheckUpsert(df, true); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if df.ChunkFile(0) == 0 || db.pins[df.ChunkFile(0)] != 1 {
+		t.Fatalf("expected chunks in a data holder pinned by the record, got %v", df.ChunkFiles)
+	}
+
+	checkStream := func() {
+		df, stream, ok := db.GetDataStream("big")
+		if !ok {
+			t.Fatal("chunked data not found")
+		}
+		defer stream.Close()
+
+		if !df.IsChunked() || stream.Size() != int64(len(data)) {
+			t.Fatalf("expected %d bytes in chunks, got %d", len(data), stream.Size())
+		}
+
+		b, err := ioutil.ReadAll(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatal("chunked data does not match")
+		}
+
+		if _, err := stream.Seek(100, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b = make([]byte, 40)
+		if _, err := io.ReadFull(stream, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[100:140]) {
+			t.Fatal("data read after seek does not match")
+		}
+	}
+	checkStream()
+
+	// more data holders so chunks are copied by compaction
+	for i := 5; i < 10; i++ {
+		if err := db.InsertData(newTestDataDefinition(fmt.Sprintf("other%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.dhList) < 2 {
+		t.Fatalf("expected several data holders, got %d", len(db.dhList))
+	}
+
+	// stream opened before compaction reads the merged data holder
+	_, open, _ := db.GetDataStream("big")
+	_, idx, _ := db.GetDataIndexByKey("big")
+	path := db.dhList[idx].path
+
+	// data holder of chunks is merged once the record is copied next to them
+	doCompaction(db)
+	if len(db.pins) != 0 {
+		t.Fatalf("expected chunks copied to merged data holder, got pins %v", db.pins)
+	}
+	checkStream()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("merged data holder %s deleted while a stream reads it", path)
+	}
+	if b, err := ioutil.ReadAll(open); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("stream of merged data holder failed: %v", err)
+	}
+	open.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("merged data holder %s not deleted after stream was closed", path)
+	}
+
+	doCompaction(db)
+	if len(db.dhList) != 1 {
+		t.Fatalf("expected data holders merged, got %d", len(db.dhList))
+	}
+	checkStream()
+
+	// chunks of a rejected record are left for compaction
+	w = db.NewChunkWriter("big")
+	w.Write(data)
+	if err := db.InsertData(newTestDataDefinition("other10")); err != nil {
+		t.Fatal(err)
+	}
+	rejected := newTestDataDefinition("big")
+	if err := w.Finish(rejected); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertCheckUpsert(rejected, false); err == nil {
+		t.Fatal("expected existing key rejected")
+	}
+	w.Close()
+
+	if len(db.pins) != 0 || len(db.reclaimIn) != 1 {
+		t.Fatalf("expected data holder of rejected chunks marked for compaction, got %v", db.reclaimIn)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_InsertIf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewDatabase(DatabaseDescriptor{
+		Name:           "conditional",
+		Path:           dir,
+		MaxDataLogSize: 1048576,
+		MaxCacheSize:   1024,
+		BloomFilterFp:  0.01,
+		CronExp:        "0 0 1 ? * TUE",
+	})
+	defer db.Close()
+
+	revision := func(rev uint32) func(stored *model.DataDefinition) bool {
+		return func(stored *model.DataDefinition) bool {
+			if stored == nil {
+				return rev == 0
+			}
+			return stored.Revision == rev
+		}
+	}
+
+	rev, err := db.InsertIf(newTestDataDefinition("key"), "0", revision(0))
+	if err != nil || rev != 1 {
+		t.Fatalf("expected revision 1, got %d %v", rev, err)
+	}
+
+	// two writers expecting the same revision, only the first one succeeds
+	if rev, err = db.InsertIf(newTestDataDefinition("key"), "1", revision(1)); err != nil || rev != 2 {
+		t.Fatalf("expected revision 2, got %d %v", rev, err)
+	}
+
+	_, err = db.InsertIf(newTestDataDefinition("key"), "1", revision(1))
+	re, ok := err.(*RevisionError)
+	if !ok || re.Current != 2 {
+		t.Fatalf("expected revision error with current revision 2, got %v", err)
+	}
+
+	// a removed key is matched as missing
+	tbs := model.NewTombstone(newTestDataDefinition("key"))
+	if _, err := db.InsertIf(tbs, "2", revision(2)); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err = db.InsertIf(newTestDataDefinition("key"), "0", revision(0)); err != nil || rev != 4 {
+		t.Fatalf("expected revision 4, got %d %v", rev, err)
+	}
+}
+
+// recordLogger keeps formats of logged messages
+type recordLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordLogger) record(format string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, format)
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{})  { l.record(format) }
+func (l *recordLogger) Warnf(format string, args ...interface{})  { l.record(format) }
+func (l *recordLogger) Errorf(format string, args ...interface{}) { l.record(format) }
+func (l *recordLogger) Fatalf(format string, args ...interface{}) { l.record(format) }
+
+// countCompressor counts data compressed with the wrapped Compressor
+type countCompressor struct {
+	compression.Compressor
+	count *int32
+}
+
+func (c countCompressor) Compress(src []byte) []byte {
+	atomic.AddInt32(c.count, 1)
+	return c.Compressor.Compress(src)
+}
+
+func Test_Options(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var count int32
+	log := &recordLogger{}
+	opts := Options{
+		Codecs: compression.Default().With(compression.CodecZstd, countCompressor{compression.NewZstdCompressor(), &count}),
+		Logger: log,
+	}
+
+	db := NewDatabaseWithOptions(DatabaseDescriptor{
+		Name:           "options",
+		Path:           dir,
+		MaxDataLogSize: 1048576,
+		MaxCacheSize:   1024,
+		BloomFilterFp:  0.01,
+		CronExp:        "0 0 1 ? * TUE",
+		Compression:    "zstd",
+	}, opts)
+	defer db.Close()
+
+	df := newTestDataDefinition("key")
+	df.Buf = bytes.Repeat([]byte("compressible "), 100)
+	if err := db.InsertData(df); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&count) == 0 {
+		t.Fatal("data not compressed with given compressor")
+	}
+
+	doCompaction(db)
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(log.messages) == 0 {
+		t.Fatal("nothing logged with given logger")
+	}
+}
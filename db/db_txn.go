@@ -0,0 +1,187 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// Txn groups inserts and removals of keys of a database, Commit
+// writes all of them in one commitlog record or none
+type Txn struct {
+	db  *Database
+	ops []BatchOp
+}
+
+// Begin starts a transaction of db
+func (db *Database) Begin() *Txn {
+	return &Txn{db: db}
+}
+
+// Insert adds insert of df to t, it fails if its
+// key is stored and upsert is not set
+func (t *Txn) Insert(df *model.DataDefinition, upsert bool) {
+	t.ops = append(t.ops, BatchOp{Data: df, Upsert: upsert})
+}
+
+// Remove adds removal of key to t, it fails if key is not stored
+func (t *Txn) Remove(key string) {
+	t.ops = append(t.ops, BatchOp{Key: key})
+}
+
+// Add adds op to t
+func (t *Txn) Add(op BatchOp) {
+	t.ops = append(t.ops, op)
+}
+
+// Commit writes operations of t while holding database lock, they are
+// seen by readers and replayed after a crash all together. If one of
+// them fails none is written, ErrTransactionAborted is returned and it
+// is the error in results of the others
+func (t *Txn) Commit() ([]BatchResult, error) {
+	db := t.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]BatchResult, len(t.ops))
+	dfs := make([]*model.DataDefinition, len(t.ops))
+
+	// data written by earlier operations of t
+	pending := make(map[string]*model.DataDefinition)
+	lookup := func(key string) *model.DataDefinition {
+		if df, ok := pending[key]; ok {
+			return df
+		}
+		df, _ := db.getDataByKey(key)
+		return df
+	}
+
+	failed := false
+	for i, op := range t.ops {
+		var err error
+		if op.Data != nil {
+			results[i].Key = op.Data.Key
+			dfs[i], err = txnInsert(op, lookup(op.Data.Key))
+		} else {
+			results[i].Key = op.Key
+			dfs[i], err = txnRemove(op.Key, lookup(op.Key), db.Descriptor.Name)
+		}
+
+		if err != nil {
+			results[i].Err, failed = err, true
+			continue
+		}
+		pending[dfs[i].Key] = dfs[i]
+		results[i].Revision = dfs[i].Revision
+	}
+
+	if failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Revision, results[i].Err = 0, errors.ErrTransactionAborted
+			}
+		}
+		return results, errors.ErrTransactionAborted
+	}
+
+	if err := db.writeTxn(dfs); err != nil {
+		for i := range results {
+			results[i].Revision, results[i].Err = 0, err
+		}
+		return results, err
+	}
+	return results, nil
+}
+
+// txnInsert returns df of op with the revision following stored
+func txnInsert(op BatchOp, stored *model.DataDefinition) (*model.DataDefinition, error) {
+	df := op.Data
+	if strings.HasPrefix(df.Key, blobKeyPrefix) {
+		return nil, fmt.Errorf(errors.ErrReservedKey.Error(), df.Key)
+	}
+
+	df.Revision = 1
+	if stored != nil {
+		if stored.Status == model.DataDefinitionActive && !stored.Expired(time.Now()) && !op.Upsert {
+			return nil, fmt.Errorf(errors.ErrKeyExists.Error(), df.Key)
+		}
+		df.Revision = stored.Revision + 1
+	}
+	return df, nil
+}
+
+// txnRemove returns tombstone of key, stored is not changed
+func txnRemove(key string, stored *model.DataDefinition, dbname string) (*model.DataDefinition, error) {
+	if stored == nil || stored.Status != model.DataDefinitionActive || stored.Expired(time.Now()) {
+		return nil, fmt.Errorf(errors.ErrKeyNotFound.Error(), key, dbname)
+	}
+
+	removed := *stored
+	tbs := model.NewTombstone(&removed)
+	tbs.Revision = stored.Revision + 1
+	return tbs, nil
+}
+
+// writeTxn writes dfs, and blobs they refer to, in one commitlog
+// record. db.mu must be held
+func (db *Database) writeTxn(dfs []*model.DataDefinition) error {
+	records := make([]*model.DataDefinition, 0, len(dfs))
+	written := make(map[string]bool)
+
+	for _, df := range dfs {
+		buf := df.Buf
+		if db.Descriptor.Dedup && df.Status == model.DataDefinitionActive && !df.IsChunked() && len(buf) > 0 {
+			id := blobID(buf)
+			if !written[id] {
+				if blob := db.newBlob(df); blob != nil {
+					records = append(records, blob)
+				}
+				written[id] = true
+			}
+			df.Blob, df.Buf = id, nil
+			defer func(df *model.DataDefinition, buf []byte) { df.Buf = buf }(df, buf)
+		} else if len(buf) > 0 {
+			df.Blob = ""
+		}
+		records = append(records, df)
+	}
+
+	batch := make([]commitRecord, len(records))
+	var total int64
+	for i, df := range records {
+		df.Codec = db.codec
+		batch[i] = commitRecord{key: df.Key, status: df.Status, rev: df.Revision, expires: df.Expires, bs: db.enc.ToByteStream(df)}
+		total += int64(batch[i].bs.Size())
+	}
+
+	size, err := db.commitlog.Size()
+	if err != nil {
+		return err
+	}
+
+	// batch record is written in one commitlog
+	if db.finishing == 0 && size+total > int64(db.Descriptor.MaxDataLogSize) {
+		if err := db.rollover(); err != nil {
+			return err
+		}
+	}
+
+	if err := db.commitlog.AddBatch(batch); err != nil {
+		return err
+	}
+
+	for _, r := range batch {
+		db.cache.Put(r.key, r.bs.Bytes())
+	}
+	for _, df := range dfs {
+		if len(df.Blob) > 0 {
+			db.blobs[df.Blob]++
+		}
+		db.pinChunkFiles(df)
+		db.trackExpiry(df)
+	}
+	return nil
+}
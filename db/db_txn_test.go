@@ -0,0 +1,95 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+func Test_Txn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor := DatabaseDescriptor{
+		Name:           "txn",
+		Path:           dir,
+		MaxDataLogSize: 1048576,
+		MaxCacheSize:   1024,
+		BloomFilterFp:  0.01,
+		CronExp:        "0 0 1 ? * TUE",
+		CommitlogSync:  SyncAlways,
+		Dedup:          true,
+	}
+	db := NewDatabase(descriptor)
+
+	if err := db.InsertData(newTestDataDefinition("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := db.Begin()
+	txn.Insert(newTestDataDefinition("original"), false)
+	txn.Insert(newTestDataDefinition("small"), false)
+	txn.Insert(newTestDataDefinition("small"), true)
+	txn.Remove("old")
+	results, err := txn.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1].Revision != 1 || results[2].Revision != 2 || results[3].Revision != 1 {
+		t.Fatalf("unexpected revisions %v", results)
+	}
+
+	// failed operation aborts the others
+	txn = db.Begin()
+	txn.Insert(newTestDataDefinition("other"), false)
+	txn.Insert(newTestDataDefinition("original"), false)
+	results, err = txn.Commit()
+	if err != errors.ErrTransactionAborted || results[0].Err != errors.ErrTransactionAborted || results[1].Err == nil {
+		t.Fatalf("expected aborted transaction, got %v %v", results, err)
+	}
+	if _, ok := db.GetDataByKey("other"); ok {
+		t.Fatal("operation of aborted transaction written")
+	}
+	db.Close()
+
+	// torn batch record is dropped as a whole
+	db = openTestDatabase(t, descriptor)
+	txn = db.Begin()
+	txn.Insert(newTestDataDefinition("torn1"), false)
+	txn.Insert(newTestDataDefinition("torn2"), false)
+	if _, err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	path := filepath.Join(dir, FolderCommitlog, "commitlog.spw")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-4); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDatabase(t, descriptor)
+	defer db.Close()
+
+	if df, ok := db.GetDataByKey("small"); !ok || df.Revision != 2 || string(df.Buf) != "image content of small" {
+		t.Fatal("small not replayed")
+	}
+	if df, ok := db.GetDataByKey("old"); !ok || df.Status != model.DataDefinitionRemoved {
+		t.Fatal("old not removed")
+	}
+	for _, key := range []string{"torn1", "torn2"} {
+		if _, ok := db.GetDataByKey(key); ok {
+			t.Fatalf("%s of torn transaction replayed", key)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// historyEntry returns history index entry of revision of
+// key stored at offset
+func historyEntry(key string, offset int64, status uint16, rev uint32) *index.Entry {
+	return &index.Entry{
+		Key:      index.RevisionKey(key, rev),
+		Offset:   offset,
+		Status:   status,
+		Revision: rev,
+	}
+}
+
+// writeHistoryIndex replaces history index file
+func writeHistoryIndex(sto engine.Storage, entries []*index.Entry) error {
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileHistoryIndex}, func(w engine.Writer) error {
+		return index.WriteSortedIndex(w, entries, index.DefaultSampleInterval)
+	})
+}
+
+// revisionEntries returns history index entries of key
+// in si, from newest to oldest revision
+func revisionEntries(si *index.SortedIndex, key string) ([]*index.Entry, error) {
+	entries := make([]*index.Entry, 0)
+	if si == nil {
+		return entries, nil
+	}
+
+	prefix := index.RevisionPrefix(key)
+	err := si.IterateFrom(prefix, func(e *index.Entry) bool {
+		if !strings.HasPrefix(e.Key, prefix) {
+			return false
+		}
+		// a longer key may share the prefix
+		if k, ok := index.SplitRevisionKey(e.Key); ok && k == key {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	return entries, err
+}
+
+// openHistoryIndex opens history index file, it is rebuilt
+// from data file if it does not exist or can not be loaded
+func (d *DataHolder) openHistoryIndex() error {
+	desc := engine.FileDesc{Type: engine.FileHistoryIndex}
+
+	if d.sto.Exists(desc) {
+		if err := d.loadHistoryIndex(); err == nil {
+			return nil
+		}
+	}
+
+	d.log.Infof("Rebuilding history index of %s", d.path)
+
+	entries := make([]*index.Entry, 0)
+	_, err := scanDataFile(d.sto, engine.FileDesc{Type: engine.FileData}, func(offset int64, df *model.DataDefinition) {
+		entries = append(entries, historyEntry(df.Key, offset, df.Status, df.Revision))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeHistoryIndex(d.sto, entries); err != nil {
+		return err
+	}
+
+	return d.loadHistoryIndex()
+}
+
+func (d *DataHolder) loadHistoryIndex() error {
+	si, freader, err := openIndexFile(d.sto, engine.FileDesc{Type: engine.FileHistoryIndex})
+	if err != nil {
+		return err
+	}
+
+	if d.hindexFile != nil {
+		d.hindexFile.Close()
+	}
+	d.hindex, d.hindexFile = si, freader
+	return nil
+}
@@ -0,0 +1,39 @@
+package index
+
+import "strings"
+
+// keySep separates the parts of the keys of attribute and history
+// indexes, attribute names and values can not hold it
+const keySep = "\x00"
+
+// AttributeKey returns the key of attribute index entry
+func AttributeKey(name, value, key string) string {
+	return name + keySep + value + keySep + key
+}
+
+// AttributePrefix returns the prefix shared by attribute index keys
+// of attribute name whose value starts with value
+func AttributePrefix(name, value string) string {
+	return name + keySep + value
+}
+
+// SplitAttributeKey returns attribute name, value and data key of
+// attribute index key
+func SplitAttributeKey(s string) (name, value, key string) {
+	parts := strings.SplitN(s, keySep, 3)
+	if len(parts) != 3 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// AttributeMarker returns the key that marks attribute name as indexed
+// in attribute index, markers are sorted before any attribute key
+func AttributeMarker(name string) string {
+	return keySep + name
+}
+
+// IsAttributeMarker checks if s is the key of an attribute marker
+func IsAttributeMarker(s string) bool {
+	return strings.HasPrefix(s, keySep)
+}
@@ -1,31 +1,88 @@
 package index
 
-import "github.com/SparrowDb/sparrowdb/util"
+import (
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+const (
+	// entryVersion version of the entry format
+	//   2: key, offset, status, revision
+	//   3: expiry time after revision
+	entryVersion = 3
+
+	// legacyEntrySize size of entries written before versioning, they
+	// only kept 32 bit hash of the key. Versioned entries are always
+	// larger because they start with version and key size
+	legacyEntrySize = 18
+)
 
 // Entry holds index entry
 type Entry struct {
-	Key      uint32
+	Key      string
 	Offset   int64
 	Status   uint16
 	Revision uint32
+
+	// unix time in seconds data of entry expires at, 0 if it does not
+	Expires int64
+
+	// set when entry was read from an index written before
+	// expiry time was stored, Expires is 0
+	expiryUnknown bool
 }
 
 // Bytes returns byte array with index entry data
 func (e *Entry) Bytes() []byte {
 	bs := util.NewByteStream()
-	bs.PutUInt32(e.Key)
+	bs.PutUInt16(entryVersion)
+	bs.PutString(e.Key)
 	bs.PutUInt64(uint64(e.Offset))
 	bs.PutUInt16(e.Status)
 	bs.PutUInt32(e.Revision)
+	bs.PutUInt64(uint64(e.Expires))
 	return bs.Bytes()
 }
 
+// ExpiryKnown checks if Expires holds expiry time of data, entries
+// of indexes written before it was stored do not hold it
+func (e *Entry) ExpiryKnown() bool {
+	return !e.expiryUnknown
+}
+
+// Expired checks if data of entry expired at time now
+func (e *Entry) Expired(now time.Time) bool {
+	return e.Expires != 0 && now.Unix() >= e.Expires
+}
+
+// IsLegacy checks if entry was read from an index written before full
+// keys were stored. Its Key is empty and must be read from data file
+func (e *Entry) IsLegacy() bool {
+	return len(e.Key) == 0
+}
+
 // NewEntryFromByteStream convert ByteStream to Entry
 func NewEntryFromByteStream(bs *util.ByteStream) *Entry {
 	df := Entry{}
-	df.Key = bs.GetUInt32()
+
+	version := uint16(1)
+	if bs.Size() == legacyEntrySize {
+		// 32 bit hash of the key
+		bs.GetUInt32()
+	} else {
+		version = bs.GetUInt16()
+		df.Key = bs.GetString()
+	}
+
 	df.Offset = int64(bs.GetUInt64())
 	df.Status = bs.GetUInt16()
 	df.Revision = bs.GetUInt32()
+
+	if version >= 3 {
+		df.Expires = int64(bs.GetUInt64())
+	} else {
+		df.expiryUnknown = true
+	}
 	return &df
 }
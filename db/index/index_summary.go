@@ -2,7 +2,7 @@ package index
 
 // Summary holds index data
 type Summary struct {
-	table map[uint32]*Entry
+	table map[string]*Entry
 	count uint32
 }
 
@@ -12,14 +12,22 @@ func (s *Summary) Add(e *Entry) {
 	s.table[e.Key] = e
 }
 
+// Remove removes entry of key from index
+func (s *Summary) Remove(key string) {
+	if _, ok := s.table[key]; ok {
+		s.count--
+		delete(s.table, key)
+	}
+}
+
 // LookUp search in index table
-func (s *Summary) LookUp(key uint32) (*Entry, bool) {
+func (s *Summary) LookUp(key string) (*Entry, bool) {
 	value, ok := s.table[key]
 	return value, ok
 }
 
 // GetTable returns the index holder
-func (s *Summary) GetTable() map[uint32]*Entry {
+func (s *Summary) GetTable() map[string]*Entry {
 	return s.table
 }
 
@@ -31,6 +39,6 @@ func (s *Summary) Count() uint32 {
 // NewSummary returns new Summary
 func NewSummary() *Summary {
 	return &Summary{
-		table: make(map[uint32]*Entry),
+		table: make(map[string]*Entry),
 	}
 }
@@ -0,0 +1,29 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+)
+
+// revisionSize size of revision part of history index key
+const revisionSize = 8
+
+// RevisionKey returns the key of history index entry, revisions
+// of a key are sorted from newest to oldest
+func RevisionKey(key string, rev uint32) string {
+	return key + keySep + fmt.Sprintf("%08x", ^rev)
+}
+
+// RevisionPrefix returns the prefix of history index keys of key
+func RevisionPrefix(key string) string {
+	return key + keySep
+}
+
+// SplitRevisionKey returns data key of history index key
+func SplitRevisionKey(s string) (string, bool) {
+	n := len(s) - revisionSize - len(keySep)
+	if n < 0 || !strings.HasPrefix(s[n:], keySep) {
+		return "", false
+	}
+	return s[:n], true
+}
@@ -0,0 +1,256 @@
+package index
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+const (
+	// DefaultSampleInterval number of entries between two keys
+	// of sorted index that are kept in memory
+	DefaultSampleInterval = 128
+
+	sortedIndexMagic   = 0x53505349
+	sortedIndexVersion = 2
+
+	// magic, version, sample interval, entry count,
+	// samples offset, samples checksum
+	footerSize = 26
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// sample holds the first key of a block of entries
+// and the block position in file
+type sample struct {
+	key    string
+	offset int64
+}
+
+// SortedIndex immutable index of a sealed data holder. Entries are kept on
+// disk sorted by key and only one key for each sample interval is kept in
+// memory, it is used to find the block of entries that may hold a key
+type SortedIndex struct {
+	reader        io.ReaderAt
+	count         uint32
+	interval      uint32
+	samplesOffset int64
+	samples       []sample
+}
+
+type entriesByKey []*Entry
+
+func (e entriesByKey) Len() int           { return len(e) }
+func (e entriesByKey) Less(i, j int) bool { return e[i].Key < e[j].Key }
+func (e entriesByKey) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// SortEntries returns entries sorted by key. When a key appears more than
+// once only the last one is kept, as Summary.Add does
+func SortEntries(entries []*Entry) []*Entry {
+	sorted := make([]*Entry, len(entries))
+	copy(sorted, entries)
+	sort.Stable(entriesByKey(sorted))
+
+	result := sorted[:0]
+	for _, e := range sorted {
+		if n := len(result); n > 0 && result[n-1].Key == e.Key {
+			result[n-1] = e
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// WriteSortedIndex writes entries as sorted index
+func WriteSortedIndex(w io.Writer, entries []*Entry, interval uint32) error {
+	bw := bufio.NewWriter(w)
+	sorted := SortEntries(entries)
+	samples := util.NewByteStream()
+	var pos int64
+
+	for i, e := range sorted {
+		if uint32(i)%interval == 0 {
+			samples.PutString(e.Key)
+			samples.PutUInt64(uint64(pos))
+		}
+
+		rec := util.NewByteStream()
+		rec.PutBytes(e.Bytes())
+		if _, err := bw.Write(rec.Bytes()); err != nil {
+			return err
+		}
+		pos += int64(rec.Size())
+	}
+
+	if _, err := bw.Write(samples.Bytes()); err != nil {
+		return err
+	}
+
+	footer := util.NewByteStream()
+	footer.PutUInt32(sortedIndexMagic)
+	footer.PutUInt16(sortedIndexVersion)
+	footer.PutUInt32(interval)
+	footer.PutUInt32(uint32(len(sorted)))
+	footer.PutUInt64(uint64(pos))
+	footer.PutUInt32(crc32.Checksum(samples.Bytes(), crcTable))
+
+	if _, err := bw.Write(footer.Bytes()); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// OpenSortedIndex loads sampled keys of sorted index, r must remain
+// open while the index is used
+func OpenSortedIndex(r io.ReaderAt, size int64) (si *SortedIndex, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			si, err = nil, errors.ErrInvalidIndex
+		}
+	}()
+
+	if size < footerSize {
+		return nil, errors.ErrInvalidIndex
+	}
+
+	bFooter := make([]byte, footerSize)
+	if _, err := r.ReadAt(bFooter, size-footerSize); err != nil {
+		return nil, err
+	}
+
+	footer := util.NewByteStreamFromBytes(bFooter)
+	if footer.GetUInt32() != sortedIndexMagic || footer.GetUInt16() != sortedIndexVersion {
+		return nil, errors.ErrInvalidIndex
+	}
+
+	si = &SortedIndex{reader: r}
+	si.interval = footer.GetUInt32()
+	si.count = footer.GetUInt32()
+	si.samplesOffset = int64(footer.GetUInt64())
+	checksum := footer.GetUInt32()
+
+	if si.interval == 0 || si.samplesOffset > size-footerSize {
+		return nil, errors.ErrInvalidIndex
+	}
+
+	bSamples := make([]byte, size-footerSize-si.samplesOffset)
+	if _, err := r.ReadAt(bSamples, si.samplesOffset); err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(bSamples, crcTable) != checksum {
+		return nil, errors.ErrInvalidIndex
+	}
+
+	samples := util.NewByteStreamFromBytes(bSamples)
+	si.samples = make([]sample, (si.count+si.interval-1)/si.interval)
+	for i := range si.samples {
+		si.samples[i].key = samples.GetString()
+		si.samples[i].offset = int64(samples.GetUInt64())
+	}
+
+	return si, nil
+}
+
+// readBlock reads entries of the block that starts at sample i
+func (si *SortedIndex) readBlock(i int) (entries []*Entry, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			entries, err = nil, errors.ErrInvalidIndex
+		}
+	}()
+
+	end := si.samplesOffset
+	if i+1 < len(si.samples) {
+		end = si.samples[i+1].offset
+	}
+
+	b := make([]byte, end-si.samples[i].offset)
+	if _, err := si.reader.ReadAt(b, si.samples[i].offset); err != nil {
+		return nil, err
+	}
+
+	n := si.interval
+	if first := uint32(i) * si.interval; first+n > si.count {
+		n = si.count - first
+	}
+
+	bs := util.NewByteStreamFromBytes(b)
+	entries = make([]*Entry, n)
+	for j := range entries {
+		entries[j] = NewEntryFromByteStream(util.NewByteStreamFromBytes(bs.GetBytes()))
+	}
+	return entries, nil
+}
+
+// blockOf returns the block that may hold key, -1 if key
+// is lower than the first key of index
+func (si *SortedIndex) blockOf(key string) int {
+	return sort.Search(len(si.samples), func(i int) bool {
+		return si.samples[i].key > key
+	}) - 1
+}
+
+// LookUp search key in sorted index
+func (si *SortedIndex) LookUp(key string) (*Entry, bool) {
+	i := si.blockOf(key)
+	if i < 0 {
+		return nil, false
+	}
+
+	entries, err := si.readBlock(i)
+	if err != nil {
+		return nil, false
+	}
+
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j].Key >= key
+	})
+	if j == len(entries) || entries[j].Key != key {
+		return nil, false
+	}
+	return entries[j], true
+}
+
+// Iterate calls fn for each entry in key order until fn returns false
+func (si *SortedIndex) Iterate(fn func(e *Entry) bool) error {
+	return si.IterateFrom("", fn)
+}
+
+// IterateFrom calls fn for each entry with key greater or equal than
+// from, in key order until fn returns false
+func (si *SortedIndex) IterateFrom(from string, fn func(e *Entry) bool) error {
+	first := si.blockOf(from)
+	if first < 0 {
+		first = 0
+	}
+
+	for i := first; i < len(si.samples); i++ {
+		entries, err := si.readBlock(i)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if e.Key < from {
+				continue
+			}
+			if !fn(e) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Count returns the number of entries in SortedIndex
+func (si *SortedIndex) Count() uint32 {
+	return si.count
+}
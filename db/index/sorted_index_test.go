@@ -0,0 +1,97 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+func Test_SortedIndex(t *testing.T) {
+	entries := make([]*Entry, 0)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("image%d", (i*7919)%100003)
+		entries = append(entries, &Entry{Key: key, Offset: int64(i)})
+	}
+
+	// same key added again must replace the first entry
+	entries = append(entries, &Entry{Key: entries[10].Key, Offset: 5000})
+
+	var buf bytes.Buffer
+	if err := WriteSortedIndex(&buf, entries, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	si, err := OpenSortedIndex(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if si.Count() != 1000 {
+		t.Fatalf("expected 1000 entries, got %d", si.Count())
+	}
+
+	for i, e := range entries[:1000] {
+		found, ok := si.LookUp(e.Key)
+		if !ok {
+			t.Fatalf("key %s not found", e.Key)
+		}
+		if i != 10 && found.Offset != e.Offset {
+			t.Fatalf("key %s expected offset %d, got %d", e.Key, e.Offset, found.Offset)
+		}
+	}
+
+	if e, _ := si.LookUp(entries[10].Key); e.Offset != 5000 {
+		t.Fatalf("expected last entry of duplicated key, got offset %d", e.Offset)
+	}
+
+	for _, key := range []string{"", "a", "image", "image1000000", "z"} {
+		if _, ok := si.LookUp(key); ok {
+			t.Fatalf("found key %s that was not added", key)
+		}
+	}
+
+	var last string
+	n := 0
+	si.Iterate(func(e *Entry) bool {
+		if n > 0 && e.Key <= last {
+			t.Fatalf("entries not sorted: %s after %s", e.Key, last)
+		}
+		last = e.Key
+		n++
+		return true
+	})
+	if n != 1000 {
+		t.Fatalf("expected to iterate 1000 entries, got %d", n)
+	}
+
+	si.IterateFrom("image5", func(e *Entry) bool {
+		if e.Key < "image5" {
+			t.Fatalf("iterate from image5 returned %s", e.Key)
+		}
+		return true
+	})
+
+	// corrupted footer must not be loaded
+	b := buf.Bytes()
+	b[len(b)-1] ^= 0xff
+	if _, err := OpenSortedIndex(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Fatal("expected error opening corrupted index")
+	}
+}
+
+func Test_LegacyEntry(t *testing.T) {
+	e := &Entry{Key: "image", Offset: 42, Status: 1, Revision: 3}
+	n := NewEntryFromByteStream(util.NewByteStreamFromBytes(e.Bytes()))
+	if *n != *e {
+		t.Fatalf("expected %v, got %v", e, n)
+	}
+
+	// 32 bit hash, offset, status and revision
+	legacy := []byte{1, 2, 3, 4, 42, 0, 0, 0, 0, 0, 0, 0, 1, 0, 3, 0, 0, 0}
+	l := NewEntryFromByteStream(util.NewByteStreamFromBytes(legacy))
+	if !l.IsLegacy() || l.Offset != 42 || l.Status != 1 || l.Revision != 3 {
+		t.Fatalf("wrong legacy entry %v", l)
+	}
+}
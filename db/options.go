@@ -0,0 +1,25 @@
+package db
+
+import (
+	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/slog"
+)
+
+// Options holds dependencies of databases, nil ones are replaced by defaults
+type Options struct {
+	// Codecs compress stored data, compression.Default() if nil
+	Codecs *compression.Codecs
+
+	// Logger logs database events, slog.Default() if nil
+	Logger slog.Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.Codecs == nil {
+		o.Codecs = compression.Default()
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	return o
+}
@@ -5,7 +5,7 @@ import (
 
 	"github.com/SparrowDb/sparrowdb/db/index"
 	"github.com/SparrowDb/sparrowdb/engine"
-	"github.com/SparrowDb/sparrowdb/slog"
+	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/util"
 )
 
@@ -15,28 +15,97 @@ type dbReader struct {
 }
 
 func (r *dbReader) Read(offset int64) ([]byte, error) {
-	bSize := make([]byte, 4)
+	b, _, err := r.ReadNext(offset)
+	return b, err
+}
+
+// ReadNext reads the record at offset, verifies its checksum and
+// returns its data and the offset of the following record
+func (r *dbReader) ReadNext(offset int64) ([]byte, int64, error) {
+	bSize := make([]byte, recordSizeMark)
 	if _, err := r.reader.ReadAt(bSize, offset); err != nil {
-		slog.Fatalf(err.Error())
+		return nil, offset, err
 	}
 
 	bs := util.NewByteStreamFromBytes(bSize)
 	size := bs.GetUInt32()
+	checksummed := size&recordChecksumFlag != 0
+	size &^= recordChecksumFlag
 
 	// Skip 4 bytes of the size mark
-	offset += 4
+	offset += recordSizeMark
+
+	var checksum uint32
+	if checksummed {
+		bCrc := make([]byte, recordChecksumMark)
+		if _, err := r.reader.ReadAt(bCrc, offset); err != nil {
+			return nil, offset, io.ErrUnexpectedEOF
+		}
+		checksum = util.NewByteStreamFromBytes(bCrc).GetUInt32()
+		offset += recordChecksumMark
+	}
+
+	// A torn size mark may point far beyond the end of file, check
+	// that the last byte of the record exists before allocating it
+	if size > 0 {
+		if _, err := r.reader.ReadAt(make([]byte, 1), offset+int64(size)-1); err != nil {
+			return nil, offset, io.ErrUnexpectedEOF
+		}
+	}
 
 	// Reads data
 	bufData := make([]byte, size)
 	if _, err := r.reader.ReadAt(bufData, offset); err != nil {
-		return nil, err
+		return nil, offset, io.ErrUnexpectedEOF
+	}
+
+	if checksummed && recordChecksum(bufData) != checksum {
+		return nil, offset, errors.ErrChecksum
 	}
 
 	if err := r.Close(); err != nil {
-		return nil, err
+		return nil, offset, err
 	}
 
-	return bufData, nil
+	return bufData, offset + int64(size), nil
+}
+
+// recordEnd returns the offset following the record at offset
+// read from its size mark, false if size mark can not be read
+func (r *dbReader) recordEnd(offset int64) (int64, bool) {
+	bSize := make([]byte, recordSizeMark)
+	if _, err := r.reader.ReadAt(bSize, offset); err != nil {
+		return offset, false
+	}
+
+	size := util.NewByteStreamFromBytes(bSize).GetUInt32()
+	end := offset + recordSizeMark + int64(size&^recordChecksumFlag)
+	if size&recordChecksumFlag != 0 {
+		end += recordChecksumMark
+	}
+	return end, true
+}
+
+// chainsTo checks if records that follow each other from
+// offset end exactly at end
+func (r *dbReader) chainsTo(offset, end int64) bool {
+	for offset < end {
+		next, ok := r.recordEnd(offset)
+		if !ok || next <= offset {
+			return false
+		}
+		offset = next
+	}
+	return offset == end
+}
+
+// checksummed checks if the size mark at offset has checksum flag
+func (r *dbReader) checksummed(offset int64) bool {
+	bSize := make([]byte, recordSizeMark)
+	if _, err := r.reader.ReadAt(bSize, offset); err != nil {
+		return false
+	}
+	return util.NewByteStreamFromBytes(bSize).GetUInt32()&recordChecksumFlag != 0
 }
 
 func (r *dbReader) Close() error {
@@ -47,42 +116,50 @@ func newReader(f io.ReaderAt) *dbReader {
 	return &dbReader{f, 0}
 }
 
-type indexReader struct {
-	sto *engine.Storage
-}
-
-func (ir *indexReader) LoadIndex() (index.Summary, error) {
-	summary := index.NewSummary()
-
+// readIndexFile reads all entries of index file
+func readIndexFile(sto engine.Storage) ([]*index.Entry, error) {
 	desc := engine.FileDesc{Type: engine.FileIndex}
 	var pos int64
-	var s = (*ir.sto)
 
-	size, err := s.Size(desc)
+	size, err := sto.Size(desc)
 	if err != nil {
-		slog.Fatalf(err.Error())
+		return nil, err
 	}
 
-	freader, err := s.Open(desc)
+	freader, err := sto.Open(desc)
 	if err != nil {
-		slog.Fatalf(err.Error())
+		return nil, err
 	}
+	defer freader.Close()
 
-	r := newReader(freader.(io.ReaderAt))
+	r := newReader(freader)
+	entries := make([]*index.Entry, 0)
 
 	for pos < size {
-		if b, err := r.Read(pos); err == nil {
-			bs := util.NewByteStreamFromBytes(b)
-			summary.Add(index.NewEntryFromByteStream(bs))
-			pos += int64(bs.Size()) + 4
-		} else {
-			slog.Fatalf(err.Error())
+		b, next, err := r.ReadNext(pos)
+		if err != nil {
+			return entries, err
 		}
+		e, err := decodeIndexEntry(b)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+		pos = next
 	}
 
-	return *summary, nil
+	return entries, nil
 }
 
-func newIndexReader(sto *engine.Storage) *indexReader {
-	return &indexReader{sto}
+// writeIndexFile replaces index file with the given entries
+func writeIndexFile(sto engine.Storage, entries []*index.Entry) error {
+	return replaceFile(sto, engine.FileDesc{Type: engine.FileIndex}, func(w engine.Writer) error {
+		writer := newBufWriter(w)
+		for _, e := range entries {
+			if err := writer.Append(e.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
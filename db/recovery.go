@@ -0,0 +1,237 @@
+package db
+
+import (
+	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+// ByteRange holds a range of bytes in a file, End is exclusive
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+const (
+	// scanStop stops at the first record that can not be read
+	scanStop = iota
+
+	// scanSkip skips a record that can not be read when its size mark
+	// is intact and other records follow it, otherwise it searches for
+	// the next valid record. It stops at an unreadable last record
+	scanSkip
+
+	// scanResync searches for the next valid record after
+	// one that can not be read
+	scanResync
+)
+
+// scanDataFile reads data file record by record from the beginning and
+// calls fn with the offset and header of each record but chunks. Records
+// that can not be read in the middle of the file are skipped, it stops at
+// an incomplete last record and returns its offset, which is the end of
+// the valid part of the file
+func scanDataFile(sto engine.Storage, desc engine.FileDesc, fn func(offset int64, df *model.DataDefinition)) (int64, error) {
+	end, _, err := scanRecords(sto, desc, scanSkip, fn)
+	return end, err
+}
+
+// scanDataFileRanges reads data file record by record like scanDataFile,
+// but when a record can not be read it searches for the next valid record
+// and goes on. Returns the ranges of bytes that could not be read
+func scanDataFileRanges(sto engine.Storage, desc engine.FileDesc, fn func(offset int64, df *model.DataDefinition)) ([]ByteRange, error) {
+	_, unreadable, err := scanRecords(sto, desc, scanResync, fn)
+	return unreadable, err
+}
+
+func scanRecords(sto engine.Storage, desc engine.FileDesc, mode int, fn func(offset int64, df *model.DataDefinition)) (int64, []ByteRange, error) {
+	var pos int64
+	unreadable := make([]ByteRange, 0)
+
+	if !sto.Exists(desc) {
+		return pos, unreadable, nil
+	}
+
+	size, err := sto.Size(desc)
+	if err != nil {
+		return pos, unreadable, err
+	}
+
+	freader, err := sto.Open(desc)
+	if err != nil {
+		return pos, unreadable, err
+	}
+	defer freader.Close()
+
+	r := newReader(freader)
+
+	for pos < size {
+		df, next, err := readRecordHeader(r, pos)
+		if err == nil && df.Status == model.DataDefinitionBatch {
+			err = readBatchRecord(r, next-int64(df.Size), next, fn)
+		} else if err == nil && df.Status != model.DataDefinitionChunk {
+			// chunks are read through the record that refers to them
+			fn(pos, df)
+		}
+		if err == nil {
+			pos = next
+			continue
+		}
+
+		if mode == scanStop {
+			break
+		}
+
+		if mode == scanSkip {
+			// size mark of the record is intact if the following
+			// record starts where it ends
+			if next, ok := r.recordEnd(pos); ok && next < size {
+				if end, ok := r.recordEnd(next); ok && end <= size {
+					unreadable = append(unreadable, ByteRange{pos, next})
+					pos = next
+					continue
+				}
+			}
+		}
+
+		// Only records with checksum are accepted when searching for the
+		// next valid record, size mark of records without it can not be
+		// told apart from garbage. When skipping, records must also lead
+		// to the end of file, records held by a batch record torn by an
+		// interrupted write do not
+		start := pos
+		for pos++; pos < size; pos++ {
+			if !r.checksummed(pos) {
+				continue
+			}
+			if _, _, err := readRecordHeader(r, pos); err == nil && (mode != scanSkip || r.chainsTo(pos, size)) {
+				break
+			}
+		}
+
+		// no record follows, it is the one being written when it was
+		// interrupted and the valid part of the file ends before it
+		if mode == scanSkip && pos >= size {
+			pos = start
+			break
+		}
+		unreadable = append(unreadable, ByteRange{start, pos})
+	}
+
+	return pos, unreadable, nil
+}
+
+// batchRecord returns batch record holding framed records inner,
+// they are its data, stored uncompressed at the end of the record
+func batchRecord(inner []byte) *util.ByteStream {
+	df := &model.DataDefinition{
+		Size:   uint32(len(inner)),
+		Status: model.DataDefinitionBatch,
+		Codec:  compression.CodecNone,
+		Buf:    inner,
+	}
+	return df.ToByteStream()
+}
+
+// readBatchRecord reads records of a batch record held between
+// start and end, fn is called for them only if all can be read
+func readBatchRecord(r *dbReader, start, end int64, fn func(offset int64, df *model.DataDefinition)) error {
+	offsets := make([]int64, 0)
+	records := make([]*model.DataDefinition, 0)
+
+	for pos := start; pos < end; {
+		df, next, err := readRecordHeader(r, pos)
+		if err != nil {
+			return err
+		}
+		if next > end || df.Status == model.DataDefinitionBatch {
+			return errors.ErrCorruptedRecord
+		}
+		if df.Status != model.DataDefinitionChunk {
+			offsets = append(offsets, pos)
+			records = append(records, df)
+		}
+		pos = next
+	}
+
+	for i, df := range records {
+		fn(offsets[i], df)
+	}
+	return nil
+}
+
+func readRecordHeader(r *dbReader, offset int64) (*model.DataDefinition, int64, error) {
+	b, next, err := r.ReadNext(offset)
+	if err != nil {
+		return nil, offset, err
+	}
+
+	df, err := decodeRecordHeader(b)
+	if err != nil {
+		return nil, offset, err
+	}
+
+	return df, next, nil
+}
+
+// decodeRecordHeader decodes the DataDefinition header of a record,
+// content that can not be decoded returns error instead of panic
+func decodeRecordHeader(b []byte) (df *model.DataDefinition, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			df, err = nil, errors.ErrCorruptedRecord
+		}
+	}()
+
+	bs := util.NewByteStreamFromBytes(b)
+	return model.NewDataDefinitionHeaderFromByteStream(bs), nil
+}
+
+// decodeRecord decodes record with its data, content or
+// data that can not be decoded returns error
+func decodeRecord(enc *model.Encoder, b []byte) (df *model.DataDefinition, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			df, err = nil, errors.ErrCorruptedRecord
+		}
+	}()
+
+	df = enc.FromByteStream(util.NewByteStreamFromBytes(b))
+	if df.Corrupted {
+		return nil, errors.ErrCorruptedRecord
+	}
+	return df, nil
+}
+
+// decodeIndexEntry decodes index entry of a record, content that can
+// not be decoded returns error instead of panic
+func decodeIndexEntry(b []byte) (e *index.Entry, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			e, err = nil, errors.ErrCorruptedRecord
+		}
+	}()
+
+	bs := util.NewByteStreamFromBytes(b)
+	return index.NewEntryFromByteStream(bs), nil
+}
+
+// sameIndexEntries checks if index entries point to the same records
+func sameIndexEntries(a, b []*index.Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Offset != b[i].Offset ||
+			a[i].Status != b[i].Status || a[i].Revision != b[i].Revision ||
+			a[i].Expires != b[i].Expires {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/engine"
+	"github.com/SparrowDb/sparrowdb/model"
+)
+
+// RepairReport holds the result of a data holder repair
+type RepairReport struct {
+	Path       string      `json:"path"`
+	Records    int         `json:"records"`
+	Unreadable []ByteRange `json:"unreadable"`
+}
+
+// RepairDataHolder rebuilds indexes and bloom filter of the data holder in
+// path reading only its data file, attribute index is removed. Records that
+// can not be read are left in data file, their byte ranges are returned in
+// RepairReport
+func RepairDataHolder(path string, bloomFilterFp float32) (*RepairReport, error) {
+	sto, err := engine.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := RepairReport{Path: path}
+	entries := make([]*index.Entry, 0)
+	history := make([]*index.Entry, 0)
+	blobRefs := make([]*index.Entry, 0)
+
+	report.Unreadable, err = scanDataFileRanges(sto, engine.FileDesc{Type: engine.FileData}, func(offset int64, df *model.DataDefinition) {
+		entries = append(entries, &index.Entry{
+			Key:      df.Key,
+			Offset:   offset,
+			Status:   df.Status,
+			Revision: df.Revision,
+			Expires:  df.Expires,
+		})
+		history = append(history, historyEntry(df.Key, offset, df.Status, df.Revision))
+		blobRefs = append(blobRefs, refEntries(df, offset)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Records = len(entries)
+
+	if err := writeIndexFile(sto, entries); err != nil {
+		return nil, err
+	}
+
+	if err := writeSortedIndex(sto, entries); err != nil {
+		return nil, err
+	}
+
+	bf := newBloomFilterFromEntries(entries, bloomFilterFp)
+	if err := writeBloomFilter(sto, &bf); err != nil {
+		return nil, err
+	}
+
+	if err := writeHistoryIndex(sto, history); err != nil {
+		return nil, err
+	}
+
+	if err := writeBlobIndex(sto, blobRefs); err != nil {
+		return nil, err
+	}
+
+	// attributes indexed by database are not known here, attribute
+	// index is rebuilt when database opens the data holder
+	if err := sto.Remove(engine.FileDesc{Type: engine.FileAttributeIndex}); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_RepairDataHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, sizes := fillCommitlog(t, dir, 3)
+	dh, err := NewDataHolder(c, dir, 0.01, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the second record and lose index and bloom filter
+	dataPath := filepath.Join(dh.path, "data.spw")
+	b, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[sizes[0]+10] ^= 0xff
+	ioutil.WriteFile(dataPath, b, 0644)
+	os.Remove(filepath.Join(dh.path, "index.spw"))
+	os.Remove(filepath.Join(dh.path, "bloom.spw"))
+
+	report, err := RepairDataHolder(dh.path, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Records != 2 {
+		t.Fatalf("expected 2 records, got %d", report.Records)
+	}
+	if len(report.Unreadable) != 1 || report.Unreadable[0] != (ByteRange{sizes[0], sizes[1]}) {
+		t.Fatalf("expected unreadable range %d-%d, got %v", sizes[0], sizes[1], report.Unreadable)
+	}
+
+	rdh, err := OpenDataHolder(dh.path, 0.01, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"key0", "key2"} {
+		if _, ok := rdh.sindex.LookUp(key); !ok {
+			t.Fatalf("key %s not found in repaired index", key)
+		}
+	}
+	if _, ok := rdh.sindex.LookUp("key1"); ok {
+		t.Fatal("unreadable key1 must not be indexed")
+	}
+}
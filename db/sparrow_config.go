@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/slog"
@@ -12,26 +13,88 @@ import (
 const (
 	// DefaultSparrowConfigFile is the default configuration file
 	DefaultSparrowConfigFile = "sparrow.xml"
+
+	// DefaultCommitlogSync default commitlog sync policy
+	DefaultCommitlogSync = SyncBatch
+
+	// DefaultCommitlogSyncInterval default interval in milliseconds
+	// between commitlog syncs when sync policy is batch
+	DefaultCommitlogSyncInterval = 1000
+
+	// DefaultCompactionTiers default upper data file size of
+	// each compaction tier, 16MB, 128MB and 1GB
+	DefaultCompactionTiers = "16777216,134217728,1073741824"
+
+	// DefaultCompactionMinHolders default number of data holders
+	// of a tier needed to merge them
+	DefaultCompactionMinHolders = 4
+
+	// DefaultTombstoneGracePeriod default time in seconds a tombstone
+	// is kept before compaction can drop it, 10 days
+	DefaultTombstoneGracePeriod = 864000
+
+	// DefaultMaxRevisions default number of revisions of a key
+	// kept by compaction, only the current one
+	DefaultMaxRevisions = 1
+
+	// DefaultCacheControl default Cache-Control header of images,
+	// clients revalidate them with ETag before each use
+	DefaultCacheControl = "no-cache"
+
+	// DefaultChunkSize default size in bytes of the chunks
+	// of images larger than it, 4MB
+	DefaultChunkSize = 4194304
+
+	// DefaultMaxDerivedCacheSize default size in bytes of the cache
+	// of images transformed when they are read, 32MB
+	DefaultMaxDerivedCacheSize = 33554432
+
+	// DefaultAllowedMediaTypes default media types of data that can be
+	// stored, empty allows any. Operators restrict them, SVG can hold scripts
+	DefaultAllowedMediaTypes = ""
+
+	// DefaultCompression default codec data is compressed with,
+	// none, snappy, lz4 or zstd
+	DefaultCompression = "snappy"
+
+	// DefaultExpirySweepInterval default time in seconds between
+	// removals of expired images, 1 hour. 0 turns sweep off
+	DefaultExpirySweepInterval = 3600
 )
 
 // SparrowConfig holds general configuration of SparrowDB
 type SparrowConfig struct {
-	NodeName             string  `xml:"node_name"`
-	HTTPPort             string  `xml:"http_port"`
-	HTTPHost             string  `xml:"http_host"`
-	AdminPort            string  `xml:"admin_port"`
-	AdminHost            string  `xml:"admin_host"`
-	ReadOnly             bool    `xml:"read_only"`
-	MaxDataLogSize       uint64  `xml:"max_datalog_size"`
-	MaxCacheSize         uint64  `xml:"max_cache_size"`
-	BloomFilterFp        float32 `xml:"bloomfilter_fpp"`
-	CronExp              string  `xml:"dataholder_cron_compaction"`
-	Path                 string  `xml:"data_file_directory"`
-	SnapshotPath         string  `xml:"snapshot_path"`
-	TokenActive          bool    `xml:"generate_token"`
-	AuthenticationActive bool    `xml:"enable_authentication"`
-	UserExpire           int     `xml:"user_expire"`
-	EnableWebUI          bool    `xml:"enable_webui"`
+	NodeName              string  `xml:"node_name"`
+	HTTPPort              string  `xml:"http_port"`
+	HTTPHost              string  `xml:"http_host"`
+	AdminPort             string  `xml:"admin_port"`
+	AdminHost             string  `xml:"admin_host"`
+	ReadOnly              bool    `xml:"read_only"`
+	MaxDataLogSize        uint64  `xml:"max_datalog_size"`
+	MaxCacheSize          uint64  `xml:"max_cache_size"`
+	BloomFilterFp         float32 `xml:"bloomfilter_fpp"`
+	CronExp               string  `xml:"dataholder_cron_compaction"`
+	Path                  string  `xml:"data_file_directory"`
+	SnapshotPath          string  `xml:"snapshot_path"`
+	TokenActive           bool    `xml:"generate_token"`
+	AuthenticationActive  bool    `xml:"enable_authentication"`
+	UserExpire            int     `xml:"user_expire"`
+	EnableWebUI           bool    `xml:"enable_webui"`
+	CommitlogSync         string  `xml:"commitlog_sync"`
+	CommitlogSyncInterval int     `xml:"commitlog_sync_interval"`
+	CompactionTiers       string  `xml:"compaction_tiers"`
+	CompactionMinHolders  int     `xml:"compaction_min_holders"`
+	TombstoneGracePeriod  int     `xml:"tombstone_grace_period"`
+	MaxRevisions          int     `xml:"max_revisions"`
+	CacheControl          string  `xml:"cache_control"`
+	ChunkSize             uint32  `xml:"chunk_size"`
+	MaxDerivedCacheSize   uint64  `xml:"max_derived_cache_size"`
+	AllowedMediaTypes     string  `xml:"allowed_media_types"`
+	Compression           string  `xml:"compression"`
+	ExpirySweepInterval   int     `xml:"expiry_sweep_interval"`
+
+	// images transformed at a time when they are read, 0 is one per CPU
+	MaxConcurrentTransforms int `xml:"max_concurrent_transforms"`
 }
 
 // NewSparrowConfig return configuration from file
@@ -50,11 +113,52 @@ func NewSparrowConfig(filePath string) *SparrowConfig {
 		slog.Fatalf(err.Error())
 	}
 
-	cfg := SparrowConfig{}
+	// 0 is valid for them, they keep default only if file does not hold them
+	cfg := SparrowConfig{
+		TombstoneGracePeriod: DefaultTombstoneGracePeriod,
+		ExpirySweepInterval:  DefaultExpirySweepInterval,
+	}
 
 	if err := xml.Unmarshal(data, &cfg); err != nil {
 		slog.Fatalf(errors.ErrParseFile.Error(), filePath)
 	}
 
+	if len(strings.TrimSpace(cfg.CommitlogSync)) == 0 {
+		cfg.CommitlogSync = DefaultCommitlogSync
+	}
+	if !validSyncPolicy(cfg.CommitlogSync) {
+		slog.Fatalf(errors.ErrSyncPolicy.Error(), cfg.CommitlogSync)
+	}
+	if cfg.CommitlogSyncInterval <= 0 {
+		cfg.CommitlogSyncInterval = DefaultCommitlogSyncInterval
+	}
+	if len(strings.TrimSpace(cfg.CompactionTiers)) == 0 {
+		cfg.CompactionTiers = DefaultCompactionTiers
+	}
+	if cfg.CompactionMinHolders <= 0 {
+		cfg.CompactionMinHolders = DefaultCompactionMinHolders
+	}
+	if cfg.TombstoneGracePeriod < 0 {
+		cfg.TombstoneGracePeriod = DefaultTombstoneGracePeriod
+	}
+	if cfg.MaxRevisions <= 0 {
+		cfg.MaxRevisions = DefaultMaxRevisions
+	}
+	if len(strings.TrimSpace(cfg.CacheControl)) == 0 {
+		cfg.CacheControl = DefaultCacheControl
+	}
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.MaxDerivedCacheSize == 0 {
+		cfg.MaxDerivedCacheSize = DefaultMaxDerivedCacheSize
+	}
+	if len(strings.TrimSpace(cfg.Compression)) == 0 {
+		cfg.Compression = DefaultCompression
+	}
+	if cfg.ExpirySweepInterval < 0 {
+		cfg.ExpirySweepInterval = DefaultExpirySweepInterval
+	}
+
 	return &cfg
 }
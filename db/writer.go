@@ -1,63 +1,114 @@
 package db
 
 import (
+	"hash/crc32"
 	"io"
 
+	"github.com/SparrowDb/sparrowdb/engine"
 	"github.com/SparrowDb/sparrowdb/util"
 )
 
-type dbWriter struct {
-	writer io.Writer
+const (
+	// recordSizeMark size in bytes of the record size mark
+	recordSizeMark = 4
+
+	// recordChecksumMark size in bytes of the record checksum
+	recordChecksumMark = 4
+
+	// recordChecksumFlag is set in the size mark of records followed
+	// by a CRC32C checksum. Records written before checksums existed
+	// do not have it and are read without verification
+	recordChecksumFlag = 1 << 31
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func recordChecksum(b []byte) uint32 {
+	return crc32.Checksum(b, crcTable)
 }
 
-func (w *dbWriter) Append(key string, value []byte) error {
+// writeRecord writes value framed by its size and checksum
+func writeRecord(w io.Writer, value []byte) error {
 	bout := util.NewByteStream()
-	bout.PutUInt32(uint32(len(value)))
+	bout.PutUInt32(uint32(len(value)) | recordChecksumFlag)
+	bout.PutUInt32(recordChecksum(value))
 	b := bout.Bytes()
 
-	if _, err := w.writer.Write(b); err != nil {
+	if _, err := w.Write(b); err != nil {
 		return err
 	}
 
-	if _, err := w.writer.Write(value); err != nil {
+	if _, err := w.Write(value); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+type dbWriter struct {
+	writer engine.Writer
+}
+
+func (w *dbWriter) Append(key string, value []byte) error {
+	return writeRecord(w.writer, value)
+}
+
+func (w *dbWriter) Sync() error {
+	return w.writer.Sync()
+}
+
 func (w *dbWriter) Close() error {
-	return w.writer.(io.WriteCloser).Close()
+	return w.writer.Close()
 }
 
-func newWriter(f io.Writer) *dbWriter {
+func newWriter(f engine.Writer) *dbWriter {
 	return &dbWriter{f}
 }
 
 type bufWriter struct {
-	writer io.Writer
+	writer engine.Writer
 }
 
 func (bw *bufWriter) Append(value []byte) error {
-	bout := util.NewByteStream()
-	bout.PutUInt32(uint32(len(value)))
-	b := bout.Bytes()
+	return writeRecord(bw.writer, value)
+}
 
-	if _, err := bw.writer.Write(b); err != nil {
+func (bw *bufWriter) Sync() error {
+	return bw.writer.Sync()
+}
+
+func (bw *bufWriter) Close() error {
+	return bw.writer.Close()
+}
+
+func newBufWriter(f engine.Writer) *bufWriter {
+	return &bufWriter{f}
+}
+
+// replaceFile writes file through a temporary file that is renamed
+// over desc, a crash while writing never leaves desc half written
+func replaceFile(sto engine.Storage, desc engine.FileDesc, write func(w engine.Writer) error) error {
+	tmp := engine.FileDesc{Type: desc.Type, Temp: true}
+	sto.Remove(tmp)
+
+	fwriter, err := sto.Create(tmp)
+	if err != nil {
 		return err
 	}
 
-	if _, err := bw.writer.Write(value); err != nil {
+	if err := write(fwriter); err != nil {
+		fwriter.Close()
 		return err
 	}
 
-	return nil
-}
+	if err := fwriter.Sync(); err != nil {
+		fwriter.Close()
+		return err
+	}
 
-func (bw *bufWriter) Close() error {
-	return bw.writer.(io.WriteCloser).Close()
-}
+	if err := fwriter.Close(); err != nil {
+		return err
+	}
 
-func newBufWriter(f io.Writer) *bufWriter {
-	return &bufWriter{f}
+	return sto.Rename(tmp, desc)
 }
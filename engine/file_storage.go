@@ -105,10 +105,12 @@ func (fs *fileStorage) Rename(ofd, nfd FileDesc) error {
 	return nil
 }
 
-func (fs *fileStorage) Truncate(pos int64) error {
+func (fs *fileStorage) Truncate(fd FileDesc, pos int64) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	return os.Truncate(fs.path, pos)
+
+	fpath := filepath.Join(fs.path, fd.Name())
+	return os.Truncate(fpath, pos)
 }
 
 func (fs *fileStorage) Close() error {
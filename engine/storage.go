@@ -20,15 +20,38 @@ const (
 
 	// FileCommitlog represents commitlog file type
 	FileCommitlog
+
+	// FileSortedIndex represents sorted index file type
+	FileSortedIndex
+
+	// FileAttributeIndex represents attribute index file type
+	FileAttributeIndex
+
+	// FileHistoryIndex represents history index file type
+	FileHistoryIndex
+
+	// FileBlobIndex represents blob reference index file type
+	FileBlobIndex
 )
 
 // FileDesc is the file descriptor
 type FileDesc struct {
 	Type FileType
+
+	// Temp is set for the temporary file used to
+	// replace the file of the same type
+	Temp bool
 }
 
 // Name returns the name of the file based on type
 func (fd *FileDesc) Name() string {
+	if fd.Temp {
+		return fd.baseName() + ".tmp"
+	}
+	return fd.baseName()
+}
+
+func (fd *FileDesc) baseName() string {
 	switch fd.Type {
 	case FileData:
 		return fmt.Sprintf("data.spw")
@@ -38,6 +61,14 @@ func (fd *FileDesc) Name() string {
 		return fmt.Sprintf("bloom.spw")
 	case FileCommitlog:
 		return fmt.Sprintf("commitlog.spw")
+	case FileSortedIndex:
+		return fmt.Sprintf("sindex.spw")
+	case FileAttributeIndex:
+		return fmt.Sprintf("aindex.spw")
+	case FileHistoryIndex:
+		return fmt.Sprintf("hindex.spw")
+	case FileBlobIndex:
+		return fmt.Sprintf("bindex.spw")
 	default:
 		return ""
 	}
@@ -53,6 +84,9 @@ type Reader interface {
 // Writer interface to file writer
 type Writer interface {
 	io.WriteCloser
+
+	// Sync commits the file content to stable storage
+	Sync() error
 }
 
 // Storage interface to manage the storage system
@@ -69,7 +103,7 @@ type Storage interface {
 
 	Rename(ofd, nfd FileDesc) error
 
-	Truncate(pos int64) error
+	Truncate(fd FileDesc, pos int64) error
 
 	Close() error
 }
@@ -52,6 +52,9 @@ var (
 	// ErrKeyExists error message when insert image and key exists
 	ErrKeyExists = errors.New("Could not insert image (%s), key already exits")
 
+	// ErrKeyNotFound error message when key is not stored in database
+	ErrKeyNotFound = errors.New("Key %s not found in %s")
+
 	// ErrImageInvalidKey error message when key is invalid (contains special chars)
 	ErrImageInvalidKey = errors.New("Invalid key")
 
@@ -73,12 +76,84 @@ var (
 	// ErrFileCorrupted error message when file is corrupted
 	ErrFileCorrupted = errors.New("Could not read data from %s. File Corrupted")
 
+	// ErrChecksum error message when record content does not match its checksum
+	ErrChecksum = errors.New("Record checksum mismatch")
+
+	// ErrCorruptedRecord error message when record could not be decoded
+	ErrCorruptedRecord = errors.New("Could not decode record")
+
+	// ErrInvalidIndex error message when index file can not be loaded
+	ErrInvalidIndex = errors.New("Invalid index file")
+
+	// ErrTornWrite error message when data file ends with an incomplete record
+	ErrTornWrite = errors.New("Incomplete record in %s at offset %d, truncating %d bytes")
+
+	// ErrSkippedRecord error message when a record in the middle of data file can not be read
+	ErrSkippedRecord = errors.New("Unreadable record in %s at offset %d, skipping %d bytes")
+
+	// ErrSyncPolicy error message when commitlog sync policy is not known
+	ErrSyncPolicy = errors.New("Invalid commitlog sync policy %q, it must be always, batch or none")
+
+	// ErrChunkFile error message when data holder of chunks of a record is not found
+	ErrChunkFile = errors.New("Data holder %d of chunks not found")
+
+	// ErrCompactionTarget error message when merged data holder path already exists
+	ErrCompactionTarget = errors.New("Compaction target %s already exists")
+
+	// ErrCompactionRunning error message when compaction is started while one is running
+	ErrCompactionRunning = errors.New("Compaction of %s is already running")
+
+	// ErrInvalidCursor error message when key listing cursor can not be decoded
+	ErrInvalidCursor = errors.New("Invalid cursor")
+
+	// ErrInvalidAttribute error message when attribute name or value has invalid size
+	ErrInvalidAttribute = errors.New("Invalid attribute %s")
+
+	// ErrTooManyAttributes error message when data has more attributes than allowed
+	ErrTooManyAttributes = errors.New("Too many attributes, max is %d")
+
+	// ErrAttributeNotIndexed error message when querying attribute without secondary index
+	ErrAttributeNotIndexed = errors.New("Attribute %s is not indexed")
+
+	// ErrDataTooLarge error message when image is larger than max size
+	ErrDataTooLarge = errors.New("Image is larger than %d bytes")
+
+	// ErrScriptOrder error message when script field is sent after an image written by chunks
+	ErrScriptOrder = errors.New("Script must be sent before uploadfile for images larger than %d bytes")
+
+	// ErrMediaTypeNotAllowed error message when media type of data is not allowed by database
+	ErrMediaTypeNotAllowed = errors.New("Media type %s is not allowed")
+
+	// ErrReservedKey error message when key is in the space of keys used by database
+	ErrReservedKey = errors.New("Key %q is reserved")
+
+	// ErrUnknownCodec error message when compression codec is not known
+	ErrUnknownCodec = errors.New("Unknown compression codec %v")
+
+	// ErrTransactionAborted error message when an operation of a transaction fails
+	ErrTransactionAborted = errors.New("Transaction aborted, no operation was written")
+
+	// ErrSeek error message when stream is moved to invalid position
+	ErrSeek = errors.New("Invalid seek position")
+
 	// ErrLogin error message when username and/or password is wrong
 	ErrLogin = errors.New("Wrong username and/or password")
 
 	// ErrInvalidToken error message when username inputs invalid or expired token
 	ErrInvalidToken = errors.New("Invalid or expired token")
 
+	// ErrInvalidSignature error message when signed URL is not valid or expired
+	ErrInvalidSignature = errors.New("Invalid or expired URL signature")
+
+	// ErrSigningDisabled error message when URL is signed and no signing key is active
+	ErrSigningDisabled = errors.New("URL signing keys are not configured")
+
+	// ErrInvalidSigningKey error message when signing key file has an invalid key
+	ErrInvalidSigningKey = errors.New("Invalid signing key %q, keys need an id, 32 bytes or more and one of them must be active")
+
+	// ErrTransformTooLarge error message when image is too large to be transformed when it is read
+	ErrTransformTooLarge = errors.New("Image is too large to be transformed")
+
 	// ErrNotSupportedFileType error message when file type not supported by script interpreter
 	ErrNotSupportedFileType = errors.New("File type not supported by script interpreter")
 
@@ -0,0 +1,414 @@
+package http
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/SparrowDb/sparrowdb/auth"
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/script"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxBatchItems max number of images or keys in one batch
+	maxBatchItems = 1000
+
+	// maxBatchSize max size in bytes of images of one batch,
+	// they are held in memory until the batch is written
+	maxBatchSize = 268435456
+
+	// paxPrefix prefix of PAX records of tar entries
+	// that hold key and options of an image
+	paxPrefix = "SPARROW."
+)
+
+// batchItem holds an image of a batch upload and its options
+type batchItem struct {
+	key      string
+	filename string
+	data     []byte
+	upsert   string
+	script   string
+	ttl      string
+	attrs    map[string]string
+	err      error
+}
+
+// batchResult holds result of an item of a batch
+type batchResult struct {
+	Key      string                      `json:"key"`
+	Revision uint32                      `json:"revision,omitempty"`
+	Data     *model.DataDefinitionResult `json:"data,omitempty"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+// batchReader reads images of a batch up to the sizes sto allows
+type batchReader struct {
+	sto   *db.Database
+	items []*batchItem
+	size  int64
+}
+
+// add reads image of item from r, an image larger than database
+// chunk size is not read and its item gets an error
+func (br *batchReader) add(item *batchItem, r io.Reader) error {
+	if len(br.items) == maxBatchItems {
+		return fmt.Errorf(errors.ErrParse.Error(), "batch, too many items")
+	}
+	br.items = append(br.items, item)
+
+	limit := int64(br.sto.Descriptor.ChunkSize)
+	buf := new(bytes.Buffer)
+	n, err := io.CopyN(buf, r, limit+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if n > limit {
+		item.err = fmt.Errorf(errors.ErrDataTooLarge.Error(), limit)
+		return nil
+	}
+
+	if br.size += n; br.size > maxBatchSize {
+		return fmt.Errorf(errors.ErrDataTooLarge.Error(), maxBatchSize)
+	}
+	item.data = buf.Bytes()
+	return nil
+}
+
+// readMultipart reads images sent as uploadfile[key] fields, options of each
+// one are sent as upsert[key], script[key], ttl[key] and attr[key][name]
+func (br *batchReader) readMultipart(c *gin.Context) error {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &uploadForm{values: url.Values{}}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+		if strings.HasPrefix(name, "uploadfile[") && strings.HasSuffix(name, "]") {
+			err = br.add(&batchItem{
+				key:      name[len("uploadfile[") : len(name)-1],
+				filename: part.FileName(),
+			}, part)
+		} else {
+			err = form.readValue(part)
+		}
+		part.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range br.items {
+		item.upsert = form.values.Get("upsert[" + item.key + "]")
+		item.script = form.values.Get("script[" + item.key + "]")
+		item.ttl = form.values.Get("ttl[" + item.key + "]")
+		item.attrs = formMap(form.values, "attr["+item.key+"]")
+	}
+	return nil
+}
+
+// readTar reads images of tar entries, key of each one is its file name
+// without extension. PAX records SPARROW.key, SPARROW.upsert, SPARROW.script,
+// SPARROW.ttl and SPARROW.attr.name set key and options of it
+func (br *batchReader) readTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		filename := path.Base(hdr.Name)
+		item := &batchItem{
+			key:      strings.TrimSuffix(filename, path.Ext(filename)),
+			filename: filename,
+			attrs:    make(map[string]string),
+		}
+
+		for k, v := range hdr.PAXRecords {
+			if !strings.HasPrefix(k, paxPrefix) {
+				continue
+			}
+
+			switch name := strings.TrimPrefix(k, paxPrefix); {
+			case name == "key":
+				item.key = v
+			case name == "upsert":
+				item.upsert = v
+			case name == "script":
+				item.script = v
+			case name == "ttl":
+				item.ttl = v
+			case strings.HasPrefix(name, "attr."):
+				item.attrs[strings.TrimPrefix(name, "attr.")] = v
+			}
+		}
+
+		if err := br.add(item, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// newData returns DataDefinition of item, options not set in item
+// are taken from query. Script of item is executed over its image
+func (br *batchReader) newData(item *batchItem, query url.Values) (*model.DataDefinition, error) {
+	if item.err != nil {
+		return nil, item.err
+	}
+
+	if !validKey(item.key) {
+		return nil, errors.ErrImageInvalidKey
+	}
+
+	if len(item.script) == 0 {
+		item.script = query.Get("script")
+	}
+	if len(item.ttl) == 0 {
+		item.ttl = query.Get("ttl")
+	}
+
+	expires, err := expiresAt(br.sto, item.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := model.ValidateAttributes(item.attrs); err != nil {
+		return nil, err
+	}
+
+	b := item.data
+	ext := strings.TrimPrefix(filepath.Ext(item.filename), ".")
+	mediaType := script.DetectMediaType(b)
+	if err := checkMediaType(br.sto, mediaType); err != nil {
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(item.script)) > 0 {
+		if b, ext, err = script.Execute(item.script, item.key, b); err != nil {
+			return nil, err
+		}
+
+		mediaType = script.DetectMediaType(b)
+		if err := checkMediaType(br.sto, mediaType); err != nil {
+			return nil, err
+		}
+	}
+
+	df := newUploadData(item.key, ext, mediaType, item.attrs, b)
+	df.Expires = expires
+
+	// dimensions, EXIF and hash of image are stored with it
+	df.Buf = (&uploadForm{}).inspectImage(br.sto, df, b)
+	df.Size = uint32(len(df.Buf))
+	return df, nil
+}
+
+// batchDatabase returns database of batch request, it writes
+// error response if user can not write to it
+func (sh *ServeHandler) batchDatabase(c *gin.Context, resp *Response) (*db.Database, bool) {
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleImageManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return nil, false
+		}
+	}
+
+	sto, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return nil, false
+	}
+	return sto, true
+}
+
+// applyBatch writes ops of items to sto, items without op failed before.
+// If atomic is set ops are written in one transaction, none is written
+// if an item failed. Returns result of each item and error of transaction
+func applyBatch(sto *db.Database, keys []string, ops []*db.BatchOp, errs []error, atomic bool) ([]batchResult, error) {
+	batch := make([]db.BatchOp, 0, len(ops))
+	for _, op := range ops {
+		if op != nil {
+			batch = append(batch, *op)
+		}
+	}
+
+	var applied []db.BatchResult
+	var txnErr error
+	switch {
+	case !atomic:
+		applied = sto.Batch(batch)
+	case len(batch) < len(ops):
+		applied = make([]db.BatchResult, len(batch))
+		for i := range applied {
+			applied[i].Err = errors.ErrTransactionAborted
+		}
+		txnErr = errors.ErrTransactionAborted
+	default:
+		txn := sto.Begin()
+		for _, op := range batch {
+			txn.Add(op)
+		}
+		applied, txnErr = txn.Commit()
+	}
+
+	results := make([]batchResult, len(keys))
+	for i, op := range ops {
+		results[i].Key = keys[i]
+
+		err := errs[i]
+		if op != nil {
+			r := applied[0]
+			applied = applied[1:]
+			results[i].Revision, err = r.Revision, r.Err
+			if err == nil && op.Data != nil {
+				results[i].Data = op.Data.QueryResult()
+			}
+		}
+		if err != nil {
+			results[i].Revision = 0
+			results[i].Error = err.Error()
+		}
+	}
+	return results, txnErr
+}
+
+// batchResponse writes results of batch, status is 409 if
+// transaction was aborted and 500 if it could not be written
+func batchResponse(c *gin.Context, resp *Response, results []batchResult, err error) {
+	resp.AddContent("results", results)
+
+	status := http.StatusOK
+	if err != nil {
+		resp.AddError(err)
+		status = http.StatusInternalServerError
+		if err == errors.ErrTransactionAborted {
+			status = http.StatusConflict
+		}
+	}
+	c.JSON(status, resp)
+}
+
+// uploadBatch stores images of a multipart or tar request in one batch.
+// upsert, script and ttl query parameters apply to images that do not set them,
+// atomic query parameter writes all images or none
+func (sh *ServeHandler) uploadBatch(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	sto, ok := sh.batchDatabase(c, resp)
+	if !ok {
+		return
+	}
+
+	br := &batchReader{sto: sto}
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+
+	var err error
+	switch mediaType {
+	case "multipart/form-data":
+		err = br.readMultipart(c)
+	case "application/x-tar":
+		err = br.readTar(c.Request.Body)
+	default:
+		err = errors.ErrWrongRequest
+	}
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	query := c.Request.URL.Query()
+	keys := make([]string, len(br.items))
+	ops := make([]*db.BatchOp, len(br.items))
+	errs := make([]error, len(br.items))
+	for i, item := range br.items {
+		keys[i] = item.key
+
+		df, err := br.newData(item, query)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		upsert := item.upsert
+		if len(upsert) == 0 {
+			upsert = query.Get("upsert")
+		}
+		ops[i] = &db.BatchOp{Data: df, Upsert: upsert == "true"}
+	}
+
+	results, err := applyBatch(sto, keys, ops, errs, c.Query("atomic") == "true")
+	batchResponse(c, resp, results, err)
+}
+
+// deleteBatch removes keys of JSON request {"keys": [...]} in one batch,
+// atomic query parameter removes all keys or none
+func (sh *ServeHandler) deleteBatch(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	sto, ok := sh.batchDatabase(c, resp)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	if len(req.Keys) > maxBatchItems {
+		resp.AddError(fmt.Errorf(errors.ErrParse.Error(), "batch, too many items"))
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ops := make([]*db.BatchOp, len(req.Keys))
+	errs := make([]error, len(req.Keys))
+	for i, key := range req.Keys {
+		if !validKey(key) {
+			errs[i] = errors.ErrImageInvalidKey
+			continue
+		}
+		ops[i] = &db.BatchOp{Key: key}
+	}
+
+	results, err := applyBatch(sto, req.Keys, ops, errs, c.Query("atomic") == "true")
+	batchResponse(c, resp, results, err)
+}
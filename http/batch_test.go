@@ -0,0 +1,318 @@
+package http
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/auth"
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+// newTestServer returns server of API without authentication
+// whose database "images" stores chunks of 1024 bytes
+func newTestServer(t *testing.T, dir string) (*httptest.Server, *db.DBManager) {
+	cfg := db.NewSparrowConfig("../config/")
+	cfg.Path = filepath.Join(dir, "data")
+	cfg.SnapshotPath = filepath.Join(dir, "snapshot")
+	cfg.AuthenticationActive = false
+	cfg.ReadOnly = false
+
+	dbm := db.NewDBManager(cfg, db.NewDatabaseConfig(dir))
+	srv := httptest.NewServer(NewRouter(cfg, dbm, auth.NewKeyring()))
+
+	res, _ := doRequest(t, "PUT", srv.URL+"/api/images", "application/json", strings.NewReader(`{"chunk_size": 1024}`), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected database created, got %d", res.StatusCode)
+	}
+	return srv, dbm
+}
+
+// doRequest sends request and returns its response and body
+func doRequest(t *testing.T, method, url, contentType string, body *strings.Reader, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, b
+}
+
+// sendBatch sends batch request and returns its status and results
+func sendBatch(t *testing.T, method, url, contentType string, body []byte) (int, []batchResult) {
+	res, b := doRequest(t, method, url, contentType, strings.NewReader(string(body)), nil)
+
+	var resp struct {
+		Content struct {
+			Results []batchResult `json:"results"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, resp.Content.Results
+}
+
+func testImage(t *testing.T) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// tarEntry is a file of a tar batch and its PAX records
+type tarEntry struct {
+	name string
+	data []byte
+	pax  map[string]string
+}
+
+func testTar(t *testing.T, entries []tarEntry) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), PAXRecords: e.pax}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func Test_UploadBatchMultipart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	invalid := strings.Repeat("k", 151)
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, f := range []struct {
+		key  string
+		data []byte
+	}{
+		{"a-b", testImage(t)},
+		{"large", make([]byte, 2048)},
+		{invalid, testImage(t)},
+	} {
+		w, err := mw.CreateFormFile("uploadfile["+f.key+"]", f.key+".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	mw.WriteField("attr[a-b][color]", "red")
+	mw.Close()
+
+	status, results := sendBatch(t, "PUT", srv.URL+"/api/images/_batch", mw.FormDataContentType(), b.Bytes())
+	if status != http.StatusOK || len(results) != 3 {
+		t.Fatalf("expected result of each item, got %d %v", status, results)
+	}
+
+	// results are in order of items, failed ones do not stop the others
+	if results[0].Key != "a-b" || results[0].Revision != 1 || len(results[0].Error) > 0 {
+		t.Fatalf("expected a-b stored, got %+v", results[0])
+	}
+	if results[1].Key != "large" || results[1].Error != fmt.Sprintf(errors.ErrDataTooLarge.Error(), 1024) {
+		t.Fatalf("expected image larger than chunk size rejected, got %+v", results[1])
+	}
+	if results[2].Key != invalid || results[2].Error != errors.ErrImageInvalidKey.Error() {
+		t.Fatalf("expected invalid key rejected, got %+v", results[2])
+	}
+
+	sto, _ := dbm.GetDatabase("images")
+	df, ok := sto.GetDataByKey("a-b")
+	if !ok || df.Attributes["color"] != "red" {
+		t.Fatalf("expected a-b stored with its attributes, got %v", df)
+	}
+	if _, ok := sto.GetDataByKey("large"); ok {
+		t.Fatal("expected large not stored")
+	}
+
+	// single keys are validated like keys of a batch
+	res, _ := doRequest(t, "DELETE", srv.URL+"/api/images/a-b", "", strings.NewReader(""), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected a-b removed, got %d", res.StatusCode)
+	}
+}
+
+func Test_UploadBatchTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	body := testTar(t, []tarEntry{
+		{name: "photos/x.png", data: testImage(t), pax: map[string]string{
+			paxPrefix + "key":        "y",
+			paxPrefix + "attr.color": "blue",
+		}},
+		{name: "z.png", data: testImage(t)},
+	})
+
+	status, results := sendBatch(t, "PUT", srv.URL+"/api/images/_batch", "application/x-tar", body)
+	if status != http.StatusOK || len(results) != 2 {
+		t.Fatalf("expected result of each item, got %d %v", status, results)
+	}
+	if results[0].Key != "y" || results[0].Revision != 1 || results[1].Key != "z" || results[1].Revision != 1 {
+		t.Fatalf("expected y and z stored, got %+v", results)
+	}
+
+	sto, _ := dbm.GetDatabase("images")
+	if df, ok := sto.GetDataByKey("y"); !ok || df.Attributes["color"] != "blue" {
+		t.Fatalf("expected y stored with its attributes, got %v", df)
+	}
+
+	// too many items, nothing is stored
+	entries := make([]tarEntry, maxBatchItems+1)
+	for i := range entries {
+		entries[i] = tarEntry{name: fmt.Sprintf("k%d.png", i)}
+	}
+	res, _ := doRequest(t, "PUT", srv.URL+"/api/images/_batch", "application/x-tar", strings.NewReader(string(testTar(t, entries))), nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected batch of too many items rejected, got %d", res.StatusCode)
+	}
+	if _, ok := sto.GetDataByKey("k0"); ok {
+		t.Fatal("expected no item of rejected batch stored")
+	}
+}
+
+func Test_UploadBatchAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	sto, _ := dbm.GetDatabase("images")
+	body := testTar(t, []tarEntry{
+		{name: "d.png", data: testImage(t)},
+		{name: "d.png", data: testImage(t)},
+	})
+
+	// second insert of a key fails, so does the transaction
+	status, results := sendBatch(t, "PUT", srv.URL+"/api/images/_batch?atomic=true", "application/x-tar", body)
+	if status != http.StatusConflict || len(results) != 2 {
+		t.Fatalf("expected transaction aborted, got %d %v", status, results)
+	}
+	if results[0].Error != errors.ErrTransactionAborted.Error() || results[1].Error != fmt.Sprintf(errors.ErrKeyExists.Error(), "d") {
+		t.Fatalf("expected duplicate key failed, got %+v", results)
+	}
+	if _, ok := sto.GetDataByKey("d"); ok {
+		t.Fatal("expected d not stored")
+	}
+
+	// upsert of duplicate keys writes both revisions
+	status, results = sendBatch(t, "PUT", srv.URL+"/api/images/_batch?atomic=true&upsert=true", "application/x-tar", body)
+	if status != http.StatusOK || results[0].Revision != 1 || results[1].Revision != 2 {
+		t.Fatalf("expected revisions 1 and 2, got %d %+v", status, results)
+	}
+	if df, ok := sto.GetDataByKey("d"); !ok || df.Revision != 2 {
+		t.Fatalf("expected revision 2 of d stored, got %v", df)
+	}
+
+	// an item failed before the transaction aborts it
+	body = testTar(t, []tarEntry{
+		{name: "e.png", data: testImage(t)},
+		{name: "large.png", data: make([]byte, 2048)},
+	})
+	status, results = sendBatch(t, "PUT", srv.URL+"/api/images/_batch?atomic=true", "application/x-tar", body)
+	if status != http.StatusConflict || results[0].Error != errors.ErrTransactionAborted.Error() || results[0].Revision != 0 {
+		t.Fatalf("expected transaction aborted, got %d %+v", status, results)
+	}
+	if _, ok := sto.GetDataByKey("e"); ok {
+		t.Fatal("expected e not stored")
+	}
+}
+
+func Test_DeleteBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	body := testTar(t, []tarEntry{{name: "a.png", data: testImage(t)}})
+	if status, _ := sendBatch(t, "PUT", srv.URL+"/api/images/_batch", "application/x-tar", body); status != http.StatusOK {
+		t.Fatalf("expected a stored, got %d", status)
+	}
+
+	status, results := sendBatch(t, "DELETE", srv.URL+"/api/images/_batch", "application/json", []byte(`{"keys": ["a", "missing", "a\u0000b"]}`))
+	if status != http.StatusOK || len(results) != 3 {
+		t.Fatalf("expected result of each key, got %d %v", status, results)
+	}
+	if results[0].Key != "a" || results[0].Revision != 2 || len(results[0].Error) > 0 {
+		t.Fatalf("expected a removed, got %+v", results[0])
+	}
+	if results[1].Error != fmt.Sprintf(errors.ErrKeyNotFound.Error(), "missing", "images") {
+		t.Fatalf("expected missing not found, got %+v", results[1])
+	}
+	if results[2].Error != errors.ErrImageInvalidKey.Error() {
+		t.Fatalf("expected invalid key rejected, got %+v", results[2])
+	}
+
+	keys := make([]string, maxBatchItems+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+	b, _ := json.Marshal(map[string][]string{"keys": keys})
+	res, _ := doRequest(t, "DELETE", srv.URL+"/api/images/_batch", "application/json", strings.NewReader(string(b)), nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected batch of too many keys rejected, got %d", res.StatusCode)
+	}
+}
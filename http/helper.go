@@ -1,8 +1,19 @@
 package http
 
 import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
 	"github.com/SparrowDb/sparrowdb/auth"
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
 	"github.com/gin-gonic/gin"
+	govalidator "gopkg.in/asaskevich/govalidator.v4"
 )
 
 // BasicMiddleware middleware of gin to handler OPTIONS
@@ -11,10 +22,11 @@ func BasicMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Server", "SparrowDb")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if c.Request.Method == "OPTIONS" {
-			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match")
 		}
 
 		c.Next()
@@ -34,6 +46,25 @@ func AuthMiddleware(onerr func(c *gin.Context)) gin.HandlerFunc {
 	}
 }
 
+// validDatabaseName checks name of database data is written to
+func validDatabaseName(name string) bool {
+	return govalidator.IsAlphanumeric(name) && govalidator.IsByteLength(name, 3, 50)
+}
+
+// validKey checks key of data that is written, it can not hold NUL,
+// it separates the parts of history and attribute index keys
+func validKey(key string) bool {
+	return govalidator.IsByteLength(key, 1, 150) && !strings.ContainsRune(key, 0)
+}
+
+// intOrUnset returns value of v, db.Unset if it was not sent
+func intOrUnset(v *int) int {
+	if v == nil {
+		return db.Unset
+	}
+	return *v
+}
+
 func hasPermission(c *gin.Context, role int) bool {
 	_, u, err := auth.ParseClaimFromRequest(c.Request)
 	if err != nil {
@@ -41,3 +72,97 @@ func hasPermission(c *gin.Context, role int) bool {
 	}
 	return auth.CheckUserPermission(u, role)
 }
+
+// writeCondition returns condition of a conditional write, nil if request
+// has none. If-Match header holds ETags of accepted data or "*" for any,
+// revision field of form or query holds accepted revision, 0 if key must
+// not exist
+func writeCondition(c *gin.Context, form url.Values) (string, func(stored *model.DataDefinition) bool, error) {
+	conds := make([]func(stored *model.DataDefinition) bool, 0)
+	expected := make([]string, 0)
+
+	if ifMatch := c.GetHeader("If-Match"); len(ifMatch) > 0 {
+		tags := strings.Split(ifMatch, ",")
+		for i := range tags {
+			tags[i] = strings.TrimSpace(tags[i])
+		}
+
+		expected = append(expected, ifMatch)
+		conds = append(conds, func(stored *model.DataDefinition) bool {
+			if stored == nil {
+				return false
+			}
+			for _, tag := range tags {
+				if tag == "*" || tag == stored.ETag() {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	value, ok := form["revision"]
+	if !ok {
+		value, ok = c.Request.URL.Query()["revision"]
+	}
+	if ok {
+		rev, err := strconv.ParseUint(value[0], 10, 32)
+		if err != nil {
+			return "", nil, fmt.Errorf(errors.ErrParse.Error(), "revision")
+		}
+
+		expected = append(expected, value[0])
+		conds = append(conds, func(stored *model.DataDefinition) bool {
+			if stored == nil {
+				return rev == 0
+			}
+			return stored.Revision == uint32(rev)
+		})
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+
+	return strings.Join(expected, " "), func(stored *model.DataDefinition) bool {
+		for _, cond := range conds {
+			if !cond(stored) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// dataErrorStatus returns status of response to a read of data
+// that failed with err, stored data that can not be decoded is
+// a server error
+func dataErrorStatus(err error) int {
+	if err == errors.ErrCorruptedRecord {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// revisionMismatch writes precondition failed response with current
+// revision of the key if err is from a conditional write
+func revisionMismatch(c *gin.Context, resp *Response, err error) bool {
+	re, ok := err.(*db.RevisionError)
+	if !ok {
+		return false
+	}
+
+	resp.AddError(err)
+	resp.AddContent("revision", re.Current)
+	c.JSON(http.StatusPreconditionFailed, resp)
+	return true
+}
+
+// imageContentType returns Content-Type of an image with extension ext,
+// jpg is served as image/jpeg
+func imageContentType(ext string) string {
+	if t := mime.TypeByExtension("." + strings.ToLower(ext)); strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/" + ext
+}
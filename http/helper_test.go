@@ -0,0 +1,21 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ValidKey(t *testing.T) {
+	for _, key := range []string{"a", "a-b.jpg", "dir/photo 1", strings.Repeat("k", 150)} {
+		if !validKey(key) {
+			t.Fatalf("expected %q valid", key)
+		}
+	}
+
+	// NUL separates key and revision in history index keys
+	for _, key := range []string{"", "a\x00b", strings.Repeat("k", 151)} {
+		if validKey(key) {
+			t.Fatalf("expected %q invalid", key)
+		}
+	}
+}
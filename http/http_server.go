@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/SparrowDb/sparrowdb/auth"
 	"github.com/SparrowDb/sparrowdb/db"
 	"github.com/SparrowDb/sparrowdb/slog"
 	"github.com/gin-gonic/gin"
@@ -15,7 +16,9 @@ type HTTPServer struct {
 	Config    *db.SparrowConfig
 	router    *gin.Engine
 	dbManager *db.DBManager
+	keyring   *auth.Keyring
 	listener  net.Listener
+	log       slog.Logger
 }
 
 // Start starts HTTP server listener
@@ -23,35 +26,53 @@ func (httpServer *HTTPServer) Start() {
 	var err error
 	httpServer.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%s", httpServer.Config.HTTPHost, httpServer.Config.HTTPPort))
 	if err != nil {
-		slog.Fatalf(err.Error())
+		httpServer.log.Fatalf(err.Error())
 	}
 
-	handler := NewServeHandler(httpServer.dbManager)
+	registerRoutes(httpServer.router, httpServer.Config, NewServeHandler(httpServer.dbManager, httpServer.keyring))
 
+	http.Serve(httpServer.listener, httpServer.router)
+}
+
+// NewRouter returns handler of HTTP API of databases of dbm, the
+// same one served by HTTPServer, URLs are signed with keys of keyring
+func NewRouter(config *db.SparrowConfig, dbm *db.DBManager, keyring *auth.Keyring) http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	registerRoutes(router, config, NewServeHandler(dbm, keyring))
+	return router
+}
+
+func registerRoutes(router *gin.Engine, config *db.SparrowConfig, handler *ServeHandler) {
 	// register basic middleware, for cors and server name
-	httpServer.router.Use(BasicMiddleware())
+	router.Use(BasicMiddleware())
 
 	// auth group
-	authorized := httpServer.router.Group("/")
+	authorized := router.Group("/")
 
 	// Checks if auth is active, if true, register auth middleware
-	if httpServer.Config.AuthenticationActive {
+	if config.AuthenticationActive {
 		authorized.Use(AuthMiddleware(func(c *gin.Context) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 		}))
 	}
-	httpServer.router.POST("/user/login", handler.userLogin)
+	router.POST("/user/login", handler.userLogin)
 
 	// register routes based on configuration file permission
-	if !httpServer.Config.ReadOnly {
+	if !config.ReadOnly {
 		// database create/delete
 		authorized.PUT("/api/:dbname", handler.createDatabase)
 		authorized.DELETE("/api/:dbname", handler.dropDatabase)
 
-		// image insert/delete
+		// image insert/delete, if :key is "_compact"
+		// it starts database compaction and if it is
+		// "_batch" it writes or removes several images
 		authorized.PUT("/api/:dbname/:key", handler.uploadData)
 		authorized.DELETE("/api/:dbname/:key", handler.deleteData)
 
+		// image metadata update
+		authorized.PATCH("/api/:dbname/:key", handler.updateMetadata)
+
 		// register script route
 		authorized.POST("/script/:name", saveScript)
 		authorized.DELETE("/script/:name", deleteScript)
@@ -61,34 +82,60 @@ func (httpServer *HTTPServer) Start() {
 	// is a valid database name, it will retrive database information
 	authorized.GET("/api/:dbname", handler.infoDatabase)
 
-	// get image information by database/image_key
+	// get image information by database/image_key, if :key
+	// is "_compact" it retrieves database compaction status
+	// and if it is "_query" it searches images by attribute
 	authorized.GET("/api/:dbname/:key", handler.getDataInfo)
 
+	// list stored revisions of image
+	authorized.GET("/api/:dbname/:key/_history", handler.getHistory)
+
+	// mint signed URL of image, required by databases with signed_urls
+	authorized.GET("/api/:dbname/:key/_sign", handler.signURL)
+
 	// if :name is "_all" it will retrieve all scripts
 	authorized.GET("/script/:name", getScriptList)
 
 	// get image by database/image_key
-	httpServer.router.GET("/g/:dbname/:key", handler.get)
-	httpServer.router.GET("/g/:dbname/:key/:token", handler.get)
+	router.GET("/g/:dbname/:key", handler.get)
+	router.GET("/g/:dbname/:key/:token", handler.get)
 
-	httpServer.router.GET("/ping", handler.ping)
-	httpServer.router.OPTIONS("/*cors", func(c *gin.Context) {})
-
-	http.Serve(httpServer.listener, httpServer.router)
+	router.GET("/ping", handler.ping)
+	router.OPTIONS("/*cors", func(c *gin.Context) {})
 }
 
 // Stop stops HTTP server listener
 func (httpServer *HTTPServer) Stop() {
-	slog.Infof("Stopping HTTP Server")
+	httpServer.log.Infof("Stopping HTTP Server")
 	httpServer.listener.Close()
 }
 
-// NewHTTPServer returns new HTTPServer
-func NewHTTPServer(config *db.SparrowConfig, dbm *db.DBManager) HTTPServer {
+// NewHTTPServer returns new HTTPServer logging with default logger,
+// URLs are signed with keys of keyring
+func NewHTTPServer(config *db.SparrowConfig, dbm *db.DBManager, keyring *auth.Keyring) HTTPServer {
+	return NewHTTPServerWithLogger(config, dbm, keyring, slog.Default())
+}
+
+// NewHTTPServerWithLogger returns new HTTPServer logging with log
+func NewHTTPServerWithLogger(config *db.SparrowConfig, dbm *db.DBManager, keyring *auth.Keyring, log slog.Logger) HTTPServer {
 	gin.SetMode(gin.ReleaseMode)
 	return HTTPServer{
 		Config:    config,
 		dbManager: dbm,
+		keyring:   keyring,
 		router:    gin.New(),
+		log:       log,
 	}
 }
+
+// NewHTTPServerWithOptions returns new HTTPServer of a DBManager whose
+// databases use codecs and logger of opts, the server logs with it too
+func NewHTTPServerWithOptions(config *db.SparrowConfig, dbConfig *db.DatabaseConfig, keyring *auth.Keyring, opts db.Options) HTTPServer {
+	dbm := db.NewDBManagerWithOptions(config, dbConfig, opts)
+	return NewHTTPServerWithLogger(config, dbm, keyring, dbm.Logger())
+}
+
+// DBManager returns DBManager of databases served by httpServer
+func (httpServer *HTTPServer) DBManager() *db.DBManager {
+	return httpServer.dbManager
+}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	govalidator "gopkg.in/asaskevich/govalidator.v4"
 
@@ -16,13 +19,26 @@ import (
 	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/model"
 	"github.com/SparrowDb/sparrowdb/script"
-	"github.com/SparrowDb/sparrowdb/util/uuid"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// defaultKeysLimit number of keys listed when limit is not requested
+	defaultKeysLimit = 1000
+
+	// maxKeysLimit max number of keys listed in one request
+	maxKeysLimit = 10000
+)
+
 // ServeHandler holds main http methods
 type ServeHandler struct {
 	dbManager *db.DBManager
+
+	// signs and verifies URLs of images
+	keyring *auth.Keyring
+
+	// bounds images transformed at a time
+	transforms chan struct{}
 }
 
 func (sh *ServeHandler) ping(c *gin.Context) {
@@ -61,13 +77,30 @@ func (sh *ServeHandler) createDatabase(c *gin.Context) {
 	c.BindJSON(&req)
 
 	databaseCfg := db.DatabaseDescriptor{
-		Name:           resp.Database,
-		MaxDataLogSize: req.MaxDataLogSize,
-		MaxCacheSize:   req.MaxCacheSize,
-		BloomFilterFp:  req.BloomFilterFp,
-		CronExp:        req.CronExp,
-		Path:           req.Path,
-		SnapshotPath:   req.SnapshotPath,
+		Name:                  resp.Database,
+		MaxDataLogSize:        req.MaxDataLogSize,
+		MaxCacheSize:          req.MaxCacheSize,
+		BloomFilterFp:         req.BloomFilterFp,
+		CronExp:               req.CronExp,
+		Path:                  req.Path,
+		SnapshotPath:          req.SnapshotPath,
+		CommitlogSync:         req.CommitlogSync,
+		CommitlogSyncInterval: req.CommitlogSyncInterval,
+		CompactionTiers:       req.CompactionTiers,
+		CompactionMinHolders:  req.CompactionMinHolders,
+		TombstoneGracePeriod:  intOrUnset(req.TombstoneGracePeriod),
+		IndexedAttributes:     req.IndexedAttributes,
+		MaxRevisions:          req.MaxRevisions,
+		CacheControl:          req.CacheControl,
+		ChunkSize:             req.ChunkSize,
+		MaxDerivedCacheSize:   req.MaxDerivedCacheSize,
+		AllowedMediaTypes:     req.AllowedMediaTypes,
+		AutoOrient:            req.AutoOrient,
+		Dedup:                 req.Dedup,
+		Compression:           req.Compression,
+		DefaultTTL:            req.DefaultTTL,
+		ExpirySweepInterval:   intOrUnset(req.ExpirySweepInterval),
+		SignedURLs:            req.SignedURLs,
 	}
 
 	if _, err := govalidator.ValidateStruct(databaseCfg); err != nil {
@@ -125,10 +158,27 @@ func (sh *ServeHandler) getDatabaseInfo(resp *Response) int {
 			"max_cache_size":             db.Descriptor.MaxCacheSize,
 			"bloomfilter_fpp":            db.Descriptor.BloomFilterFp,
 			"dataholder_cron_compaction": db.Descriptor.CronExp,
-			"path":           db.Descriptor.Path,
-			"snapshot_path":  db.Descriptor.SnapshotPath,
-			"generate_token": db.Descriptor.TokenActive,
-			"read_only":      db.Descriptor.ReadOnly,
+			"path":                       db.Descriptor.Path,
+			"snapshot_path":              db.Descriptor.SnapshotPath,
+			"generate_token":             db.Descriptor.TokenActive,
+			"read_only":                  db.Descriptor.ReadOnly,
+			"commitlog_sync":             db.Descriptor.CommitlogSync,
+			"commitlog_sync_interval":    db.Descriptor.CommitlogSyncInterval,
+			"compaction_tiers":           db.Descriptor.CompactionTiers,
+			"compaction_min_holders":     db.Descriptor.CompactionMinHolders,
+			"tombstone_grace_period":     db.Descriptor.TombstoneGracePeriod,
+			"indexed_attributes":         db.Descriptor.IndexedAttributes,
+			"max_revisions":              db.Descriptor.MaxRevisions,
+			"cache_control":              db.Descriptor.CacheControl,
+			"chunk_size":                 db.Descriptor.ChunkSize,
+			"max_derived_cache_size":     db.Descriptor.MaxDerivedCacheSize,
+			"allowed_media_types":        db.Descriptor.AllowedMediaTypes,
+			"auto_orient":                db.Descriptor.AutoOrient,
+			"dedup":                      db.Descriptor.Dedup,
+			"compression":                db.Descriptor.Compression,
+			"default_ttl":                db.Descriptor.DefaultTTL,
+			"expiry_sweep_interval":      db.Descriptor.ExpirySweepInterval,
+			"signed_urls":                db.Descriptor.SignedURLs,
 		})
 		resp.AddContent("statistics", db.Info())
 		return http.StatusOK
@@ -157,7 +207,50 @@ func (sh *ServeHandler) infoDatabase(c *gin.Context) {
 	c.IndentedJSON(200, resp)
 }
 
+func (sh *ServeHandler) compaction(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleDatabaseManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+	}
+
+	db, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	// PUT starts compaction, GET only returns its status
+	if c.Request.Method == http.MethodPut {
+		if err := db.Compact(); err != nil {
+			resp.AddError(err)
+			resp.AddContent("compaction", db.CompactionStatus())
+			c.JSON(http.StatusConflict, resp)
+			return
+		}
+	}
+
+	resp.AddContent("compaction", db.CompactionStatus())
+	c.JSON(http.StatusOK, resp)
+}
+
 func (sh *ServeHandler) uploadData(c *gin.Context) {
+	if c.Param("key") == "_compact" {
+		sh.compaction(c)
+		return
+	}
+
+	if c.Param("key") == "_batch" {
+		sh.uploadBatch(c)
+		return
+	}
+
 	resp := NewResponse()
 	resp.Database = c.Param("dbname")
 
@@ -169,90 +262,276 @@ func (sh *ServeHandler) uploadData(c *gin.Context) {
 		}
 	}
 
-	if r := (govalidator.IsAlphanumeric(resp.Database) && govalidator.IsByteLength(resp.Database, 3, 50)); r == false {
+	if !validDatabaseName(resp.Database) {
 		resp.AddError(errors.ErrInvalidName)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
 	dataKey := c.Param("key")
-	if govalidator.IsByteLength(dataKey, 1, 150) == false {
+	if !validKey(dataKey) {
 		resp.AddError(errors.ErrImageInvalidKey)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
-	file, fhandler, err := c.Request.FormFile("uploadfile")
-	if err != nil {
-		resp.AddError(err)
+	sto, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
-	defer file.Close()
 
-	buf := new(bytes.Buffer)
-	io.Copy(buf, file)
+	// request body is read as it arrives, large images
+	// are written to database before it ends
+	exists := false
+	form, err := readUploadForm(c, sto, dataKey, func(values url.Values) error {
+		var err error
+		exists, err = checkUpload(c, sto, dataKey, values)
+		return err
+	})
+	if err != nil {
+		if revisionMismatch(c, resp, err) {
+			return
+		}
+		status := http.StatusBadRequest
+		if exists {
+			status = http.StatusConflict
+		}
+		resp.AddError(err)
+		c.JSON(status, resp)
+		return
+	}
+	defer form.Close()
 
 	upsert := false
-	if _upsert := c.DefaultPostForm("upsert", "false"); _upsert == "true" {
+	if _upsert := form.values.Get("upsert"); _upsert == "true" {
 		upsert = true
 	}
 
-	sto, ok := sh.dbManager.GetDatabase(resp.Database)
+	// attributes are sent as attr[name]=value form fields
+	attrs := formMap(form.values, "attr")
+	if err := model.ValidateAttributes(attrs); err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
 
-	b := buf.Bytes()
+	expected, match, err := writeCondition(c, form.values)
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
 
-	if !ok {
-		resp.AddError(errors.ErrDatabaseNotFound)
+	expires, err := expiresAt(sto, form.values.Get("ttl"))
+	if err != nil {
+		resp.AddError(err)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
-	// checks if user request needs script execution
-	if scriptName := c.PostForm("script"); len(strings.TrimSpace(scriptName)) > 0 {
-		if b, err = script.Execute(scriptName, dataKey, b); err != nil {
+	b := form.data
+
+	// get file extension and remove dot before ext name
+	ext := strings.TrimPrefix(filepath.Ext(form.filename), ".")
+	mediaType := form.mediaType
+
+	// checks if user request needs script execution, the format
+	// of its output replaces the one of the file
+	if scriptName := form.values.Get("script"); len(strings.TrimSpace(scriptName)) > 0 {
+		if b, ext, err = script.Execute(scriptName, dataKey, b); err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+
+		mediaType = script.DetectMediaType(b)
+		if err := checkMediaType(sto, mediaType); err != nil {
 			resp.AddError(err)
 			c.JSON(http.StatusBadRequest, resp)
 			return
 		}
 	}
 
-	dataToken := uuid.TimeUUID().String()
+	df := newUploadData(dataKey, ext, mediaType, attrs, b)
+	df.Expires = expires
 
-	// create new DataDefinition with requested values
-	df := &model.DataDefinition{
-		Key: dataKey,
+	// dimensions, EXIF and hash of image are stored with it
+	df.Buf = form.inspectImage(sto, df, b)
+	df.Size = uint32(len(df.Buf))
 
-		// default store UUID to keep information of insert time
-		// and eliminates attacks aimed at guessing valid URLs for photos
-		Token: dataToken,
+	if form.chunks != nil {
+		if err := form.chunks.Finish(df); err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+	}
 
-		// get file extension and remove dot before ext name
-		Ext: filepath.Ext(fhandler.Filename)[1:],
+	// try to insert image in database, a conditional
+	// write overrides data that matches its condition
+	if match != nil {
+		_, err = sto.InsertIf(df, expected, match)
+	} else {
+		_, err = sto.InsertCheckUpsert(df, upsert)
+	}
+	if err != nil {
+		if revisionMismatch(c, resp, err) {
+			return
+		}
+		resp.AddError(err)
+		c.JSON(http.StatusConflict, resp)
+		return
+	}
+
+	// write ok response
+	c.Header("ETag", df.ETag())
+	resp.AddContent("data", df.QueryResult())
+	c.JSON(http.StatusOK, resp)
+}
+
+func (sh *ServeHandler) updateMetadata(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleImageManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+	}
+
+	if !validDatabaseName(resp.Database) {
+		resp.AddError(errors.ErrInvalidName)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	dataKey := c.Param("key")
+	if !validKey(dataKey) {
+		resp.AddError(errors.ErrImageInvalidKey)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	db, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	df, stream, found := db.GetDataStream(dataKey)
+	if !found || df.Status != model.DataDefinitionActive {
+		if found {
+			stream.Close()
+		}
+		resp.AddErrorStr(fmt.Sprintf(errors.ErrKeyNotFound.Error(), dataKey, resp.Database))
+		c.JSON(http.StatusNotFound, resp)
+		return
+	}
+	defer stream.Close()
 
-		Size: uint32(len(b)),
+	if df.Corrupted {
+		resp.AddError(errors.ErrCorruptedRecord)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
 
-		// Default status 1 (Active)
-		Status: model.DataDefinitionActive,
+	// attributes sent are set, the ones sent
+	// with empty value are removed
+	if df.Attributes == nil {
+		df.Attributes = make(map[string]string)
+	}
+	for name, value := range c.PostFormMap("attr") {
+		if len(value) == 0 {
+			delete(df.Attributes, name)
+			continue
+		}
+		df.Attributes[name] = value
+	}
 
-		Revision: 0,
+	if err := model.ValidateAttributes(df.Attributes); err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
 
-		Buf: b,
+	// expiry is kept unless ttl is sent
+	if ttl, ok := c.GetPostForm("ttl"); ok {
+		var err error
+		if df.Expires, err = expiresAt(db, ttl); err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
 	}
 
-	// try to insert image in database
-	if _, err := sto.InsertCheckUpsert(df, upsert); err != nil {
+	c.Request.ParseForm()
+	expected, match, err := writeCondition(c, c.Request.PostForm)
+	if err != nil {
 		resp.AddError(err)
-		c.JSON(http.StatusConflict, resp)
+		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
-	// write ok response
+	// chunks are referred to by their offset in the file that holds
+	// them, they are written again with the new revision
+	if df.IsChunked() {
+		chunks := db.NewChunkWriter(dataKey)
+		defer chunks.Close()
+
+		_, err = io.Copy(chunks, stream)
+		if err == nil {
+			err = chunks.Finish(df)
+		}
+		if err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+	}
+
+	// data read above is written back only if it was not changed
+	// meanwhile, besides the condition sent
+	read, changed := df.Revision, false
+	if match == nil {
+		expected = strconv.FormatUint(uint64(read), 10)
+	}
+	_, err = db.InsertIf(df, expected, func(stored *model.DataDefinition) bool {
+		if match != nil && !match(stored) {
+			return false
+		}
+		changed = stored == nil || stored.Revision != read
+		return !changed
+	})
+	if err != nil {
+		if changed {
+			resp.AddError(err)
+			c.JSON(http.StatusConflict, resp)
+			return
+		}
+		if revisionMismatch(c, resp, err) {
+			return
+		}
+		resp.AddError(err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	c.Header("ETag", df.ETag())
 	resp.AddContent("data", df.QueryResult())
 	c.JSON(http.StatusOK, resp)
 }
 
 func (sh *ServeHandler) deleteData(c *gin.Context) {
+	if c.Param("key") == "_batch" {
+		sh.deleteBatch(c)
+		return
+	}
+
 	resp := NewResponse()
 	resp.Database = c.Param("dbname")
 
@@ -272,28 +551,51 @@ func (sh *ServeHandler) deleteData(c *gin.Context) {
 	}
 
 	dataKey := c.Param("key")
-	if r := (govalidator.IsAlphanumeric(dataKey) && govalidator.IsByteLength(dataKey, 1, 50)); r == false {
+	if !validKey(dataKey) {
 		resp.AddError(errors.ErrImageInvalidKey)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
+	expected, match, err := writeCondition(c, nil)
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
 	if db, ok := sh.dbManager.GetDatabase(resp.Database); ok == true {
 		storedDf, found := db.GetDataByKey(dataKey)
 
 		// Check if data is in index
 		if found {
 			// check if data is already marked as tombstone
-			if storedDf.Status == model.DataDefinitionRemoved {
-				resp.AddErrorStr(fmt.Sprintf("Key %s not found in %s", dataKey, resp.Database))
+			if storedDf.Status != model.DataDefinitionActive {
+				resp.AddErrorStr(fmt.Sprintf(errors.ErrKeyNotFound.Error(), dataKey, resp.Database))
 			} else {
 				tbs := model.NewTombstone(storedDf)
-				db.InsertCheckUpsert(tbs, true)
-				resp.AddContent(resp.Database, "ok")
-				status = http.StatusOK
+				if match != nil {
+					// removed data can not be removed again
+					_, err = db.InsertIf(tbs, expected, func(stored *model.DataDefinition) bool {
+						return stored != nil && match(stored)
+					})
+				} else {
+					_, err = db.InsertCheckUpsert(tbs, true)
+				}
+
+				if err != nil {
+					if revisionMismatch(c, resp, err) {
+						return
+					}
+					resp.AddError(err)
+					status = http.StatusInternalServerError
+				} else {
+					resp.AddContent(resp.Database, "ok")
+					status = http.StatusOK
+				}
 			}
 		} else {
-			resp.AddErrorStr(fmt.Sprintf("Key %s not found in %s", dataKey, resp.Database))
+			resp.AddErrorStr(fmt.Sprintf(errors.ErrKeyNotFound.Error(), dataKey, resp.Database))
 		}
 	} else {
 		resp.AddError(errors.ErrDatabaseNotFound)
@@ -303,33 +605,88 @@ func (sh *ServeHandler) deleteData(c *gin.Context) {
 	c.JSON(status, resp)
 }
 
-func (sh *ServeHandler) getData(dbname, key, token string) (*model.DataDefinition, error) {
+// getData returns current revision of key, or revision rev if it is set
+func (sh *ServeHandler) getData(dbname, key, token, rev string) (*model.DataDefinition, error) {
 	// Check if database exists
 	sto, ok := sh.dbManager.GetDatabase(dbname)
 	if !ok {
 		return nil, errors.ErrDatabaseNotFound
 	}
 
-	// Async get requested data
-	result := <-sh.dbManager.GetData(dbname, key)
+	var result *model.DataDefinition
+	if len(rev) > 0 {
+		n, err := strconv.ParseUint(rev, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "rev")
+		}
+		result, _ = sto.GetDataByRevision(key, uint32(n))
+	} else {
+		// Async get requested data
+		result = <-sh.dbManager.GetData(dbname, key)
+	}
 
-	// Check if found requested data or DataDefinition is deleted
-	if result == nil || result.Status == model.DataDefinitionRemoved {
-		return nil, errors.ErrEmptyQueryResult
+	if err := checkData(sto, result, token); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// openData is like getData, data is read from the returned
+// DataStream, which must be closed
+func (sh *ServeHandler) openData(dbname, key, token, rev string) (*model.DataDefinition, *db.DataStream, error) {
+	// Check if database exists
+	sto, ok := sh.dbManager.GetDatabase(dbname)
+	if !ok {
+		return nil, nil, errors.ErrDatabaseNotFound
+	}
+
+	var result *model.DataDefinition
+	var stream *db.DataStream
+	if len(rev) > 0 {
+		n, err := strconv.ParseUint(rev, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf(errors.ErrParse.Error(), "rev")
+		}
+		result, stream, _ = sto.GetDataStreamByRevision(key, uint32(n))
+	} else {
+		result, stream, _ = sto.GetDataStream(key)
+	}
+
+	if err := checkData(sto, result, token); err != nil {
+		if stream != nil {
+			stream.Close()
+		}
+		return nil, nil, err
+	}
+
+	return result, stream, nil
+}
+
+// checkData checks that data was found, is not removed, can be
+// decoded and that token matches if database requires it
+func checkData(sto *db.Database, df *model.DataDefinition, token string) error {
+	// Check if found requested data or DataDefinition is deleted or not an image
+	if df == nil || df.Status != model.DataDefinitionActive {
+		return errors.ErrEmptyQueryResult
+	}
+
+	if df.Corrupted {
+		return errors.ErrCorruptedRecord
 	}
 
 	// Token verification if enabled
 	if sto.Descriptor.TokenActive {
 		if token == "" {
-			return nil, errors.ErrWrongRequest
+			return errors.ErrWrongRequest
 		}
 
-		if token != result.Token {
-			return nil, errors.ErrWrongToken
+		if token != df.Token {
+			return errors.ErrWrongToken
 		}
 	}
 
-	return result, nil
+	return nil
 }
 
 func (sh *ServeHandler) get(c *gin.Context) {
@@ -338,19 +695,156 @@ func (sh *ServeHandler) get(c *gin.Context) {
 	key := c.Param("key")
 	token := c.Param("token")
 
-	df, err := sh.getData(resp.Database, key, token)
+	// image operations requested in query, applied
+	// to a copy of the image, stored one is not changed
+	t, err := parseTransform(c.Request.URL.Query())
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	sto, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	// database that requires signed URLs serves only the ones minted by _sign
+	query := c.Request.URL.Query()
+	signed := false
+	if sto.Descriptor.SignedURLs || len(query.Get("sig")) > 0 {
+		err := sh.keyring.VerifyURL(signedPath(resp.Database, key, token), query, time.Now())
+		if err != nil && sto.Descriptor.SignedURLs {
+			resp.AddError(err)
+			c.JSON(http.StatusForbidden, resp)
+			return
+		}
+		signed = err == nil
+	}
+
+	// scripts are run over images read by image managers or
+	// by signed URLs, whose signature covers script parameter
+	if len(t.Script) > 0 && sh.dbManager.Config.AuthenticationActive && !signed && !hasPermission(c, auth.RoleImageManager) {
+		resp.AddError(errors.ErrNoPrivilege)
+		c.JSON(http.StatusUnauthorized, resp)
+		return
+	}
+
+	df, stream, err := sh.openData(resp.Database, key, token, c.Query("rev"))
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(dataErrorStatus(err), resp)
+		return
+	}
+	defer stream.Close()
+
+	var content io.ReadSeeker = stream
+	contentType, etag := df.MediaType, df.ETag()
+	if len(contentType) == 0 {
+		contentType = imageContentType(df.Ext)
+	}
+
+	if !t.IsEmpty() {
+		b, err := transformData(c.Request.Context(), sh.transforms, sto, df, stream, t)
+		if err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		content = bytes.NewReader(b)
+		contentType, etag = script.DetectMediaType(b), derivedETag(df, t)
+	}
+
+	if len(sto.Descriptor.CacheControl) > 0 {
+		c.Writer.Header().Set("Cache-Control", sto.Descriptor.CacheControl)
+	}
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
+	c.Writer.Header().Set("ETag", etag)
+
+	// scripts of SVG images are not run by browsers
+	if contentType == script.MediaTypeSVG {
+		c.Writer.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	}
+
+	// answers conditional and range requests from ETag and Last-Modified,
+	// with 304 or 206 responses. Chunks are read as they are written
+	http.ServeContent(c.Writer, c.Request, "", df.Time(), content)
+}
+
+// keysLimit returns number of keys requested in limit parameter
+func keysLimit(c *gin.Context) (int, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultKeysLimit)))
+	if err != nil || limit <= 0 || limit > maxKeysLimit {
+		return 0, fmt.Errorf(errors.ErrParse.Error(), "limit")
+	}
+	return limit, nil
+}
+
+// queryAttribute lists keys of data whose attribute attr is equal to
+// eq parameter or starts with prefix parameter
+func (sh *ServeHandler) queryAttribute(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleImageManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+	}
+
+	db, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	limit, err := keysLimit(c)
 	if err != nil {
 		resp.AddError(err)
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
 
-	c.Writer.Header().Add("Content-Type", "image/"+df.Ext)
-	c.Writer.Header().Add("Content-Length", strconv.Itoa(int(df.Size)))
-	c.Writer.Write(df.Buf)
+	value, equal := c.GetQuery("eq")
+	prefix, hasPrefix := c.GetQuery("prefix")
+	if equal == hasPrefix {
+		resp.AddError(errors.ErrInvalidQueryAction)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	if hasPrefix {
+		value = prefix
+	}
+
+	page, err := db.QueryAttribute(c.Query("attr"), value, hasPrefix, c.Query("cursor"), limit)
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	resp.AddContent("keys", page.Keys)
+	resp.AddContent("cursor", page.Cursor)
+	c.JSON(http.StatusOK, resp)
 }
 
 func (sh *ServeHandler) getDataInfo(c *gin.Context) {
+	if c.Param("key") == "_compact" {
+		sh.compaction(c)
+		return
+	}
+
+	if c.Param("key") == "_query" {
+		sh.queryAttribute(c)
+		return
+	}
+
 	resp := NewResponse()
 	resp.Database = c.Param("dbname")
 
@@ -375,25 +869,88 @@ func (sh *ServeHandler) getDataInfo(c *gin.Context) {
 			return
 		}
 
-		resp.AddContent("keys", sto.Keys())
+		limit, err := keysLimit(c)
+		if err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+
+		page, err := sto.ListKeys(c.Query("prefix"), c.Query("cursor"), limit)
+		if err != nil {
+			resp.AddError(err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+
+		resp.AddContent("keys", page.Keys)
+		resp.AddContent("cursor", page.Cursor)
 		c.JSON(http.StatusOK, resp)
 		return
 	}
 
-	df, err := sh.getData(resp.Database, key, token)
+	df, err := sh.getData(resp.Database, key, token, c.Query("rev"))
 	if err != nil {
 		resp.AddError(err)
-		c.JSON(http.StatusBadRequest, resp)
+		c.JSON(dataErrorStatus(err), resp)
 		return
 	}
 
+	c.Header("ETag", df.ETag())
 	resp.AddContent("data", df.QueryResult())
 	c.IndentedJSON(http.StatusOK, resp)
 }
 
-// NewServeHandler returns new ServeHandler
-func NewServeHandler(dbm *db.DBManager) *ServeHandler {
+// getHistory lists stored revisions of key, newest first
+func (sh *ServeHandler) getHistory(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleImageManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+	}
+
+	db, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	key := c.Param("key")
+	revs, err := db.History(key)
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	if len(revs) == 0 {
+		resp.AddErrorStr(fmt.Sprintf(errors.ErrKeyNotFound.Error(), key, resp.Database))
+		c.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	resp.AddContent("revisions", revs)
+	c.JSON(http.StatusOK, resp)
+}
+
+// NewServeHandler returns new ServeHandler, URLs are signed with keys of
+// keyring. Images are transformed by one request per CPU at a time
+// unless configuration sets it
+func NewServeHandler(dbm *db.DBManager, keyring *auth.Keyring) *ServeHandler {
+	n := dbm.Config.MaxConcurrentTransforms
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
 	return &ServeHandler{
-		dbManager: dbm,
+		dbManager:  dbm,
+		keyring:    keyring,
+		transforms: make(chan struct{}, n),
 	}
 }
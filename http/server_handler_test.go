@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_GetConditionalAndRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, dbm := newTestServer(t, dir)
+	defer srv.Close()
+	defer dbm.Stop()
+
+	// noise does not compress, image is stored in several chunks
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	w, _ := mw.CreateFormFile("uploadfile", "noise.png")
+	w.Write(data.Bytes())
+	mw.Close()
+	res, b := doRequest(t, "PUT", srv.URL+"/api/images/noise", mw.FormDataContentType(), strings.NewReader(body.String()), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected image stored, got %d %s", res.StatusCode, b)
+	}
+
+	sto, _ := dbm.GetDatabase("images")
+	df, _ := sto.GetDataByKey("noise")
+	if !df.IsChunked() {
+		t.Fatal("expected image stored in chunks")
+	}
+
+	res, b = doRequest(t, "GET", srv.URL+"/g/images/noise", "", strings.NewReader(""), nil)
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != http.StatusOK || etag != df.ETag() || !bytes.Equal(b, data.Bytes()) {
+		t.Fatalf("expected image with ETag %s, got %d %s", df.ETag(), res.StatusCode, etag)
+	}
+
+	// matching ETag
+	res, b = doRequest(t, "GET", srv.URL+"/g/images/noise", "", strings.NewReader(""), http.Header{"If-None-Match": {etag}})
+	if res.StatusCode != http.StatusNotModified || len(b) > 0 {
+		t.Fatalf("expected 304 without body, got %d %d bytes", res.StatusCode, len(b))
+	}
+	res, _ = doRequest(t, "GET", srv.URL+"/g/images/noise", "", strings.NewReader(""), http.Header{"If-None-Match": {`"0-other"`}})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for other ETag, got %d", res.StatusCode)
+	}
+
+	// range across chunks
+	res, b = doRequest(t, "GET", srv.URL+"/g/images/noise", "", strings.NewReader(""), http.Header{"Range": {"bytes=1000-2099"}})
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(b, data.Bytes()[1000:2100]) {
+		t.Fatalf("expected 206 with bytes 1000-2099, got %d %d bytes", res.StatusCode, len(b))
+	}
+	if res.Header.Get("ETag") != etag {
+		t.Fatalf("expected ETag of partial response %s, got %s", etag, res.Header.Get("ETag"))
+	}
+
+	// range of other revision is served in full
+	res, b = doRequest(t, "GET", srv.URL+"/g/images/noise", "", strings.NewReader(""), http.Header{"Range": {"bytes=0-9"}, "If-Range": {`"0-other"`}})
+	if res.StatusCode != http.StatusOK || len(b) != data.Len() {
+		t.Fatalf("expected full image for stale If-Range, got %d %d bytes", res.StatusCode, len(b))
+	}
+
+	// transformed image has its own ETag
+	res, _ = doRequest(t, "GET", srv.URL+"/g/images/noise?w=8", "", strings.NewReader(""), nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") == etag {
+		t.Fatalf("expected ETag of transformed image, got %d %s", res.StatusCode, res.Header.Get("ETag"))
+	}
+}
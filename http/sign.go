@@ -0,0 +1,116 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/auth"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultSignedURLExpire time in seconds a signed URL is
+	// valid when expires is not requested, 1 hour
+	defaultSignedURLExpire = 3600
+
+	// maxSignedURLExpire max time in seconds a signed URL is valid, 7 days
+	maxSignedURLExpire = 604800
+)
+
+// signedPath returns path of image URL that is signed, it is not escaped
+func signedPath(dbname, key, token string) string {
+	path := "/g/" + dbname + "/" + key
+	if len(token) > 0 {
+		path += "/" + token
+	}
+	return path
+}
+
+// imageURL returns escaped path of image URL
+func imageURL(dbname, key, token string) string {
+	path := "/g/" + url.PathEscape(dbname) + "/" + url.PathEscape(key)
+	if len(token) > 0 {
+		path += "/" + url.PathEscape(token)
+	}
+	return path
+}
+
+// signURL returns URL of image valid for expires seconds. Other query
+// parameters, rev and image operations, are part of the signed URL
+func (sh *ServeHandler) signURL(c *gin.Context) {
+	resp := NewResponse()
+	resp.Database = c.Param("dbname")
+
+	if sh.dbManager.Config.AuthenticationActive {
+		if hasPermission(c, auth.RoleImageManager) == false {
+			resp.AddError(errors.ErrNoPrivilege)
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+	}
+
+	sto, ok := sh.dbManager.GetDatabase(resp.Database)
+	if !ok {
+		resp.AddError(errors.ErrDatabaseNotFound)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	query := c.Request.URL.Query()
+	expire, err := strconv.Atoi(c.DefaultQuery("expires", strconv.Itoa(defaultSignedURLExpire)))
+	if err != nil || expire <= 0 || expire > maxSignedURLExpire {
+		resp.AddError(fmt.Errorf(errors.ErrParse.Error(), "expires"))
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	query.Del("expires")
+
+	if _, err := parseTransform(query); err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	key := c.Param("key")
+	var df *model.DataDefinition
+	if rev := query.Get("rev"); len(rev) > 0 {
+		n, err := strconv.ParseUint(rev, 10, 32)
+		if err != nil {
+			resp.AddError(fmt.Errorf(errors.ErrParse.Error(), "rev"))
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		df, _ = sto.GetDataByRevision(key, uint32(n))
+	} else {
+		df, _ = sto.GetDataByKey(key)
+	}
+
+	if df == nil || df.Status != model.DataDefinitionActive {
+		resp.AddErrorStr(fmt.Sprintf(errors.ErrKeyNotFound.Error(), key, resp.Database))
+		c.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	// token is part of the path of databases that require it
+	token := ""
+	if sto.Descriptor.TokenActive {
+		token = df.Token
+	}
+
+	expires := time.Now().Add(time.Duration(expire) * time.Second)
+	signed, err := sh.keyring.SignURL(signedPath(resp.Database, key, token), query, expires)
+	if err != nil {
+		resp.AddError(err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	resp.AddContent("url", imageURL(resp.Database, key, token)+"?"+signed.Encode())
+	resp.AddContent("expires", expires.Unix())
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	govalidator "gopkg.in/asaskevich/govalidator.v4"
+
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/script"
+)
+
+// parseTransform reads image operations from query parameters
+// w, h, crop, rotate, gray, format, quality and script
+func parseTransform(query url.Values) (*script.Transform, error) {
+	t := &script.Transform{}
+
+	size := func(name string) (int, error) {
+		v := query.Get(name)
+		if len(v) == 0 {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > script.MaxTransformSize {
+			return 0, fmt.Errorf(errors.ErrParse.Error(), name)
+		}
+		return n, nil
+	}
+
+	var err error
+	if t.Width, err = size("w"); err != nil {
+		return nil, err
+	}
+	if t.Height, err = size("h"); err != nil {
+		return nil, err
+	}
+
+	// crop=x1,y1,x2,y2
+	if v := query.Get("crop"); len(v) > 0 {
+		p := strings.Split(v, ",")
+		if len(p) != 4 {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "crop")
+		}
+
+		var n [4]int
+		for i := range p {
+			if n[i], err = strconv.Atoi(strings.TrimSpace(p[i])); err != nil || n[i] < 0 {
+				return nil, fmt.Errorf(errors.ErrParse.Error(), "crop")
+			}
+		}
+
+		t.Crop = image.Rect(n[0], n[1], n[2], n[3])
+		if t.Crop.Empty() {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "crop")
+		}
+	}
+
+	if v := query.Get("rotate"); len(v) > 0 {
+		if t.Rotate, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "rotate")
+		}
+	}
+
+	if v := query.Get("gray"); len(v) > 0 {
+		if t.Grayscale, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "gray")
+		}
+	}
+
+	if v := query.Get("format"); len(v) > 0 {
+		format, ok := script.FormatName(strings.ToLower(v))
+		if !ok {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "format")
+		}
+		t.Format = format
+	}
+
+	if v := query.Get("quality"); len(v) > 0 {
+		if t.Quality, err = strconv.Atoi(v); err != nil || t.Quality < 1 || t.Quality > 100 {
+			return nil, fmt.Errorf(errors.ErrParse.Error(), "quality")
+		}
+	}
+
+	if v := query.Get("script"); len(v) > 0 {
+		if !govalidator.IsAlphanumeric(v) || !govalidator.IsByteLength(v, 3, 50) {
+			return nil, errors.ErrScriptInvalidName
+		}
+		t.Script = v
+
+		// content of script is part of cache key and ETag of
+		// images it transforms, saving it again changes them
+		if err := t.LoadScript(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// transformData returns data of df read from r transformed by t,
+// transformed images are kept in derived cache of database. At most
+// cap(slots) images are transformed at a time, others wait until ctx
+// is done
+func transformData(ctx context.Context, slots chan struct{}, sto *db.Database, df *model.DataDefinition, r io.Reader, t *script.Transform) ([]byte, error) {
+	params := t.String()
+	if b := sto.GetDerived(df, params); b != nil {
+		return b, nil
+	}
+
+	if df.Size > script.MaxTransformSource {
+		return nil, errors.ErrTransformTooLarge
+	}
+
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	b, err := t.Apply(df.Key, r)
+	if err != nil {
+		return nil, err
+	}
+
+	sto.PutDerived(df, params, b)
+	return b, nil
+}
+
+// derivedETag returns ETag of the image derived from df by t
+func derivedETag(df *model.DataDefinition, t *script.Transform) string {
+	return fmt.Sprintf("\"%d-%s-%08x\"", df.Revision, df.Token, crc32.ChecksumIEEE([]byte(t.String())))
+}
@@ -0,0 +1,282 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/errors"
+	"github.com/SparrowDb/sparrowdb/model"
+	"github.com/SparrowDb/sparrowdb/script"
+	"github.com/SparrowDb/sparrowdb/util/uuid"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxFormValueSize max size in bytes of a form field of upload request
+	maxFormValueSize = model.MaxAttributeValueSize + 1024
+
+	// maxHeadSize max size in bytes of the first part of an image written
+	// by chunks that is kept to read its information, EXIF fits in it
+	maxHeadSize = 65536 + 1024
+)
+
+// uploadForm holds fields and image of an upload request. An image larger
+// than database chunk size is written to commitlog by chunks as it arrives,
+// a smaller one is kept in data
+type uploadForm struct {
+	values    url.Values
+	filename  string
+	mediaType string
+	data      []byte
+	chunks    *db.ChunkWriter
+
+	// first bytes of an image written by chunks
+	head []byte
+}
+
+// Close releases chunks of image, it must be called after
+// the image is inserted
+func (f *uploadForm) Close() {
+	if f.chunks != nil {
+		f.chunks.Close()
+	}
+}
+
+// readUploadForm reads multipart upload request part by part. Fields sent
+// after uploadfile are read too, except script, which must be sent before
+// it when image is written by chunks. check is called with the fields read
+// before an image is written by chunks, its error is returned unchanged
+func readUploadForm(c *gin.Context, sto *db.Database, key string, check func(values url.Values) error) (*uploadForm, error) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &uploadForm{values: url.Values{}}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+
+		if part.FormName() == "uploadfile" && len(form.filename) == 0 {
+			form.filename = part.FileName()
+			err = form.readFile(part, sto, key, len(strings.TrimSpace(form.values.Get("script"))) > 0, check)
+		} else {
+			err = form.readValue(part)
+		}
+		part.Close()
+
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+	}
+
+	if len(form.filename) == 0 {
+		return nil, http.ErrMissingFile
+	}
+
+	if form.chunks != nil && len(strings.TrimSpace(form.values.Get("script"))) > 0 {
+		form.Close()
+		return nil, fmt.Errorf(errors.ErrScriptOrder.Error(), sto.Descriptor.ChunkSize)
+	}
+	return form, nil
+}
+
+// readFile keeps image in memory if it fits in a chunk or
+// script is executed over it, otherwise it writes it by chunks
+func (f *uploadForm) readFile(r io.Reader, sto *db.Database, key string, script bool, check func(values url.Values) error) error {
+	buf := new(bytes.Buffer)
+	if script {
+		if _, err := io.Copy(buf, r); err != nil {
+			return err
+		}
+		f.data = buf.Bytes()
+		return f.setMediaType(sto, f.data)
+	}
+
+	n, err := io.CopyN(buf, r, int64(sto.Descriptor.ChunkSize)+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// rejected before any chunk is written
+	if err := f.setMediaType(sto, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if n <= int64(sto.Descriptor.ChunkSize) {
+		f.data = buf.Bytes()
+		return nil
+	}
+
+	// chunks of an image that can not be inserted are not written
+	if err := check(f.values); err != nil {
+		return err
+	}
+
+	f.head = buf.Bytes()
+	if len(f.head) > maxHeadSize {
+		f.head = f.head[:maxHeadSize]
+	}
+
+	f.chunks = sto.NewChunkWriter(key)
+	if _, err := f.chunks.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	_, err = io.Copy(f.chunks, r)
+	return err
+}
+
+// checkUpload checks with the fields sent so far that an image of key
+// can be inserted, before it is written by chunks. Insert checks them
+// again. Returns true if key exists and upsert was not sent
+func checkUpload(c *gin.Context, sto *db.Database, key string, values url.Values) (bool, error) {
+	expected, match, err := writeCondition(c, values)
+	if err != nil {
+		return false, err
+	}
+
+	stored, ok := sto.GetDataByKey(key)
+	if !ok || stored.Status != model.DataDefinitionActive {
+		stored = nil
+	}
+
+	if match != nil {
+		if !match(stored) {
+			current := uint32(0)
+			if stored != nil {
+				current = stored.Revision
+			}
+			return false, &db.RevisionError{Key: key, Expected: expected, Current: current}
+		}
+		return false, nil
+	}
+
+	if stored != nil && values.Get("upsert") != "true" {
+		return true, fmt.Errorf(errors.ErrKeyExists.Error(), key)
+	}
+	return false, nil
+}
+
+// setMediaType detects media type of image from its
+// first bytes b, it must be allowed by database
+func (f *uploadForm) setMediaType(sto *db.Database, b []byte) error {
+	f.mediaType = script.DetectMediaType(b)
+	return checkMediaType(sto, f.mediaType)
+}
+
+// checkMediaType checks if database allows data of media type t
+func checkMediaType(sto *db.Database, t string) error {
+	if !sto.Descriptor.AllowsMediaType(t) {
+		return fmt.Errorf(errors.ErrMediaTypeNotAllowed.Error(), t)
+	}
+	return nil
+}
+
+func (f *uploadForm) readValue(part *multipart.Part) error {
+	b := new(bytes.Buffer)
+	n, err := io.CopyN(b, part, maxFormValueSize+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n > maxFormValueSize {
+		return fmt.Errorf(errors.ErrParse.Error(), part.FormName())
+	}
+
+	f.values.Add(part.FormName(), b.String())
+	return nil
+}
+
+// expiresAt returns expiry time of data written with ttl in seconds, the
+// default TTL of sto is used if ttl is empty. Data with ttl 0 does not expire
+func expiresAt(sto *db.Database, ttl string) (int64, error) {
+	seconds := int64(sto.Descriptor.DefaultTTL)
+	if len(ttl) > 0 {
+		n, err := strconv.ParseUint(ttl, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf(errors.ErrParse.Error(), "ttl")
+		}
+		seconds = int64(n)
+	}
+
+	if seconds <= 0 {
+		return 0, nil
+	}
+	return time.Now().Unix() + seconds, nil
+}
+
+// formMap returns fields of values named name[key] as a map of key
+func formMap(values url.Values, name string) map[string]string {
+	m := make(map[string]string)
+	for k, v := range values {
+		if strings.HasPrefix(k, name+"[") && strings.HasSuffix(k, "]") && len(k) > len(name)+2 {
+			m[k[len(name)+1:len(k)-1]] = v[0]
+		}
+	}
+	return m
+}
+
+// inspectImage reads information of image b, or of the first bytes of
+// an image written by chunks, and sets it in df. Whole image is decoded
+// for its hash only if it is in memory, which is rotated by its EXIF
+// orientation if database requires it. Returns data of image
+func (f *uploadForm) inspectImage(sto *db.Database, df *model.DataDefinition, b []byte) []byte {
+	head := b
+	if f.chunks != nil {
+		head = f.head
+	}
+
+	info, ok := script.ReadInfo(head)
+	if !ok {
+		return b
+	}
+
+	if f.chunks == nil {
+		b = info.Analyze(b, sto.Descriptor.AutoOrient)
+	}
+
+	df.Width, df.Height = uint32(info.Width), uint32(info.Height)
+	df.Hash, df.Exif = info.Hash, info.Exif
+	return b
+}
+
+// newUploadData returns new DataDefinition of uploaded image b
+func newUploadData(key, ext, mediaType string, attrs map[string]string, b []byte) *model.DataDefinition {
+	return &model.DataDefinition{
+		Key: key,
+
+		// default store UUID to keep information of insert time
+		// and eliminates attacks aimed at guessing valid URLs for photos
+		Token: uuid.TimeUUID().String(),
+
+		Ext: ext,
+
+		// detected from content, served as Content-Type
+		MediaType: mediaType,
+
+		Size: uint32(len(b)),
+
+		// Default status 1 (Active)
+		Status: model.DataDefinitionActive,
+
+		Revision: 0,
+
+		Attributes: attrs,
+
+		Buf: b,
+	}
+}
@@ -1,7 +1,13 @@
 package model
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/util"
 	"github.com/SparrowDb/sparrowdb/util/uuid"
 )
@@ -12,48 +18,193 @@ const (
 
 	// DataDefinitionRemoved removed status
 	DataDefinitionRemoved
+
+	// DataDefinitionChunk status of a record holding a chunk
+	// of the data of another DataDefinition
+	DataDefinitionChunk
+
+	// DataDefinitionBlob status of a record holding data shared
+	// by the DataDefinition that refer to it by content
+	DataDefinitionBlob
+
+	// DataDefinitionBatch status of a record whose data holds records
+	// written together, they are read all or none
+	DataDefinitionBatch
+)
+
+const (
+	// dataDefinitionMark starts versioned DataDefinition, DataDefinition
+	// written before versioning starts with key size, which never has
+	// this value
+	dataDefinitionMark = 0xFFFFFFFF
+
+	// dataDefinitionVersion version of DataDefinition format
+	//   1: key, token, size, ext, status, revision, data
+	//   2: attributes after revision
+	//   3: chunk size and chunk offsets after attributes
+	//   4: media type after chunk offsets
+	//   5: width, height, perceptual hash and EXIF after media type
+	//   6: blob after EXIF
+	//   7: codec of data before data
+	//   8: expiry time after blob
+	//   9: data holder of each chunk after expiry time
+	dataDefinitionVersion = 9
+
+	// MaxAttributes max number of attributes of a DataDefinition
+	MaxAttributes = 64
+
+	// MaxAttributeKeySize max size in bytes of an attribute name
+	MaxAttributeKeySize = 128
+
+	// MaxAttributeValueSize max size in bytes of an attribute value
+	MaxAttributeValueSize = 4096
 )
 
 // DataDefinition holds the stored item
 type DataDefinition struct {
-	Key      string
-	Size     uint32
-	Token    string
-	Ext      string
-	Status   uint16
-	Revision uint32
-	Buf      []byte
+	Key        string
+	Size       uint32
+	Token      string
+	Ext        string
+	Status     uint16
+	Revision   uint32
+	Attributes map[string]string
+	Buf        []byte
+
+	// data stored in chunk records of ChunkSize bytes, the last one
+	// may be smaller. Chunks holds their offsets and Buf is empty.
+	// ChunkFiles holds the name of the data holder of each chunk, 0
+	// if it is the file of the record, it is nil if all of them are
+	ChunkSize  uint32
+	Chunks     []int64
+	ChunkFiles []int64
+
+	// media type detected from content of data
+	MediaType string
+
+	// read from image when it is stored
+	Width  uint32
+	Height uint32
+	Hash   uint64
+	Exif   map[string]string
+
+	// content address of the blob holding data when it is
+	// deduplicated, Buf is empty in the stored record
+	Blob string
+
+	// unix time in seconds data expires at, 0 if it does not
+	Expires int64
+
+	// codec data is compressed with when it is stored. When read,
+	// codec it was stored with, CodecNone if ratio was poor
+	Codec uint16
+
+	// set when data read could not be decoded, Buf is empty.
+	// It is not stored
+	Corrupted bool
 }
 
 // DataDefinitionResult holds DataDefinition query result
 type DataDefinitionResult struct {
-	Key       string
-	Size      uint32
-	Token     string
-	Timestamp string
-	Ext       string
-	Revision  uint32
+	Key        string
+	Size       uint32
+	Token      string
+	Timestamp  string
+	Ext        string
+	MediaType  string
+	Width      uint32
+	Height     uint32
+	Hash       string
+	Exif       map[string]string
+	Revision   uint32
+	Attributes map[string]string
+	Expires    string
 }
 
 // QueryResult convert DataDefinition to DataDefinitionResult
 func (df *DataDefinition) QueryResult() *DataDefinitionResult {
 	dfr := DataDefinitionResult{
-		Key:      df.Key,
-		Size:     df.Size,
-		Token:    df.Token,
-		Ext:      df.Ext,
-		Revision: df.Revision,
+		Key:        df.Key,
+		Size:       df.Size,
+		Token:      df.Token,
+		Ext:        df.Ext,
+		MediaType:  df.MediaType,
+		Width:      df.Width,
+		Height:     df.Height,
+		Exif:       df.Exif,
+		Revision:   df.Revision,
+		Attributes: df.Attributes,
 	}
 
-	uuid, _ := uuid.ParseUUID(df.Token)
-	dfr.Timestamp = uuid.Time().String()
+	if df.Hash != 0 {
+		dfr.Hash = fmt.Sprintf("%016x", df.Hash)
+	}
+
+	dfr.Timestamp = df.Time().String()
+
+	if df.Expires != 0 {
+		dfr.Expires = time.Unix(df.Expires, 0).String()
+	}
 
 	return &dfr
 }
 
-// ToByteStream convert DataDefinition to ByteStream
+// Time returns when data was stored, taken from its token
+func (df *DataDefinition) Time() time.Time {
+	u, _ := uuid.ParseUUID(df.Token)
+	return u.Time()
+}
+
+// Expired checks if data expired at time now
+func (df *DataDefinition) Expired(now time.Time) bool {
+	return df.Expires != 0 && now.Unix() >= df.Expires
+}
+
+// IsChunked checks if data is stored in chunk records
+func (df *DataDefinition) IsChunked() bool {
+	return len(df.Chunks) > 0
+}
+
+// ChunkFile returns name of data holder of chunk i, 0 if
+// it is in the file of the record
+func (df *DataDefinition) ChunkFile(i int) int64 {
+	if i < len(df.ChunkFiles) {
+		return df.ChunkFiles[i]
+	}
+	return 0
+}
+
+// ETag returns entity tag of DataDefinition, it changes with each revision
+func (df *DataDefinition) ETag() string {
+	return fmt.Sprintf("\"%d-%s\"", df.Revision, df.Token)
+}
+
+// Encoder converts DataDefinition to and from ByteStream,
+// data is compressed with its Codecs
+type Encoder struct {
+	codecs *compression.Codecs
+}
+
+var defaultEncoder = NewEncoder(nil)
+
+// NewEncoder returns new Encoder, default codecs are used if codecs is nil
+func NewEncoder(codecs *compression.Codecs) *Encoder {
+	if codecs == nil {
+		codecs = compression.Default()
+	}
+	return &Encoder{codecs: codecs}
+}
+
+// ToByteStream convert DataDefinition to ByteStream with default codecs
 func (df *DataDefinition) ToByteStream() *util.ByteStream {
+	return defaultEncoder.ToByteStream(df)
+}
+
+// ToByteStream convert DataDefinition to ByteStream
+func (e *Encoder) ToByteStream(df *DataDefinition) *util.ByteStream {
 	byteStream := util.NewByteStream()
+	byteStream.PutUInt32(dataDefinitionMark)
+	byteStream.PutUInt16(dataDefinitionVersion)
 	byteStream.PutString(df.Key)
 	byteStream.PutString(df.Token)
 	byteStream.PutUInt32(df.Size)
@@ -61,15 +212,74 @@ func (df *DataDefinition) ToByteStream() *util.ByteStream {
 	byteStream.PutUInt16(df.Status)
 	byteStream.PutUInt32(df.Revision)
 
-	encoded := compression.Compress(df.Buf)
+	putStringMap(byteStream, df.Attributes)
+
+	byteStream.PutUInt32(df.ChunkSize)
+	byteStream.PutUInt32(uint32(len(df.Chunks)))
+	for _, offset := range df.Chunks {
+		byteStream.PutUInt64(uint64(offset))
+	}
+	byteStream.PutString(df.MediaType)
+	byteStream.PutUInt32(df.Width)
+	byteStream.PutUInt32(df.Height)
+	byteStream.PutUInt64(df.Hash)
+	putStringMap(byteStream, df.Exif)
+	byteStream.PutString(df.Blob)
+	byteStream.PutUInt64(uint64(df.Expires))
+	byteStream.PutUInt32(uint32(len(df.ChunkFiles)))
+	for _, file := range df.ChunkFiles {
+		byteStream.PutUInt64(uint64(file))
+	}
+
+	codec, encoded := e.codecs.Encode(df.Codec, df.Buf)
+	byteStream.PutUInt16(codec)
 	byteStream.PutBytes(encoded)
 
 	return byteStream
 }
 
-// NewDataDefinitionFromByteStream convert ByteStream to DataDefinition
-func NewDataDefinitionFromByteStream(bs *util.ByteStream) *DataDefinition {
+// putStringMap writes m sorted by key, so equal
+// DataDefinition have the same bytes
+func putStringMap(bs *util.ByteStream, m map[string]string) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bs.PutUInt32(uint32(len(names)))
+	for _, name := range names {
+		bs.PutString(name)
+		bs.PutString(m[name])
+	}
+}
+
+// getStringMap reads map written by putStringMap, nil if it is empty
+func getStringMap(bs *util.ByteStream) map[string]string {
+	count := bs.GetUInt32()
+	if count == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, count)
+	for i := uint32(0); i < count; i++ {
+		name := bs.GetString()
+		m[name] = bs.GetString()
+	}
+	return m
+}
+
+// NewDataDefinitionHeaderFromByteStream convert ByteStream to DataDefinition
+// without reading the stored data
+func NewDataDefinitionHeaderFromByteStream(bs *util.ByteStream) *DataDefinition {
 	df := DataDefinition{}
+
+	version := uint16(1)
+	if bs.PeekUInt32() == dataDefinitionMark {
+		bs.GetUInt32()
+		version = bs.GetUInt16()
+	}
+
 	df.Key = bs.GetString()
 	df.Token = bs.GetString()
 	df.Size = bs.GetUInt32()
@@ -77,10 +287,91 @@ func NewDataDefinitionFromByteStream(bs *util.ByteStream) *DataDefinition {
 	df.Status = bs.GetUInt16()
 	df.Revision = bs.GetUInt32()
 
-	buf := bs.GetBytes()
-	if decoded, err := compression.Decompress(buf); err == nil {
-		df.Buf = decoded
+	if version >= 2 {
+		df.Attributes = getStringMap(bs)
+	}
+
+	if version >= 3 {
+		df.ChunkSize = bs.GetUInt32()
+		if count := bs.GetUInt32(); count > 0 {
+			df.Chunks = make([]int64, count)
+			for i := range df.Chunks {
+				df.Chunks[i] = int64(bs.GetUInt64())
+			}
+		}
+	}
+
+	if version >= 4 {
+		df.MediaType = bs.GetString()
+	}
+
+	if version >= 5 {
+		df.Width = bs.GetUInt32()
+		df.Height = bs.GetUInt32()
+		df.Hash = bs.GetUInt64()
+		df.Exif = getStringMap(bs)
+	}
+
+	if version >= 6 {
+		df.Blob = bs.GetString()
+	}
+
+	if version >= 8 {
+		df.Expires = int64(bs.GetUInt64())
+	}
+
+	if version >= 9 {
+		if count := bs.GetUInt32(); count > 0 {
+			df.ChunkFiles = make([]int64, count)
+			for i := range df.ChunkFiles {
+				df.ChunkFiles[i] = int64(bs.GetUInt64())
+			}
+		}
+	}
+
+	// data of older versions is compressed with snappy
+	df.Codec = compression.CodecSnappy
+	if version >= 7 {
+		df.Codec = bs.GetUInt16()
 	}
 
 	return &df
 }
+
+// NewDataDefinitionFromByteStream convert ByteStream to DataDefinition
+// with default codecs
+func NewDataDefinitionFromByteStream(bs *util.ByteStream) *DataDefinition {
+	return defaultEncoder.FromByteStream(bs)
+}
+
+// FromByteStream convert ByteStream to DataDefinition, it
+// is marked as corrupted if its data can not be decoded
+func (e *Encoder) FromByteStream(bs *util.ByteStream) *DataDefinition {
+	df := NewDataDefinitionHeaderFromByteStream(bs)
+
+	buf := bs.GetBytes()
+	decoded, err := e.codecs.Decode(df.Codec, buf)
+	if err != nil {
+		df.Corrupted = true
+		return df
+	}
+	df.Buf = decoded
+
+	return df
+}
+
+// ValidateAttributes checks number of attributes and their sizes, names
+// and values can not hold NUL, it is used as separator by attribute index
+func ValidateAttributes(attrs map[string]string) error {
+	if len(attrs) > MaxAttributes {
+		return fmt.Errorf(errors.ErrTooManyAttributes.Error(), MaxAttributes)
+	}
+
+	for name, value := range attrs {
+		if len(name) == 0 || len(name) > MaxAttributeKeySize || len(value) > MaxAttributeValueSize ||
+			strings.ContainsRune(name, 0) || strings.ContainsRune(value, 0) {
+			return fmt.Errorf(errors.ErrInvalidAttribute.Error(), name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/compression"
+	"github.com/SparrowDb/sparrowdb/util"
+)
+
+func Test_DataDefinitionAttributes(t *testing.T) {
+	df := &DataDefinition{
+		Key:        "key",
+		Token:      "token",
+		Ext:        "png",
+		Size:       3,
+		Attributes: map[string]string{"caption": "sunset", "owner": "42"},
+		Buf:        []byte{1, 2, 3},
+		MediaType:  "image/png",
+		Width:      640,
+		Height:     480,
+		Hash:       0x8f3c0f0e1c3c7e7f,
+		Exif:       map[string]string{"make": "Canon", "orientation": "6"},
+		Expires:    1767225600,
+	}
+
+	rdf := NewDataDefinitionFromByteStream(util.NewByteStreamFromBytes(df.ToByteStream().Bytes()))
+	if !reflect.DeepEqual(df, rdf) {
+		t.Fatalf("expected %+v, got %+v", df, rdf)
+	}
+}
+
+func Test_DataDefinitionCodec(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible image data "), 1024)
+	noise := make([]byte, 128*1024)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	for _, tc := range []struct {
+		codec    uint16
+		buf      []byte
+		expected uint16
+	}{
+		{compression.CodecZstd, text, compression.CodecZstd},
+		{compression.CodecLZ4, text, compression.CodecLZ4},
+		{compression.CodecSnappy, noise, compression.CodecNone},
+	} {
+		df := &DataDefinition{Key: "key", Buf: tc.buf, Codec: tc.codec}
+		b := df.ToByteStream().Bytes()
+		if tc.expected == compression.CodecNone && len(b) < len(tc.buf) {
+			t.Fatalf("codec %d: expected data stored uncompressed", tc.codec)
+		}
+
+		rdf := NewDataDefinitionFromByteStream(util.NewByteStreamFromBytes(b))
+		if rdf.Codec != tc.expected || !bytes.Equal(rdf.Buf, tc.buf) {
+			t.Fatalf("codec %d: expected codec %d and same data, got codec %d", tc.codec, tc.expected, rdf.Codec)
+		}
+	}
+}
+
+func Test_DataDefinitionLegacyFormat(t *testing.T) {
+	bs := util.NewByteStream()
+	bs.PutString("key")
+	bs.PutString("token")
+	bs.PutUInt32(3)
+	bs.PutString("png")
+	bs.PutUInt16(DataDefinitionActive)
+	bs.PutUInt32(7)
+	bs.PutBytes(compression.NewSnappyCompressor().Compress([]byte{1, 2, 3}))
+
+	df := NewDataDefinitionFromByteStream(util.NewByteStreamFromBytes(bs.Bytes()))
+	if df.Key != "key" || df.Ext != "png" || df.Revision != 7 || df.Attributes != nil {
+		t.Fatalf("unexpected legacy DataDefinition %+v", df)
+	}
+	if !reflect.DeepEqual(df.Buf, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected legacy data %v", df.Buf)
+	}
+}
+
+func Test_DataDefinitionCorrupted(t *testing.T) {
+	df := &DataDefinition{Key: "key", Buf: bytes.Repeat([]byte("compressible image data "), 1024), Codec: compression.CodecZstd}
+	b := df.ToByteStream().Bytes()
+
+	// data compressed with zstd that can not be decompressed
+	for i := len(b) - 8; i < len(b); i++ {
+		b[i] ^= 0xff
+	}
+
+	rdf := NewDataDefinitionFromByteStream(util.NewByteStreamFromBytes(b))
+	if !rdf.Corrupted || len(rdf.Buf) != 0 {
+		t.Fatalf("expected record marked as corrupted, got %+v", rdf.Corrupted)
+	}
+}
@@ -1,11 +1,30 @@
 package model
 
-// CreateDatabase holds database parsed arguments from http request
+// CreateDatabase holds database parsed arguments from http request,
+// nil TombstoneGracePeriod and ExpirySweepInterval take the values of
+// configuration, 0 is valid for them
 type CreateDatabase struct {
-	MaxDataLogSize uint64  `json:"max_datalog_size"`
-	MaxCacheSize   uint64  `json:"max_cache_size"`
-	BloomFilterFp  float32 `json:"bloomfilter_fpp"`
-	CronExp        string  `json:"dataholder_cron_compaction"`
-	Path           string  `json:"path"`
-	SnapshotPath   string  `json:"snapshot_path"`
+	MaxDataLogSize        uint64  `json:"max_datalog_size"`
+	MaxCacheSize          uint64  `json:"max_cache_size"`
+	BloomFilterFp         float32 `json:"bloomfilter_fpp"`
+	CronExp               string  `json:"dataholder_cron_compaction"`
+	Path                  string  `json:"path"`
+	SnapshotPath          string  `json:"snapshot_path"`
+	CommitlogSync         string  `json:"commitlog_sync"`
+	CommitlogSyncInterval int     `json:"commitlog_sync_interval"`
+	CompactionTiers       string  `json:"compaction_tiers"`
+	CompactionMinHolders  int     `json:"compaction_min_holders"`
+	TombstoneGracePeriod  *int    `json:"tombstone_grace_period,omitempty"`
+	IndexedAttributes     string  `json:"indexed_attributes"`
+	MaxRevisions          int     `json:"max_revisions"`
+	CacheControl          string  `json:"cache_control"`
+	ChunkSize             uint32  `json:"chunk_size"`
+	MaxDerivedCacheSize   uint64  `json:"max_derived_cache_size"`
+	AllowedMediaTypes     string  `json:"allowed_media_types"`
+	AutoOrient            bool    `json:"auto_orient"`
+	Dedup                 bool    `json:"dedup"`
+	Compression           string  `json:"compression"`
+	DefaultTTL            int     `json:"default_ttl"`
+	ExpirySweepInterval   *int    `json:"expiry_sweep_interval,omitempty"`
+	SignedURLs            bool    `json:"signed_urls"`
 }
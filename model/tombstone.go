@@ -1,10 +1,20 @@
 package model
 
+import "github.com/SparrowDb/sparrowdb/util/uuid"
+
 // NewTombstone returns new DataDefinition
 // Tombstones are DataDefinition with Status = DataDefinitionRemoved
-// and empty byte buffer containing the image data
+// and empty byte buffer containing the image data. Its token keeps
+// the time of removal
 func NewTombstone(df *DataDefinition) *DataDefinition {
+	df.Token = uuid.TimeUUID().String()
 	df.Status = DataDefinitionRemoved
 	df.Buf = []byte("")
+	df.Attributes = nil
+	df.ChunkSize, df.Chunks, df.ChunkFiles = 0, nil, nil
+	df.MediaType = ""
+	df.Width, df.Height, df.Hash, df.Exif = 0, 0, 0, nil
+	df.Blob = ""
+	df.Expires = 0
 	return df
 }
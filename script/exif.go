@@ -0,0 +1,225 @@
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagGPSLatitudeRef   = 0x0001
+	exifTagGPSLatitude      = 0x0002
+	exifTagGPSLongitudeRef  = 0x0003
+	exifTagGPSLongitude     = 0x0004
+
+	exifTypeASCII    = 2
+	exifTypeShort    = 3
+	exifTypeLong     = 4
+	exifTypeRational = 5
+
+	// maxExifEntries max entries read from an IFD of a damaged file
+	maxExifEntries = 512
+)
+
+// exifEntry holds an IFD entry, value holds it or its offset
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifReader reads IFDs of the TIFF structure of EXIF data
+type exifReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// readExif returns EXIF fields of JPEG image b: make, model, orientation,
+// datetime and gps_latitude, gps_longitude in decimal degrees
+func readExif(b []byte) map[string]string {
+	tiff := jpegExif(b)
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	r := &exifReader{b: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	ifd0 := r.ifd(r.order.Uint32(tiff[4:]))
+	if ifd0 == nil {
+		return nil
+	}
+
+	fields := make(map[string]string)
+	for tag, name := range map[uint16]string{exifTagMake: "make", exifTagModel: "model", exifTagDateTime: "datetime"} {
+		if v, ok := r.ascii(ifd0[tag]); ok {
+			fields[name] = v
+		}
+	}
+	if v, ok := r.uint(ifd0[exifTagOrientation]); ok && v >= 1 && v <= 8 {
+		fields["orientation"] = strconv.Itoa(int(v))
+	}
+
+	if offset, ok := r.uint(ifd0[exifTagExifIFD]); ok {
+		if v, ok := r.ascii(r.ifd(offset)[exifTagDateTimeOriginal]); ok {
+			fields["datetime"] = v
+		}
+	}
+
+	if offset, ok := r.uint(ifd0[exifTagGPSIFD]); ok {
+		gps := r.ifd(offset)
+		if v, ok := r.coordinate(gps[exifTagGPSLatitude], gps[exifTagGPSLatitudeRef], "S"); ok {
+			fields["gps_latitude"] = v
+		}
+		if v, ok := r.coordinate(gps[exifTagGPSLongitude], gps[exifTagGPSLongitudeRef], "W"); ok {
+			fields["gps_longitude"] = v
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// jpegExif returns TIFF structure of APP1 Exif segment of JPEG image b
+func jpegExif(b []byte) []byte {
+	if !bytes.HasPrefix(b, []byte("\xff\xd8")) {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		// start of scan, image data follows
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint16(b[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(b) {
+			return nil
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(b[i+4:end], []byte("Exif\x00\x00")) {
+			return b[i+10 : end]
+		}
+		i = end
+	}
+	return nil
+}
+
+// ifd returns entries of IFD at offset by tag
+func (r *exifReader) ifd(offset uint32) map[uint16]exifEntry {
+	if int64(offset)+2 > int64(len(r.b)) {
+		return nil
+	}
+
+	count := int(r.order.Uint16(r.b[offset:]))
+	if count > maxExifEntries {
+		return nil
+	}
+
+	entries := make(map[uint16]exifEntry, count)
+	for i := 0; i < count; i++ {
+		pos := int(offset) + 2 + i*12
+		if pos+12 > len(r.b) {
+			break
+		}
+		entries[r.order.Uint16(r.b[pos:])] = exifEntry{
+			typ:   r.order.Uint16(r.b[pos+2:]),
+			count: r.order.Uint32(r.b[pos+4:]),
+			value: r.b[pos+8 : pos+12],
+		}
+	}
+	return entries
+}
+
+// data returns bytes of value of e, held by the entry if
+// they fit in 4 bytes, otherwise at the offset it holds
+func (r *exifReader) data(e exifEntry, size int) ([]byte, bool) {
+	n := int64(e.count) * int64(size)
+	if e.count == 0 || n > int64(len(r.b)) {
+		return nil, false
+	}
+	if n <= 4 {
+		return e.value[:n], true
+	}
+
+	offset := int64(r.order.Uint32(e.value))
+	if offset+n > int64(len(r.b)) {
+		return nil, false
+	}
+	return r.b[offset : offset+n], true
+}
+
+func (r *exifReader) ascii(e exifEntry) (string, bool) {
+	if e.typ != exifTypeASCII {
+		return "", false
+	}
+
+	b, ok := r.data(e, 1)
+	if !ok {
+		return "", false
+	}
+
+	v := strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+	return v, len(v) > 0
+}
+
+func (r *exifReader) uint(e exifEntry) (uint32, bool) {
+	switch e.typ {
+	case exifTypeShort:
+		if b, ok := r.data(e, 2); ok {
+			return uint32(r.order.Uint16(b)), true
+		}
+	case exifTypeLong:
+		if b, ok := r.data(e, 4); ok {
+			return r.order.Uint32(b), true
+		}
+	}
+	return 0, false
+}
+
+// coordinate returns degrees, minutes and seconds of e as decimal
+// degrees, negative if ref is neg
+func (r *exifReader) coordinate(e, ref exifEntry, neg string) (string, bool) {
+	if e.typ != exifTypeRational || e.count != 3 {
+		return "", false
+	}
+
+	b, ok := r.data(e, 8)
+	if !ok {
+		return "", false
+	}
+
+	v := 0.0
+	for i, unit := range []float64{1, 60, 3600} {
+		num, den := r.order.Uint32(b[i*8:]), r.order.Uint32(b[i*8+4:])
+		if den == 0 {
+			return "", false
+		}
+		v += float64(num) / float64(den) / unit
+	}
+
+	if s, ok := r.ascii(ref); ok && s == neg {
+		v = -v
+	}
+	return fmt.Sprintf("%.6f", v), true
+}
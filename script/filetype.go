@@ -1,21 +1,93 @@
 package script
 
-var supportedTypes = []func(buf []byte) bool{
-	func(buf []byte) bool {
-		return len(buf) > 3 && buf[0] == 0x89 && buf[1] == 0x50 && buf[2] == 0x4E && buf[3] == 0x47
-	},
-	func(buf []byte) bool {
-		return len(buf) > 2 && buf[0] == 0xFF && buf[1] == 0xD8 && buf[2] == 0xFF
-	},
+import (
+	"bytes"
+	"net/http"
+)
+
+const (
+	// MediaTypeJPEG media type of JPEG images
+	MediaTypeJPEG = "image/jpeg"
+
+	// MediaTypePNG media type of PNG images
+	MediaTypePNG = "image/png"
+
+	// MediaTypeGIF media type of GIF images
+	MediaTypeGIF = "image/gif"
+
+	// MediaTypeWebP media type of WebP images
+	MediaTypeWebP = "image/webp"
+
+	// MediaTypeBMP media type of BMP images
+	MediaTypeBMP = "image/bmp"
+
+	// MediaTypeTIFF media type of TIFF images
+	MediaTypeTIFF = "image/tiff"
+
+	// MediaTypeSVG media type of SVG images
+	MediaTypeSVG = "image/svg+xml"
+
+	// sniffLen number of bytes read to detect media type
+	sniffLen = 512
+)
+
+var mediaTypes = []struct {
+	mediaType string
+	match     func(buf []byte) bool
+}{
+	{MediaTypePNG, func(buf []byte) bool {
+		return bytes.HasPrefix(buf, []byte("\x89PNG\r\n\x1a\n"))
+	}},
+	{MediaTypeJPEG, func(buf []byte) bool {
+		return bytes.HasPrefix(buf, []byte("\xff\xd8\xff"))
+	}},
+	{MediaTypeGIF, func(buf []byte) bool {
+		return bytes.HasPrefix(buf, []byte("GIF87a")) || bytes.HasPrefix(buf, []byte("GIF89a"))
+	}},
+	{MediaTypeWebP, func(buf []byte) bool {
+		return len(buf) > 11 && bytes.HasPrefix(buf, []byte("RIFF")) && bytes.Equal(buf[8:12], []byte("WEBP"))
+	}},
+	{MediaTypeBMP, func(buf []byte) bool {
+		return len(buf) > 13 && bytes.HasPrefix(buf, []byte("BM"))
+	}},
+	{MediaTypeTIFF, func(buf []byte) bool {
+		return bytes.HasPrefix(buf, []byte("II*\x00")) || bytes.HasPrefix(buf, []byte("MM\x00*"))
+	}},
+	{MediaTypeSVG, isSVG},
+}
+
+// isSVG checks if buf starts an XML document, comment or doctype
+// and has an svg element in its first bytes
+func isSVG(buf []byte) bool {
+	if len(buf) > sniffLen {
+		buf = buf[:sniffLen]
+	}
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+	buf = bytes.TrimLeft(buf, " \t\r\n")
+	return bytes.HasPrefix(buf, []byte("<")) && bytes.Contains(bytes.ToLower(buf), []byte("<svg"))
+}
+
+// DetectMediaType returns media type of buf from its first bytes, other
+// content than images gets the type detected by net/http
+func DetectMediaType(buf []byte) string {
+	for _, t := range mediaTypes {
+		if t.match(buf) {
+			return t.mediaType
+		}
+	}
+
+	if len(buf) > sniffLen {
+		buf = buf[:sniffLen]
+	}
+	return http.DetectContentType(buf)
 }
 
 // IsSupportedFileType check if file is supported by sparrowdb
 // image manipulation library
 func IsSupportedFileType(buf []byte) bool {
-	for _, f := range supportedTypes {
-		if f(buf) == true {
-			return true
-		}
+	switch DetectMediaType(buf) {
+	case MediaTypePNG, MediaTypeJPEG, MediaTypeGIF:
+		return true
 	}
 	return false
 }
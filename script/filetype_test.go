@@ -0,0 +1,23 @@
+package script
+
+import "testing"
+
+func Test_DetectMediaType(t *testing.T) {
+	cases := map[string]string{
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR":                        MediaTypePNG,
+		"\xff\xd8\xff\xe0\x00\x10JFIF":                               MediaTypeJPEG,
+		"GIF89a\x01\x00\x01\x00":                                     MediaTypeGIF,
+		"RIFF\x24\x00\x00\x00WEBPVP8 ":                               MediaTypeWebP,
+		"BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00":         MediaTypeBMP,
+		"II*\x00\x08\x00\x00\x00":                                    MediaTypeTIFF,
+		"<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org\">": MediaTypeSVG,
+		"%PDF-1.4":         "application/pdf",
+		"\x00\x01\x02\x03": "application/octet-stream",
+	}
+
+	for content, expected := range cases {
+		if mt := DetectMediaType([]byte(content)); mt != expected {
+			t.Fatalf("expected %s, got %s for %q", expected, mt, content)
+		}
+	}
+}
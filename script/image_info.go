@@ -0,0 +1,134 @@
+package script
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+const (
+	// hashWidth and hashHeight size of the grid compared by
+	// perceptual hash, each row gives 8 bits
+	hashWidth  = 9
+	hashHeight = 8
+
+	// hashSamples max number of pixels read in each row and column
+	hashSamples = 256
+)
+
+// ImageInfo holds information read from an image when it is stored.
+// Hash is a perceptual hash, similar images have hashes with few
+// different bits
+type ImageInfo struct {
+	Width  int
+	Height int
+	Exif   map[string]string
+	Hash   uint64
+}
+
+// ReadInfo reads dimensions and EXIF from the first bytes of an image,
+// returns false if format of image can not be decoded
+func ReadInfo(head []byte) (*ImageInfo, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return nil, false
+	}
+
+	return &ImageInfo{
+		Width:  cfg.Width,
+		Height: cfg.Height,
+		Exif:   readExif(head),
+	}, true
+}
+
+// Orientation returns EXIF orientation of image, 1 if it is not rotated
+func (info *ImageInfo) Orientation() int {
+	if v, err := strconv.Atoi(info.Exif["orientation"]); err == nil {
+		return v
+	}
+	return 1
+}
+
+// Analyze decodes image b to compute its hash. If orient is true, image
+// is rotated by its EXIF orientation and returned encoded again, otherwise
+// b is returned. Image that can not be decoded has no hash
+func (info *ImageInfo) Analyze(b []byte, orient bool) []byte {
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return b
+	}
+
+	if orient && info.Orientation() > 1 {
+		si := &SparrowImage{Type: format, Img: img}
+		si.Orient(info.Orientation())
+
+		if nb, err := si.Encode(); err == nil {
+			b, img = nb, si.Img
+			info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+			info.Exif["orientation"] = "1"
+		}
+	}
+
+	info.Hash = perceptualHash(img)
+	return b
+}
+
+// Orient rotates and flips image as EXIF orientation
+// o requires to show it upright
+func (s *SparrowImage) Orient(o int) {
+	resize := &transform.RotationOptions{ResizeBounds: true}
+	switch o {
+	case 2:
+		s.Img = transform.FlipH(s.Img)
+	case 3:
+		s.Img = transform.Rotate(s.Img, 180, resize)
+	case 4:
+		s.Img = transform.FlipV(s.Img)
+	case 5:
+		s.Img = transform.FlipH(transform.Rotate(s.Img, 90, resize))
+	case 6:
+		s.Img = transform.Rotate(s.Img, 90, resize)
+	case 7:
+		s.Img = transform.FlipH(transform.Rotate(s.Img, 270, resize))
+	case 8:
+		s.Img = transform.Rotate(s.Img, 270, resize)
+	}
+}
+
+// perceptualHash returns difference hash of img, image is reduced to
+// a grid of mean luminance and each bit tells if a cell is brighter
+// than the next one in its row
+func perceptualHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Dx() < hashWidth || b.Dy() < hashHeight {
+		return 0
+	}
+
+	// pixels of large images are sampled
+	stepX, stepY := b.Dx()/hashSamples+1, b.Dy()/hashSamples+1
+
+	var sum [hashHeight][hashWidth]float64
+	var count [hashHeight][hashWidth]int
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		gy := (y - b.Min.Y) * hashHeight / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			gx := (x - b.Min.X) * hashWidth / b.Dx()
+			sum[gy][gx] += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			count[gy][gx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if sum[y][x]/float64(count[y][x]) > sum[y][x+1]/float64(count[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
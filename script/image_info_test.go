@@ -0,0 +1,95 @@
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// exifJPEG returns JPEG image of w x h with EXIF make Canon,
+// orientation 6 and latitude 40.5 N
+func exifJPEG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	b := new(bytes.Buffer)
+	if err := jpeg.Encode(b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := new(bytes.Buffer)
+	put := func(v ...interface{}) {
+		for _, x := range v {
+			binary.Write(tiff, binary.BigEndian, x)
+		}
+	}
+
+	// header and IFD0 at 8 with make, orientation and GPS IFD at 56
+	put([]byte("MM"), uint16(42), uint32(8))
+	put(uint16(3))
+	put(uint16(exifTagMake), uint16(exifTypeASCII), uint32(6), uint32(50))
+	put(uint16(exifTagOrientation), uint16(exifTypeShort), uint32(1), uint16(6), uint16(0))
+	put(uint16(exifTagGPSIFD), uint16(exifTypeLong), uint32(1), uint32(56))
+	put(uint32(0), []byte("Canon\x00"))
+
+	// GPS IFD with latitude at 86
+	put(uint16(2))
+	put(uint16(exifTagGPSLatitudeRef), uint16(exifTypeASCII), uint32(2), []byte("N\x00\x00\x00"))
+	put(uint16(exifTagGPSLatitude), uint16(exifTypeRational), uint32(3), uint32(86))
+	put(uint32(0), uint32(40), uint32(1), uint32(30), uint32(1), uint32(0), uint32(1))
+
+	out := new(bytes.Buffer)
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(out, binary.BigEndian, uint16(2+6+tiff.Len()))
+	out.WriteString("Exif\x00\x00")
+	out.Write(tiff.Bytes())
+	out.Write(b.Bytes()[2:])
+	return out.Bytes()
+}
+
+func Test_ReadInfo(t *testing.T) {
+	info, ok := ReadInfo(exifJPEG(t, 40, 20))
+	if !ok {
+		t.Fatal("image information not read")
+	}
+
+	if info.Width != 40 || info.Height != 20 {
+		t.Fatalf("expected 40x20, got %dx%d", info.Width, info.Height)
+	}
+	if info.Exif["make"] != "Canon" || info.Orientation() != 6 || info.Exif["gps_latitude"] != "40.500000" {
+		t.Fatalf("unexpected EXIF %v", info.Exif)
+	}
+
+	if _, ok := ReadInfo([]byte("not an image")); ok {
+		t.Fatal("information read from invalid image")
+	}
+}
+
+func Test_PerceptualHash(t *testing.T) {
+	gradient := func(w, h int, inverse bool) image.Image {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		for x := 0; x < w; x++ {
+			v := uint8(127 + 127*math.Cos(3*math.Pi*float64(x)/float64(w)))
+			if inverse {
+				v = 255 - v
+			}
+			for y := 0; y < h; y++ {
+				img.SetGray(x, y, color.Gray{Y: v})
+			}
+		}
+		return img
+	}
+
+	// same image in other size has the same hash
+	a, b := perceptualHash(gradient(90, 80, false)), perceptualHash(gradient(900, 800, false))
+	if a == 0 || a != b {
+		t.Fatalf("expected equal hashes, got %016x and %016x", a, b)
+	}
+
+	if c := perceptualHash(gradient(90, 80, true)); bits.OnesCount64(a^c) < 32 {
+		t.Fatalf("expected different hashes, got %016x and %016x", a, c)
+	}
+}
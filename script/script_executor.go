@@ -2,10 +2,11 @@ package script
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -26,48 +27,65 @@ func GetScriptPath() (string, error) {
 	return filepath.Join(pwd, "scripts"), nil
 }
 
-// Execute executes script that is in scripts folder
-func Execute(script, key string, b []byte) ([]byte, error) {
+// Execute executes script that is in scripts folder. Result is encoded in
+// the format of the image unless script sets another, returns the format
+func Execute(script, key string, b []byte) ([]byte, string, error) {
 	// check if image is supported
 	if IsSupportedFileType(b) == false {
-		return nil, errors.ErrNotSupportedFileType
+		return nil, "", errors.ErrNotSupportedFileType
 	}
 
-	// check script file
+	// image bytes to RGBA
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// register sparrowdb image effect
+	si := &SparrowImage{Name: key, Type: format, Img: img}
+	if err := si.run(script); err != nil {
+		return nil, "", err
+	}
+
+	nb, err := si.Encode()
+	if err != nil {
+		return nil, "", err
+	}
+	return nb, si.Type, nil
+}
+
+// readScript returns content of script in scripts folder
+func readScript(script string) ([]byte, error) {
 	sp, err := GetScriptPath()
 	if err != nil {
 		return nil, err
 	}
 
-	scriptpath := filepath.Join(sp, script+".lua")
-	if _, err := os.Stat(scriptpath); err != nil {
-		if os.IsNotExist(err) == false {
-			return nil, errors.ErrScriptNotExists
-		}
+	b, err := ioutil.ReadFile(filepath.Join(sp, script+".lua"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf(errors.ErrScriptNotExists.Error(), script)
+	}
+	return b, err
+}
+
+// run executes script over image
+func (s *SparrowImage) run(script string) error {
+	b, err := readScript(script)
+	if err != nil {
+		return err
 	}
+	return s.runSource(string(b))
+}
 
+// runSource executes script content over image
+func (s *SparrowImage) runSource(source string) error {
 	// lua interpreter
 	L := lua.NewState()
 	defer L.Close()
 
-	// image bytes to RGBA
-	img, _, err := image.Decode(bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-
 	// register pixel editor
 	registerRGBAType(L)
 
-	// register sparrowdb image effect
-	si := &SparrowImage{key, "png", img}
-	si.registerType(L)
-
-	if err := L.DoFile(scriptpath); err != nil {
-		return nil, err
-	}
-
-	nb := new(bytes.Buffer)
-	png.Encode(nb, si.Img)
-	return nb.Bytes(), nil
+	s.registerType(L)
+	return L.DoString(source)
 }
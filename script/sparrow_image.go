@@ -3,6 +3,7 @@ package script
 import (
 	"image"
 	"image/draw"
+	"strings"
 
 	"github.com/anthonynsimon/bild/blur"
 	"github.com/anthonynsimon/bild/effect"
@@ -15,11 +16,13 @@ const (
 	luaImageTypeInstanceName = "imageCtx"
 )
 
-// SparrowImage main image effects
+// SparrowImage main image effects, Type is the format
+// image is encoded in and Quality is used by jpeg
 type SparrowImage struct {
-	Name string
-	Type string
-	Img  image.Image
+	Name    string
+	Type    string
+	Quality int
+	Img     image.Image
 }
 
 func (s *SparrowImage) registerType(L *lua.LState) {
@@ -40,6 +43,8 @@ func (s *SparrowImage) registerType(L *lua.LState) {
 		"resize":        s.imgResize,
 		"crop":          s.imgCrop,
 		"setOutput":     s.imgSetOutput,
+		"setFormat":     s.imgSetFormat,
+		"setQuality":    s.imgSetQuality,
 	}))
 
 	// put an instance of SparrowImage with filled attrs in context
@@ -60,12 +65,32 @@ func (s *SparrowImage) getType(L *lua.LState) int {
 	return 1
 }
 
-func (s *SparrowImage) imgGrayscale(L *lua.LState) int {
+// Grayscale converts image to grayscale
+func (s *SparrowImage) Grayscale() {
 	src := effect.Grayscale(s.Img)
 	bounds := src.Bounds()
 	img := image.NewRGBA(bounds)
 	draw.Draw(img, bounds, src, bounds.Min, draw.Src)
 	s.Img = img
+}
+
+// Rotate rotates image by angle degrees
+func (s *SparrowImage) Rotate(angle float64) {
+	s.Img = transform.Rotate(s.Img, angle, nil)
+}
+
+// Resize resizes image to w x h pixels
+func (s *SparrowImage) Resize(w, h int) {
+	s.Img = transform.Resize(s.Img, w, h, transform.Linear)
+}
+
+// Crop crops image to rect
+func (s *SparrowImage) Crop(rect image.Rectangle) {
+	s.Img = transform.Crop(s.Img, rect)
+}
+
+func (s *SparrowImage) imgGrayscale(L *lua.LState) int {
+	s.Grayscale()
 	L.Push(lua.LBool(true))
 	return 1
 }
@@ -146,7 +171,7 @@ func (s *SparrowImage) imgFlipV(L *lua.LState) int {
 func (s *SparrowImage) imgRotate(L *lua.LState) int {
 	if L.GetTop() == 2 {
 		val := float64(L.Get(2).(lua.LNumber))
-		s.Img = transform.Rotate(s.Img, val, nil)
+		s.Rotate(val)
 		L.Push(lua.LBool(true))
 		return 1
 	}
@@ -158,7 +183,7 @@ func (s *SparrowImage) imgResize(L *lua.LState) int {
 	if L.GetTop() == 3 {
 		w := int(L.Get(2).(lua.LNumber))
 		h := int(L.Get(3).(lua.LNumber))
-		s.Img = transform.Resize(s.Img, w, h, transform.Linear)
+		s.Resize(w, h)
 		L.Push(lua.LBool(true))
 		return 1
 	}
@@ -172,7 +197,7 @@ func (s *SparrowImage) imgCrop(L *lua.LState) int {
 		y1 := int(L.Get(3).(lua.LNumber))
 		x2 := int(L.Get(4).(lua.LNumber))
 		y2 := int(L.Get(5).(lua.LNumber))
-		s.Img = transform.Crop(s.Img, image.Rect(x1, y1, x2, y2))
+		s.Crop(image.Rect(x1, y1, x2, y2))
 		L.Push(lua.LBool(true))
 		return 1
 	}
@@ -191,3 +216,32 @@ func (s *SparrowImage) imgSetOutput(L *lua.LState) int {
 	L.Push(lua.LBool(false))
 	return 0
 }
+
+func (s *SparrowImage) imgSetFormat(L *lua.LState) int {
+	if L.GetTop() == 2 {
+		if format, ok := FormatName(strings.ToLower(L.CheckString(2))); ok {
+			s.Type = format
+			L.Push(lua.LBool(true))
+			return 1
+		}
+	}
+	L.Push(lua.LBool(false))
+	return 1
+}
+
+func (s *SparrowImage) imgSetQuality(L *lua.LState) int {
+	if L.GetTop() == 2 {
+		if q := L.CheckInt(2); q >= 1 && q <= 100 {
+			s.Quality = q
+			L.Push(lua.LBool(true))
+			return 1
+		}
+	}
+	L.Push(lua.LBool(false))
+	return 1
+}
+
+// Encode encodes image in its format
+func (s *SparrowImage) Encode() ([]byte, error) {
+	return Encode(s.Img, s.Type, s.Quality)
+}
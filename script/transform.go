@@ -0,0 +1,190 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+const (
+	// MaxTransformSize max width or height in pixels of a resized image
+	MaxTransformSize = 4096
+
+	// MaxTransformSource max size in bytes of an image transformed when it is read
+	MaxTransformSource = 33554432
+
+	// MaxTransformPixels max number of pixels of an image transformed
+	// when it is read, checked before it is decoded
+	MaxTransformPixels = 40000000
+)
+
+var encoders = map[string]func(w io.Writer, img image.Image, quality int) error{
+	"png": func(w io.Writer, img image.Image, quality int) error {
+		return png.Encode(w, img)
+	},
+	"jpeg": func(w io.Writer, img image.Image, quality int) error {
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	},
+	"gif": func(w io.Writer, img image.Image, quality int) error {
+		return gif.Encode(w, img, nil)
+	},
+}
+
+// FormatName returns name of output format, jpg is the same as jpeg.
+// Returns false if format is not supported
+func FormatName(format string) (string, bool) {
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	_, ok := encoders[format]
+	return format, ok
+}
+
+// Encode encodes img in format, quality from 1 to 100 is used by jpeg
+func Encode(img image.Image, format string, quality int) ([]byte, error) {
+	enc, ok := encoders[format]
+	if !ok {
+		return nil, errors.ErrNotSupportedFileType
+	}
+
+	b := new(bytes.Buffer)
+	if err := enc(b, img, quality); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Transform holds operations applied to an image when it is read, in
+// the order crop, resize, rotate, grayscale and script. Format empty
+// keeps format of the image
+type Transform struct {
+	Crop      image.Rectangle
+	Width     int
+	Height    int
+	Rotate    float64
+	Grayscale bool
+	Script    string
+	Format    string
+	Quality   int
+
+	// SHA-256 of content of Script set by LoadScript, it
+	// changes when the script is saved again
+	ScriptHash string
+
+	// content of Script run by Apply, read by LoadScript
+	source string
+}
+
+// LoadScript reads content of Script, Apply runs this content and
+// ScriptHash identifies it in String
+func (t *Transform) LoadScript() error {
+	b, err := readScript(t.Script)
+	if err != nil {
+		return err
+	}
+	t.source = string(b)
+	t.ScriptHash = fmt.Sprintf("%x", sha256.Sum256(b))
+	return nil
+}
+
+// IsEmpty returns true if no operation is requested
+func (t *Transform) IsEmpty() bool {
+	return *t == Transform{}
+}
+
+// String returns operations of t, used as cache key of transformed images
+func (t *Transform) String() string {
+	return fmt.Sprintf("crop=%d,%d,%d,%d&w=%d&h=%d&rotate=%g&gray=%t&script=%s@%s&format=%s&quality=%d",
+		t.Crop.Min.X, t.Crop.Min.Y, t.Crop.Max.X, t.Crop.Max.Y, t.Width, t.Height,
+		t.Rotate, t.Grayscale, t.Script, t.ScriptHash, t.Format, t.Quality)
+}
+
+// Apply decodes image read from r and returns it transformed by t
+func (t *Transform) Apply(key string, r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxTransformSource+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxTransformSource {
+		return nil, errors.ErrTransformTooLarge
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.ErrNotSupportedFileType
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxTransformPixels {
+		return nil, errors.ErrTransformTooLarge
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.ErrNotSupportedFileType
+	}
+
+	si := &SparrowImage{Name: key, Type: format, Img: img}
+	if !t.Crop.Empty() {
+		si.Crop(t.Crop)
+	}
+	if t.Width > 0 || t.Height > 0 {
+		w, h := scaleSize(si.Img.Bounds().Size(), t.Width, t.Height)
+		si.Resize(w, h)
+	}
+	if t.Rotate != 0 {
+		si.Rotate(t.Rotate)
+	}
+	if t.Grayscale {
+		si.Grayscale()
+	}
+	if len(t.Script) > 0 && len(t.source) == 0 {
+		if err := t.LoadScript(); err != nil {
+			return nil, err
+		}
+	}
+	if len(t.Script) > 0 {
+		if err := si.runSource(t.source); err != nil {
+			return nil, err
+		}
+	}
+
+	// requested format and quality override the ones set by script
+	if len(t.Format) > 0 {
+		si.Type = t.Format
+	}
+	if t.Quality > 0 {
+		si.Quality = t.Quality
+	}
+	return si.Encode()
+}
+
+// scaleSize returns w x h, a missing side is scaled
+// to keep aspect ratio of size
+func scaleSize(size image.Point, w, h int) (int, int) {
+	if size.X <= 0 || size.Y <= 0 {
+		return 1, 1
+	}
+	if w <= 0 {
+		w = size.X * h / size.Y
+	}
+	if h <= 0 {
+		h = size.Y * w / size.X
+	}
+	if w <= 0 {
+		w = 1
+	}
+	if h <= 0 {
+		h = 1
+	}
+	return w, h
+}
@@ -0,0 +1,107 @@
+package script
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SparrowDb/sparrowdb/errors"
+)
+
+func Test_Transform(t *testing.T) {
+	b := new(bytes.Buffer)
+	if err := png.Encode(b, image.NewRGBA(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &Transform{Width: 50, Grayscale: true, Format: "jpeg", Quality: 80}
+	out, err := tr.Apply("key", bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Fatalf("expected jpeg, got %s", format)
+	}
+	if size := img.Bounds().Size(); size.X != 50 || size.Y != 25 {
+		t.Fatalf("expected 50x25, got %v", size)
+	}
+
+	// crop is applied before resize
+	tr = &Transform{Crop: image.Rect(0, 0, 100, 100), Height: 10}
+	if out, err = tr.Apply("key", bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if img, format, err = image.Decode(bytes.NewReader(out)); err != nil || format != "png" {
+		t.Fatalf("expected png, got %s %v", format, err)
+	}
+	if size := img.Bounds().Size(); size.X != 10 || size.Y != 10 {
+		t.Fatalf("expected 10x10, got %v", size)
+	}
+
+	if tr.String() == (&Transform{Crop: image.Rect(0, 0, 100, 100), Height: 20}).String() {
+		t.Fatal("different transforms must have different cache keys")
+	}
+}
+
+func Test_TransformTooLarge(t *testing.T) {
+	b := new(bytes.Buffer)
+	if err := gif.Encode(b, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 65535x65535 logical screen, rejected before pixels are decoded
+	header := b.Bytes()
+	copy(header[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+
+	tr := &Transform{Width: 50}
+	if _, err := tr.Apply("key", bytes.NewReader(header)); err != errors.ErrTransformTooLarge {
+		t.Fatalf("expected image rejected by its size, got %v", err)
+	}
+}
+
+func Test_TransformScriptHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("scripts", 0755)
+
+	save := func(content string) string {
+		if err := ioutil.WriteFile(filepath.Join("scripts", "effect.lua"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tr := &Transform{Script: "effect"}
+		if err := tr.LoadScript(); err != nil {
+			t.Fatal(err)
+		}
+		return tr.String()
+	}
+
+	// saving script again changes cache key of the images it transforms
+	if save("-- first") == save("-- second") {
+		t.Fatal("script content must be part of cache key")
+	}
+
+	tr := &Transform{Script: "missing"}
+	if err := tr.LoadScript(); err == nil {
+		t.Fatal("expected missing script rejected")
+	}
+}
@@ -25,6 +25,30 @@ func SetLogger(lo Logger) {
 	log = lo
 }
 
+// Default returns Logger that prints with the logger set by SetLogger,
+// it is used when no Logger is given
+func Default() Logger {
+	return defaultLogger{}
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Infof(format string, args ...interface{}) {
+	log.Infof(format, args...)
+}
+
+func (defaultLogger) Warnf(format string, args ...interface{}) {
+	log.Warnf(format, args...)
+}
+
+func (defaultLogger) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+}
+
+func (defaultLogger) Fatalf(format string, args ...interface{}) {
+	log.Fatalf(format, args...)
+}
+
 // Infof prints INFO message
 func Infof(format string, args ...interface{}) {
 	log.Infof(format, args...)
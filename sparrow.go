@@ -10,7 +10,6 @@ import (
 	"syscall"
 
 	"github.com/SparrowDb/sparrowdb/auth"
-	"github.com/SparrowDb/sparrowdb/compression"
 	"github.com/SparrowDb/sparrowdb/db"
 	"github.com/SparrowDb/sparrowdb/http"
 	"github.com/SparrowDb/sparrowdb/service"
@@ -28,7 +27,7 @@ var (
 	totalProcs      = runtime.NumCPU()
 	configPathFlag  = flag.String("config", "./config/", "Description")
 	configProcsFlag = flag.Int("j", totalProcs, "Description")
-	instance        = &Instance{}
+	instance        = &Instance{keyring: auth.NewKeyring()}
 )
 
 // Instance holds SparrowDb instance configuration
@@ -37,6 +36,7 @@ type Instance struct {
 	sparrowConfig  *db.SparrowConfig
 	databaseConfig *db.DatabaseConfig
 	dbManager      *db.DBManager
+	keyring        *auth.Keyring
 	httpServer     http.HTTPServer
 	httpUI         web.UIServer
 	serviceManager service.Manager
@@ -58,13 +58,17 @@ func init() {
 	createPIDfile()
 
 	slog.SetLogger(slog.NewGlog())
-	compression.SetCompressor(compression.NewSnappyCompressor())
 
 	// Configure signal handler
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
 	go handleSignal(c)
+
+	// signing keys are loaded again on SIGHUP to rotate them
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadSigningKeys(hup)
 }
 
 func handleSignal(c chan os.Signal) {
@@ -74,6 +78,16 @@ func handleSignal(c chan os.Signal) {
 	os.Exit(1)
 }
 
+func reloadSigningKeys(c chan os.Signal) {
+	for range c {
+		if err := instance.keyring.Load(*configPathFlag); err != nil {
+			slog.Errorf("Could not reload signing keys: %s", err)
+			continue
+		}
+		slog.Infof("Signing keys reloaded")
+	}
+}
+
 func createPIDfile() {
 	p := strconv.Itoa(instance.pid)
 	ioutil.WriteFile("sparrow.pid", []byte(p), 0644)
@@ -98,14 +112,16 @@ func main() {
 	slog.Infof("Database read-only: %v", instance.sparrowConfig.ReadOnly)
 
 	auth.LoadUserConfig(*configPathFlag, instance.sparrowConfig)
+	if err := instance.keyring.Load(*configPathFlag); err != nil {
+		slog.Fatalf(err.Error())
+	}
 
 	instance.serviceManager = service.NewManager()
 
-	instance.dbManager = db.NewDBManager(instance.sparrowConfig, instance.databaseConfig)
+	instance.httpServer = http.NewHTTPServerWithOptions(instance.sparrowConfig, instance.databaseConfig, instance.keyring, db.Options{})
+	instance.dbManager = instance.httpServer.DBManager()
 	instance.dbManager.LoadDatabases()
 	instance.serviceManager.AddService("dbManager", instance.dbManager)
-
-	instance.httpServer = http.NewHTTPServer(instance.sparrowConfig, instance.dbManager)
 	instance.serviceManager.AddService("httpServer", &instance.httpServer)
 
 	if instance.sparrowConfig.EnableWebUI {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -11,10 +12,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"encoding/json"
 
+	"github.com/SparrowDb/sparrowdb/client"
 	"github.com/SparrowDb/sparrowdb/slog"
 )
 
@@ -34,6 +35,9 @@ var (
 const (
 	version = "1.0.0"
 
+	// batchSize number of images of a folder sent in one request
+	batchSize = 100
+
 	contentTypeJSON = "application/json"
 	contentTypeForm = "multipart/form-data"
 )
@@ -138,20 +142,57 @@ func cmdSendFolder(dbname, path string) {
 		slog.Fatalf(err.Error())
 	}
 
-	var wg sync.WaitGroup
-
+	names := make([]string, 0)
 	for _, f := range files {
-		ext := filepath.Ext(f.Name())
-		if _, ok := allowedImages[ext]; ok {
-			wg.Add(1)
-			go func(dbname, imgName, path, ext string) {
-				cmdSend(dbname, strings.Replace(imgName, ext, "", 1), filepath.Join(path, imgName))
-				wg.Done()
-			}(dbname, f.Name(), path, ext)
+		if _, ok := allowedImages[filepath.Ext(f.Name())]; ok {
+			names = append(names, f.Name())
+		}
+	}
+
+	// images are sent in batches, each one in one request
+	c := client.New(address, client.Options{})
+	for len(names) > 0 {
+		n := batchSize
+		if len(names) < n {
+			n = len(names)
 		}
+		sendBatch(c, dbname, path, names[:n])
+		names = names[n:]
 	}
+}
 
-	wg.Wait()
+func sendBatch(c *client.Client, dbname, path string, names []string) {
+	items := make([]client.BatchItem, 0, len(names))
+	for _, imgName := range names {
+		f, err := os.Open(filepath.Join(path, imgName))
+		if err != nil {
+			slog.Fatalf(err.Error())
+		}
+		defer f.Close()
+
+		items = append(items, client.BatchItem{
+			Key:  strings.TrimSuffix(imgName, filepath.Ext(imgName)),
+			Data: f,
+			PutOptions: client.PutOptions{
+				Filename: imgName,
+				Upsert:   *flagImageUpsert,
+				Script:   *flagImageScript,
+			},
+		})
+	}
+
+	results, err := c.PutBatch(context.Background(), dbname, items, false)
+	if err != nil {
+		slog.Fatalf(err.Error())
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			slog.Errorf("[%s] %s", r.Key, r.Err)
+		} else {
+			slog.Infof("[%s] revision %d", r.Key, r.Revision)
+		}
+	}
 }
 
 func cmdDelete() {
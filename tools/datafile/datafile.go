@@ -5,16 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/SparrowDb/sparrowdb/db"
+	"github.com/SparrowDb/sparrowdb/db/index"
+	"github.com/SparrowDb/sparrowdb/errors"
 	"github.com/SparrowDb/sparrowdb/model"
 	"github.com/SparrowDb/sparrowdb/slog"
 	"github.com/SparrowDb/sparrowdb/util/uuid"
 )
 
 var (
-	flagDataFilePath = flag.String("path", "", "Data file path (data holder or commitlog)")
+	flagDataFilePath  = flag.String("path", "", "Data file path (data holder or commitlog)")
+	flagBloomFilterFp = flag.Float64("fpp", 0.001, "Bloom filter false positive probability used when it is rebuilt")
 )
 
 const (
@@ -23,41 +27,62 @@ const (
 )
 
 func processDataHolder(path string) {
-	dataFile, err := db.OpenDataHolder(path)
+	dataFile, err := db.OpenDataHolder(path, float32(*flagBloomFilterFp), nil)
 	if err != nil {
 		slog.Fatalf(err.Error())
 	}
 
-	summary := dataFile.GetSummary()
 	dfs := make([]*model.DataDefinition, 0)
 
-	for _, entry := range summary.GetTable() {
+	dataFile.GetIndex().Iterate(func(entry *index.Entry) bool {
 		bs, err := dataFile.Get(entry.Offset)
-		if err != nil {
-			slog.Warnf(err.Error())
+		if err != nil || bs == nil {
+			slog.Warnf(errors.ErrFileCorrupted.Error(), path)
+			return true
 		}
 		df := model.NewDataDefinitionFromByteStream(bs)
 		dfs = append(dfs, df)
-	}
+		return true
+	})
 	printTable(dfs)
 }
 
 func processCommitlog(path string) {
 	path = path + ".." + string(filepath.Separator)
 	cl := db.NewCommitLog(path)
-	cl.LoadData()
+	if err := cl.LoadData(); err != nil {
+		slog.Fatalf(err.Error())
+	}
 
 	summary := cl.GetSummary()
 	dfs := make([]*model.DataDefinition, 0)
 
 	for _, entry := range summary.GetTable() {
-		bs := cl.GetByHash(entry.Key)
+		bs := cl.Get(entry.Key)
 		df := model.NewDataDefinitionFromByteStream(bs)
 		dfs = append(dfs, df)
 	}
 	printTable(dfs)
 }
 
+func repairDataHolder(path string) {
+	report, err := db.RepairDataHolder(path, float32(*flagBloomFilterFp))
+	if err != nil {
+		slog.Fatalf(err.Error())
+	}
+
+	slog.Infof("Records indexed: %d", report.Records)
+
+	if len(report.Unreadable) == 0 {
+		slog.Infof("No unreadable data found")
+		return
+	}
+
+	for _, r := range report.Unreadable {
+		slog.Warnf("Unreadable bytes %d-%d (%d bytes)", r.Start, r.End, r.End-r.Start)
+	}
+}
+
 func printTable(dfs []*model.DataDefinition) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, tabSpaceSep, tabwriter.AlignRight|tabwriter.Debug)
 	fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%v\t%s\t%s\t%s", "Key", "Ext", "Size", "Status", "Revision", "Timestamp"))
@@ -93,9 +118,20 @@ func main() {
 
 	slog.Infof("Data file: %s", abspath)
 
-	if dirInfo.Name() == "commitlog" {
-		processCommitlog(*flagDataFilePath)
-	} else {
-		processDataHolder(*flagDataFilePath)
+	switch strings.ToLower(flag.Arg(0)) {
+	case "", "show":
+		if dirInfo.Name() == "commitlog" {
+			processCommitlog(*flagDataFilePath)
+		} else {
+			processDataHolder(*flagDataFilePath)
+		}
+	case "repair":
+		// commitlog is recovered by SparrowDB when database is loaded
+		if dirInfo.Name() == "commitlog" {
+			slog.Fatalf("Repair is only available for data holders")
+		}
+		repairDataHolder(*flagDataFilePath)
+	default:
+		flag.Usage()
 	}
 }
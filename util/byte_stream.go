@@ -98,6 +98,12 @@ func (bs *ByteStream) GetUInt32() uint32 {
 	return y
 }
 
+// PeekUInt32 returns next uint32 from ByteStream without
+// moving current position
+func (bs *ByteStream) PeekUInt32() uint32 {
+	return currentEndianess.Uint32(bs.buf[bs.cur : bs.cur+uint32Size])
+}
+
 // GetUInt64 returns uint64 from ByteStream
 func (bs *ByteStream) GetUInt64() uint64 {
 	x := bs.buf[bs.cur : bs.cur+uint64Size]